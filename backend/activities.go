@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/fiscal"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// Storage активити хранилища, которые вызывают воркфлоу. Воркер регистрирует
+// postgres.Postgres или memory.Storage для запуска без базы.
+type Storage interface {
+	GetUserData(ctx context.Context, userID uuid.UUID) (postgres.UserData, error)
+	GetItemsData(ctx context.Context, itemIDs []uuid.UUID) ([]postgres.ItemData, error)
+	GetPointData(ctx context.Context, pointID uuid.UUID) (postgres.PointData, error)
+	CreateOrder(ctx context.Context, params postgres.OrderParams) error
+	ChangeOrderStatus(ctx context.Context, params postgres.ChangeOrderStatusParams) error
+	PublishOrderChanges(ctx context.Context, orderID uuid.UUID, outbox postgres.Outbox) error
+	LogUnsuccessfulPayment(ctx context.Context, pp postgres.LogUnsuccessfulPaymentParams) error
+	LogAttemptToEnterWrongPINCode(ctx context.Context, pp postgres.LogAttemptToEnterWrongPINCodeParams) error
+	ReserveGiftCard(ctx context.Context, params postgres.ReserveGiftCardParams) (postgres.ReserveGiftCardResult, error)
+	FinalizeGiftCardRedemption(ctx context.Context, orderID uuid.UUID) error
+	ReleaseGiftCardReservation(ctx context.Context, orderID uuid.UUID) error
+	SaveReceipt(ctx context.Context, params postgres.SaveReceiptParams) error
+	StartCooking(ctx context.Context, params postgres.StartCookingParams) error
+	MarkItemCooked(ctx context.Context, params postgres.MarkItemCookedParams) error
+	HandOverOrder(ctx context.Context, params postgres.HandOverOrderParams) error
+	CreateZReport(ctx context.Context, params postgres.CreateZReportParams) (*postgres.ZReportResponse, error)
+}
+
+// Fiscal активити фискального регистратора.
+type Fiscal interface {
+	RegisterReceipt(ctx context.Context, receipt fiscal.Receipt) (fiscal.Registration, error)
+}
+
+// activityStub нужен воркфлоу только ради имён активити: темпорал берёт имя из
+// метода, а вызывает его у того, что зарегистрировано в воркере. Сами методы
+// заглушки никогда не вызываются.
+type activityStub struct {
+	Storage
+	Fiscal
+}
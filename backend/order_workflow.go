@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -11,18 +12,20 @@ import (
 	"go.temporal.io/sdk/workflow"
 
 	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/fiscal"
+	"github.com/krocos/coffee-shop/money"
 	"github.com/krocos/coffee-shop/postgres"
 	"github.com/krocos/coffee-shop/sse"
 )
 
 const (
-	orderStatusWaitingForPayment = "waiting_for_payment"
-	orderStatusPaymentTimeout    = "payment_timeout"
-	orderStatusPaid              = "paid"
-	orderStatusPaymentCanceled   = "payment_canceled"
-	orderStatusCooking           = "cooking"
-	orderStatusReady             = "ready"
-	orderStatusReceived          = "received"
+	orderStatusWaitingForPayment = postgres.OrderStatusWaitingForPayment
+	orderStatusPaymentTimeout    = postgres.OrderStatusPaymentTimeout
+	orderStatusPaid              = postgres.OrderStatusPaid
+	orderStatusPaymentCanceled   = postgres.OrderStatusPaymentCanceled
+	orderStatusCooking           = postgres.OrderStatusCooking
+	orderStatusReady             = postgres.OrderStatusReady
+	orderStatusReceived          = postgres.OrderStatusReceived
 )
 
 const (
@@ -32,16 +35,26 @@ const (
 )
 
 const (
-	cacheOrderStatusCooking = "cooking"
-	cacheOrderStatusReady   = "ready"
+	cacheOrderStatusCooking = postgres.CacheOrderStatusCooking
+	cacheOrderStatusReady   = postgres.CacheOrderStatusReady
+)
+
+// Кто вызвал смену статуса заказа.
+const (
+	orderActorUser           = postgres.OrderActorUser
+	orderActorPaymentGateway = postgres.OrderActorPaymentGateway
+	orderActorKitchen        = postgres.OrderActorKitchen
+	orderActorCache          = postgres.OrderActorCache
+	orderActorSystem         = postgres.OrderActorSystem
 )
 
 type (
 	OrderInitialData struct {
-		ID      uuid.UUID
-		UserID  uuid.UUID
-		PointID uuid.UUID
-		Items   []ItemInitialData
+		ID           uuid.UUID
+		UserID       uuid.UUID
+		PointID      uuid.UUID
+		Items        []ItemInitialData
+		GiftCardCode string
 	}
 	ItemInitialData struct {
 		ID       uuid.UUID
@@ -54,13 +67,23 @@ type (
 		id         uuid.UUID
 		createdAt  time.Time
 		status     string
-		totalPrice float64
+		totalPrice int64
+		currency   string
+		vatAmount  int64
 		pinCode    string
 
+		giftCardCode   string
+		giftCardAmount int64
+
+		// searchVersion растёт с каждой записью в поиск. Эластик отбрасывает
+		// записи со старой версией, например запоздавший ретрай активити.
+		searchVersion int64
+
 		user       *User
 		orderItems []*OrderItem
 		point      *Point
 		logs       []*LogItem
+		timeline   []*TimelineEvent
 	}
 	User struct {
 		id   uuid.UUID
@@ -75,16 +98,24 @@ type (
 	OrderItem struct {
 		id         uuid.UUID
 		title      string
-		price      float64
+		price      int64
 		itemID     uuid.UUID
 		quantity   float64
-		totalPrice float64
+		totalPrice int64
+		vatRate    int
+		vatAmount  int64
 		ready      bool
 	}
 	LogItem struct {
 		id   uuid.UUID
 		Text string
 	}
+	TimelineEvent struct {
+		status  string
+		at      time.Time
+		actor   string
+		payload json.RawMessage
+	}
 )
 
 func (d *OrderInitialData) itemQuantity(itemID uuid.UUID) float64 {
@@ -109,9 +140,43 @@ type (
 	}
 )
 
+// OrderStateQuery запрос текущего состояния заказа у воркфлоу, отвечает OrderState.
+const OrderStateQuery = "order_state"
+
+// OrderState то, что воркфлоу знает о заказе. По нему сверяются база и поиск.
+type OrderState struct {
+	ID             uuid.UUID
+	Status         string
+	TotalPrice     int64
+	GiftCardAmount int64
+	LogItems       int
+	SearchVersion  int64
+}
+
+func OrderWorkflowID(orderID uuid.UUID) string {
+	return fmt.Sprintf("order:%s", orderID.String())
+}
+
 func OrderWorkflow(ctx workflow.Context, initialData OrderInitialData) error {
 
 	processing := newOrderProcessing(ctx, initialData.ID)
+	processing.order.giftCardCode = initialData.GiftCardCode
+
+	// Заказы, начатые до поисковых атрибутов, доживают без них.
+	processing.withSearchAttributes = workflow.GetVersion(ctx, orderSearchAttributes, workflow.DefaultVersion, 1) == 1
+
+	if err := workflow.SetQueryHandler(ctx, OrderStateQuery, func() (OrderState, error) {
+		return OrderState{
+			ID:             processing.order.id,
+			Status:         processing.order.status,
+			TotalPrice:     processing.order.totalPrice,
+			GiftCardAmount: processing.order.giftCardAmount,
+			LogItems:       len(processing.order.logs),
+			SearchVersion:  processing.order.searchVersion,
+		}, nil
+	}); err != nil {
+		return err
+	}
 
 	ao := workflow.ActivityOptions{StartToCloseTimeout: time.Hour}
 	ctx = workflow.WithActivityOptions(ctx, ao)
@@ -129,6 +194,10 @@ func OrderWorkflow(ctx workflow.Context, initialData OrderInitialData) error {
 		return nil
 	}
 
+	if err := processing.issueReceipt(ctx); err != nil {
+		return err
+	}
+
 	if err := processing.launchCookingOnPoint(ctx); err != nil {
 		return err
 	}
@@ -148,12 +217,57 @@ func OrderWorkflow(ctx workflow.Context, initialData OrderInitialData) error {
 	return nil
 }
 
+// statusEvent запоминает в истории заказа, что он только что перешёл в текущий
+// статус, details подробности смены.
+func (p *orderProcessing) statusEvent(ctx workflow.Context, actor string, details map[string]interface{}) postgres.OrderEventParams {
+	// Строки и числа маршалятся без ошибок.
+	payload, _ := json.Marshal(details)
+	if details == nil {
+		payload = json.RawMessage("{}")
+	}
+
+	event := &TimelineEvent{
+		status:  p.order.status,
+		at:      workflow.Now(ctx).In(p.loc),
+		actor:   actor,
+		payload: payload,
+	}
+	p.order.timeline = append(p.order.timeline, event)
+
+	return postgres.OrderEventParams{
+		Status:  event.status,
+		At:      event.at,
+		Actor:   event.actor,
+		Payload: event.payload,
+	}
+}
+
+// searchTimeline история статусов для документа в поиске.
+func (p *orderProcessing) searchTimeline() []*elasticsearch.TimelineEvent {
+	timeline := make([]*elasticsearch.TimelineEvent, 0)
+	for _, event := range p.order.timeline {
+		timeline = append(timeline, &elasticsearch.TimelineEvent{
+			Status:  event.status,
+			At:      event.at.Format(time.RFC3339),
+			Actor:   event.actor,
+			Payload: event.payload,
+		})
+	}
+	return timeline
+}
+
+func (o *Order) nextSearchVersion() int64 {
+	o.searchVersion++
+	return o.searchVersion
+}
+
 type orderProcessing struct {
 	loc *time.Location
 
-	storage    *postgres.Postgres
-	sseService *sse.SSE
-	search     *elasticsearch.Search
+	withSearchAttributes bool
+
+	storage       Storage
+	fiscalService Fiscal
 
 	order *Order
 }
@@ -162,7 +276,9 @@ func newOrderProcessing(ctx workflow.Context, orderID uuid.UUID) *orderProcessin
 	loc, _ := time.LoadLocation("Asia/Yekaterinburg")
 
 	return &orderProcessing{
-		loc: loc,
+		loc:           loc,
+		storage:       activityStub{},
+		fiscalService: activityStub{},
 		order: &Order{
 			id:        orderID,
 			createdAt: workflow.Now(ctx).In(loc),
@@ -203,13 +319,25 @@ func (p *orderProcessing) prepareOrder(ctx workflow.Context, initialData OrderIn
 
 		quantity := initialData.itemQuantity(data.ID)
 
+		// Все позиции заказа должны быть в одной валюте, иначе общую сумму не посчитать.
+		if p.order.currency == "" {
+			p.order.currency = data.Currency
+		}
+		if p.order.currency != data.Currency {
+			return fmt.Errorf("item %s has currency %s, but order has %s", data.ID, data.Currency, p.order.currency)
+		}
+
+		totalPrice := money.LineTotal(data.Price, quantity)
+
 		p.order.orderItems = append(p.order.orderItems, &OrderItem{
 			id:         orderItemID,
 			title:      data.Title,
 			price:      data.Price,
 			itemID:     data.ID,
 			quantity:   quantity,
-			totalPrice: data.Price * quantity,
+			totalPrice: totalPrice,
+			vatRate:    data.VATRate,
+			vatAmount:  money.IncludedVAT(totalPrice, data.VATRate),
 		})
 	}
 
@@ -236,10 +364,13 @@ func (p *orderProcessing) prepareOrder(ctx workflow.Context, initialData OrderIn
 
 	// Назначаем оредеру статус, что ожидает оплаты.
 	p.order.status = orderStatusWaitingForPayment
+	createdEvent := p.statusEvent(ctx, orderActorUser, nil)
 
-	// Считаем общую сумму заказа.
+	// Считаем общую сумму заказа и НДС в ней. НДС заказа это сумма НДС позиций,
+	// что бы не расходиться с чеком на копейку из-за округления.
 	for _, item := range p.order.orderItems {
 		p.order.totalPrice += item.totalPrice
+		p.order.vatAmount += item.vatAmount
 	}
 
 	// Записываем ордер в базу для длинной истории.
@@ -248,10 +379,13 @@ func (p *orderProcessing) prepareOrder(ctx workflow.Context, initialData OrderIn
 		CreatedAt:  p.order.createdAt,
 		Status:     p.order.status,
 		TotalPrice: p.order.totalPrice,
+		Currency:   p.order.currency,
+		VATAmount:  p.order.vatAmount,
 		PINCode:    p.order.pinCode,
 		UserID:     p.order.user.id,
 		PointID:    p.order.point.id,
 		Items:      make([]postgres.OrderItemParams, 0),
+		Event:      createdEvent,
 	}
 	for _, orderItem := range p.order.orderItems {
 		createOrderParams.Items = append(createOrderParams.Items, postgres.OrderItemParams{
@@ -261,11 +395,10 @@ func (p *orderProcessing) prepareOrder(ctx workflow.Context, initialData OrderIn
 			ItemID:     orderItem.itemID,
 			Quantity:   orderItem.quantity,
 			TotalPrice: orderItem.totalPrice,
+			VATRate:    orderItem.vatRate,
+			VATAmount:  orderItem.vatAmount,
 		})
 	}
-	if err := workflow.ExecuteActivity(ctx, p.storage.CreateOrder, createOrderParams).Get(ctx, nil); err != nil {
-		return err
-	}
 
 	// Индексируем ордер для быстрой выдачи на клиент пользователя (истоиря ордеров бесконечно пополняется).
 
@@ -274,6 +407,8 @@ func (p *orderProcessing) prepareOrder(ctx workflow.Context, initialData OrderIn
 		CreatedAt:  p.order.createdAt.Format(time.RFC3339),
 		Status:     p.order.status,
 		TotalPrice: p.order.totalPrice,
+		Currency:   p.order.currency,
+		VATAmount:  p.order.vatAmount,
 		PINCode:    p.order.pinCode,
 		User: &elasticsearch.User{
 			ID:   p.order.user.id.String(),
@@ -295,30 +430,60 @@ func (p *orderProcessing) prepareOrder(ctx workflow.Context, initialData OrderIn
 			ItemID:     item.itemID.String(),
 			Quantity:   item.quantity,
 			TotalPrice: item.totalPrice,
+			VATRate:    item.vatRate,
+			VATAmount:  item.vatAmount,
 			OrderID:    p.order.id.String(),
 		})
 	}
 
-	for _, logItem := range p.order.logs {
-		searchOrder.LogItems = append(searchOrder.LogItems, &elasticsearch.LogItem{
-			ID:      logItem.id.String(),
-			Text:    logItem.Text,
-			OrderID: p.order.id.String(),
-		})
+	searchOrder.LogItems = p.searchLogs()
+	searchOrder.Timeline = p.searchTimeline()
+	searchOrder.Version = p.order.nextSearchVersion()
+
+	// Заказ, документ для поиска и уведомление пользователя, что заказ создан и
+	// ожидает оплаты, пишем одной транзакцией, дальше их разнесёт релей outbox.
+	createOrderParams.Outbox = postgres.Outbox{
+		IndexOrder:    &searchOrder,
+		Notifications: []sse.Event{p.userOrderListUpdated()},
 	}
 
-	if err := workflow.ExecuteActivity(ctx, p.search.IndexOrder, p.order.id, searchOrder, true).Get(ctx, nil); err != nil {
+	if err := workflow.ExecuteActivity(ctx, p.storage.CreateOrder, createOrderParams).Get(ctx, nil); err != nil {
 		return err
 	}
 
-	// Уведомляем клиента пользователя, что заказ создан и ожидает оплаты.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewOrderListUpdatedEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
+	return p.upsertSearchAttributes(ctx)
+}
 
-		return err
+// searchLogs логи заказа для документа в поиске.
+func (p *orderProcessing) searchLogs() []*elasticsearch.LogItem {
+	logs := make([]*elasticsearch.LogItem, 0)
+	for _, l := range p.order.logs {
+		logs = append(logs, &elasticsearch.LogItem{
+			ID:      l.id.String(),
+			Text:    l.Text,
+			OrderID: p.order.id.String(),
+		})
 	}
+	return logs
+}
 
-	return nil
+func (p *orderProcessing) userOrderListUpdated() sse.Event {
+	return sse.NewOrderListUpdatedEvent().ForUser().WithID(p.order.user.id)
+}
+
+// statusOutbox обновление статуса в поиске и уведомления, сначала переданные,
+// последним пользователю.
+func (p *orderProcessing) statusOutbox(notifications ...sse.Event) postgres.Outbox {
+	return postgres.Outbox{
+		UpdateOrder: &postgres.OutboxOrderUpdate{
+			Version: p.order.nextSearchVersion(),
+			Doc: &elasticsearch.Order{
+				Status:   p.order.status,
+				Timeline: p.searchTimeline(),
+			},
+		},
+		Notifications: append(notifications, p.userOrderListUpdated()),
+	}
 }
 
 // processPayment ожидает сигнала об оплате от платёжного интегратора, таймаута
@@ -328,6 +493,16 @@ func (p *orderProcessing) processPayment(ctx workflow.Context) error {
 
 	paymentSignals := workflow.GetSignalChannel(ctx, "payment_signals")
 
+	// Если пользователь указал подарочную карту, то сначала резервируем на ней сумму
+	// заказа. Если карта покрывает весь заказ, то ждать платёжного интегратора не нужно.
+	if err := p.reserveGiftCard(ctx); err != nil {
+		return err
+	}
+
+	if p.order.giftCardAmount > 0 && p.order.giftCardAmount == p.order.totalPrice {
+		p.order.status = orderStatusPaid
+	}
+
 	for {
 		if p.order.status != orderStatusWaitingForPayment {
 			break
@@ -380,55 +555,179 @@ func (p *orderProcessing) processPayment(ctx workflow.Context) error {
 				Text: text,
 			})
 
-			// Добавляем запись лога в базу данных.
+			// Добавляем запись лога в базу данных, обновляем логи в индексе и
+			// уведомляем пользователя о новых логах.
 			if err := workflow.ExecuteActivity(ctx, p.storage.LogUnsuccessfulPayment, postgres.LogUnsuccessfulPaymentParams{
 				ID:      logID,
 				OrderID: p.order.id,
 				Reason:  text,
+				Outbox: postgres.Outbox{
+					UpdateOrder: &postgres.OutboxOrderUpdate{
+						Version: p.order.nextSearchVersion(),
+						Doc:     &elasticsearch.Order{LogItems: p.searchLogs()},
+					},
+					Notifications: []sse.Event{sse.NewUnsuccessfulPayAttemptEvent().ForUser().WithID(p.order.user.id)},
+				},
 			}).Get(ctx, nil); err != nil {
 				return err
 			}
 
-			// Обновляем логи в индексе.
+			unsuccessfulPaymentReason = ""
+		}
+	}
 
-			logs := make([]*elasticsearch.LogItem, 0)
-			for _, l := range p.order.logs {
-				logs = append(logs, &elasticsearch.LogItem{
-					ID:      l.id.String(),
-					Text:    l.Text,
-					OrderID: p.order.id.String(),
-				})
-			}
+	// Запоминаем, кто и как закрыл оплату, пока не ушло время на активити.
+	actor := orderActorPaymentGateway
+	details := make(map[string]interface{})
+	switch {
+	case p.order.status == orderStatusPaymentTimeout:
+		actor = orderActorSystem
+	case p.order.status == orderStatusPaid && p.order.giftCardAmount == p.order.totalPrice:
+		actor = orderActorSystem
+	}
+	if p.order.giftCardAmount > 0 {
+		details["gift_card_amount"] = p.order.giftCardAmount
+	}
+	event := p.statusEvent(ctx, actor, details)
 
-			if err := workflow.ExecuteActivity(ctx, p.search.UpdateOrder, p.order.id, &elasticsearch.Order{LogItems: logs}, true).Get(ctx, nil); err != nil {
-				return err
-			}
+	// Резерв на подарочной карте списываем, если заказ оплачен, иначе возвращаем на карту.
+	if p.order.giftCardAmount > 0 {
+		settle := p.storage.ReleaseGiftCardReservation
+		if p.order.status == orderStatusPaid {
+			settle = p.storage.FinalizeGiftCardRedemption
+		}
 
-			// Уведомляем пользователя о новых логах.
-			if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-				sse.NewUnsuccessfulPayAttemptEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
+		if err := workflow.ExecuteActivity(ctx, settle, p.order.id).Get(ctx, nil); err != nil {
+			return err
+		}
+	}
 
-				return err
-			}
+	// Записываем измеение статуса ордера в базу данных для клинета пользователя,
+	// в индекс и уведомляем клиента пользователя.
+	if err := workflow.ExecuteActivity(ctx, p.storage.ChangeOrderStatus, postgres.ChangeOrderStatusParams{
+		OrderID: p.order.id,
+		Event:   event,
+		Outbox:  p.statusOutbox(),
+	}).Get(ctx, nil); err != nil {
+		return err
+	}
 
-			unsuccessfulPaymentReason = ""
-		}
+	return p.upsertSearchAttributes(ctx)
+}
+
+// reserveGiftCard резервирует сумму заказа на подарочной карте. Если карту нельзя
+// использовать, то пользователь увидит причину в логах заказа и оплатит весь заказ.
+func (p *orderProcessing) reserveGiftCard(ctx workflow.Context) error {
+	if p.order.giftCardCode == "" {
+		return nil
 	}
 
-	// Записываем измеение статуса ордера в базу данных для клинета пользователя.
-	if err := workflow.ExecuteActivity(ctx, p.storage.UpdateOrderStatus, p.order.id, p.order.status).Get(ctx, nil); err != nil {
+	var result postgres.ReserveGiftCardResult
+	if err := workflow.ExecuteActivity(ctx, p.storage.ReserveGiftCard, postgres.ReserveGiftCardParams{
+		Code:     p.order.giftCardCode,
+		OrderID:  p.order.id,
+		Amount:   p.order.totalPrice,
+		Currency: p.order.currency,
+		At:       workflow.Now(ctx),
+	}).Get(ctx, &result); err != nil {
 		return err
 	}
 
-	// Ообновляем статус заказа в индексе.
-	if err := workflow.ExecuteActivity(ctx, p.search.UpdateOrder, p.order.id, &elasticsearch.Order{Status: p.order.status}, true).Get(ctx, nil); err != nil {
+	p.order.giftCardAmount = result.Amount
+
+	if result.Reason == "" {
+		// Сумма по карте уже записана в заказ резервом, осталось обновить её в
+		// индексе и уведомить клиента пользователя, что заказ изменился.
+		return workflow.ExecuteActivity(ctx, p.storage.PublishOrderChanges, p.order.id, postgres.Outbox{
+			UpdateOrder: &postgres.OutboxOrderUpdate{
+				Version: p.order.nextSearchVersion(),
+				Doc:     &elasticsearch.Order{GiftCardAmount: p.order.giftCardAmount},
+			},
+			Notifications: []sse.Event{p.userOrderListUpdated()},
+		}).Get(ctx, nil)
+	}
+
+	var (
+		logID uuid.UUID
+		text  = fmt.Sprintf("Подарочная карта: %s", result.Reason)
+	)
+
+	// Создаём новый идентификатор для записи лога.
+	if err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return uuid.New()
+	}).Get(&logID); err != nil {
+		return err
+	}
+
+	p.order.logs = append(p.order.logs, &LogItem{
+		id:   logID,
+		Text: text,
+	})
+
+	// Отказ по подарочной карте для пользователя то же самое, что неудачная попытка оплаты.
+	return workflow.ExecuteActivity(ctx, p.storage.LogUnsuccessfulPayment, postgres.LogUnsuccessfulPaymentParams{
+		ID:      logID,
+		OrderID: p.order.id,
+		Reason:  text,
+		Outbox: postgres.Outbox{
+			UpdateOrder: &postgres.OutboxOrderUpdate{
+				Version: p.order.nextSearchVersion(),
+				Doc: &elasticsearch.Order{
+					GiftCardAmount: p.order.giftCardAmount,
+					LogItems:       p.searchLogs(),
+				},
+			},
+			Notifications: []sse.Event{p.userOrderListUpdated()},
+		},
+	}).Get(ctx, nil)
+}
+
+// issueReceipt формирует чек оплаченного заказа, регистрирует его в фискальном
+// регистраторе и сохраняет для выдачи пользователю.
+func (p *orderProcessing) issueReceipt(ctx workflow.Context) error {
+	receipt := fiscal.Receipt{
+		OrderID:    p.order.id.String(),
+		IssuedAt:   workflow.Now(ctx).In(p.loc),
+		PointAddr:  p.order.point.addr,
+		UserName:   p.order.user.name,
+		Currency:   p.order.currency,
+		Lines:      make([]*fiscal.ReceiptLine, 0),
+		TotalPrice: p.order.totalPrice,
+		VATAmount:  p.order.vatAmount,
+
+		GiftCardAmount: p.order.giftCardAmount,
+	}
+	for _, item := range p.order.orderItems {
+		receipt.Lines = append(receipt.Lines, &fiscal.ReceiptLine{
+			Title:      item.title,
+			Price:      item.price,
+			Quantity:   item.quantity,
+			TotalPrice: item.totalPrice,
+			VATRate:    item.vatRate,
+			VATAmount:  item.vatAmount,
+		})
+	}
+
+	// Регистрируем чек в фискальном регистраторе.
+	var registration fiscal.Registration
+	if err := workflow.ExecuteActivity(ctx, p.fiscalService.RegisterReceipt, receipt).Get(ctx, &registration); err != nil {
 		return err
 	}
 
-	// Уведомляем клиента пользователя, что статус заказа изменился.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewOrderListUpdatedEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
+	receipt.Registration = &registration
+
+	document, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
 
+	// Сохраняем чек, что бы пользователь мог его получить.
+	if err = workflow.ExecuteActivity(ctx, p.storage.SaveReceipt, postgres.SaveReceiptParams{
+		OrderID:      p.order.id,
+		IssuedAt:     receipt.IssuedAt,
+		FiscalNumber: registration.FiscalNumber,
+		Document:     document,
+	}).Get(ctx, nil); err != nil {
 		return err
 	}
 
@@ -451,16 +750,6 @@ func (p *orderProcessing) launchCookingOnPoint(ctx workflow.Context) error {
 			Quantity: item.quantity,
 		})
 	}
-	if err := workflow.ExecuteActivity(ctx, p.storage.AddItemsForKitchen, cookingParams).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Уведомляем клиент кухни точки, что появились новые итемы на приготовку.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewItemListUpdatedEvent().ForKitchen().WithID(p.order.point.kitchenID)).Get(ctx, nil); err != nil {
-
-		return err
-	}
 
 	// Записываем в заказах кассы точки, что появился новый ордер и он отдан на кухню точки, что бы
 	// оператор кассы знал что заказ готовится, что бы говорить с клиентом, если он рано явился.
@@ -470,58 +759,52 @@ func (p *orderProcessing) launchCookingOnPoint(ctx workflow.Context) error {
 		checkList = append(checkList, fmt.Sprintf("%s %.0f шт.", item.title, item.quantity))
 	}
 
-	if err := workflow.ExecuteActivity(ctx, p.storage.AddNewOrderForCache, postgres.AddNewOrderForCacheParams{
-		ID:               p.order.id,
-		CacheID:          p.order.point.cacheID,
-		OrderID:          p.order.id,
-		UserName:         p.order.user.name,
-		Status:           cacheOrderStatusCooking,
-		ReadinessPercent: 0,
-		CheckList:        strings.Join(checkList, ", "),
-	}).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Уведомляем клиент кассы точки, что есть готовящийся заказ.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewOrderListUpdatedEvent().ForCache().WithID(p.order.point.cacheID)).Get(ctx, nil); err != nil {
-
-		return err
-	}
-
 	p.order.status = orderStatusCooking
 
-	// Изменить статус заказа для клиента пользователя, что заказ готовится.
-	if err := workflow.ExecuteActivity(ctx, p.storage.UpdateOrderStatus, p.order.id, p.order.status).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Ообновляем статус заказа в индексе.
-	if err := workflow.ExecuteActivity(ctx, p.search.UpdateOrder, p.order.id, &elasticsearch.Order{Status: p.order.status}, true).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Уведомить клиент пользователя, что изменился статус заказа.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewOrderListUpdatedEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
-
+	// Кухня, касса и статус заказа меняются одной транзакцией. Клиенты кухни,
+	// кассы и пользователя узнают об этом от релея outbox.
+	if err := workflow.ExecuteActivity(ctx, p.storage.StartCooking, postgres.StartCookingParams{
+		OrderID: p.order.id,
+		Kitchen: cookingParams,
+		Cache: postgres.AddNewOrderForCacheParams{
+			ID:               p.order.id,
+			CacheID:          p.order.point.cacheID,
+			OrderID:          p.order.id,
+			UserName:         p.order.user.name,
+			Status:           cacheOrderStatusCooking,
+			ReadinessPercent: 0,
+			CheckList:        strings.Join(checkList, ", "),
+		},
+		Event: p.statusEvent(ctx, orderActorSystem, nil),
+		Outbox: p.statusOutbox(
+			sse.NewItemListUpdatedEvent().ForKitchen().WithID(p.order.point.kitchenID),
+			sse.NewOrderListUpdatedEvent().ForCache().WithID(p.order.point.cacheID),
+		),
+	}).Get(ctx, nil); err != nil {
 		return err
 	}
 
-	return nil
+	return p.upsertSearchAttributes(ctx)
 }
 
 // waitForCooking ожидание готовности заказа.
 func (p *orderProcessing) waitForCooking(ctx workflow.Context) error {
 	cookingSignals := workflow.GetSignalChannel(ctx, "cooking_signals")
 
+	// Раньше сигнал о чужой или уже готовой позиции тоже уходил в MarkItemCooked,
+	// для чужой с пустым идентификатором.
+	skipStale := workflow.GetVersion(ctx, skipStaleCookingSignals, workflow.DefaultVersion, 1) == 1
+
 	for {
 		// Тут сделано через селектор, хотя, можно было бы просто слушать
 		// канал игналов, но так будет проще потом улучшить петлю сигналов.
 
 		cookingSelector := workflow.NewSelector(ctx)
 
-		var cookedOrderItemID uuid.UUID
+		var (
+			cookedOrderItemID uuid.UUID
+			stale             = true
+		)
 
 		cookingSelector.AddReceive(cookingSignals, func(ch workflow.ReceiveChannel, more bool) {
 			var s CookingSignal
@@ -529,6 +812,7 @@ func (p *orderProcessing) waitForCooking(ctx workflow.Context) error {
 
 			for _, orderItem := range p.order.orderItems {
 				if orderItem.id.String() == s.OrderItemID.String() {
+					stale = orderItem.ready
 					orderItem.ready = true
 					cookedOrderItemID = s.OrderItemID
 				}
@@ -537,6 +821,10 @@ func (p *orderProcessing) waitForCooking(ctx workflow.Context) error {
 
 		cookingSelector.Select(ctx)
 
+		if stale && skipStale {
+			continue
+		}
+
 		var (
 			ready    int
 			notReady int
@@ -558,63 +846,47 @@ func (p *orderProcessing) waitForCooking(ctx workflow.Context) error {
 			readyPercent = ready * 100 / (ready + notReady)
 		}
 
-		// Удаляем приготовленный итем с кухни, что бы не отображался на экране клиента кухни.
-		if err := workflow.ExecuteActivity(ctx, p.storage.RemoveKitchenCookItemAsReady, cookedOrderItemID).Get(ctx, nil); err != nil {
-			return err
-		}
-
-		// Уведомляем клиент кухни, что бы перезагузил заказы.
-		if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-			sse.NewItemListUpdatedEvent().ForKitchen().WithID(p.order.point.kitchenID)).Get(ctx, nil); err != nil {
-
-			return err
-		}
-
-		// Обновляем процент готовности на кассе.
-		if err := workflow.ExecuteActivity(ctx, p.storage.UpdateCacheOrderReadinessPercent, p.order.id, readyPercent).Get(ctx, nil); err != nil {
-			return err
-		}
-
+		cacheOrderStatus := cacheOrderStatusCooking
 		if readyPercent == 100 {
-			if err := workflow.ExecuteActivity(ctx, p.storage.UpdateCacheOrderStatus, p.order.id, cacheOrderStatusReady).Get(ctx, nil); err != nil {
-				return err
-			}
+			cacheOrderStatus = cacheOrderStatusReady
+			p.order.status = orderStatusReady
 		}
 
-		// Уведомляем клиент кассы, что изменился процент готовности заказа.
-		if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-			sse.NewOrderListUpdatedEvent().ForCache().WithID(p.order.point.cacheID)).Get(ctx, nil); err != nil {
-
-			return err
-		}
-
-		if readyPercent != 100 {
-			continue
+		// Отмечаем итем приготовленным, что бы не отображался на экране клиента
+		// кухни, и обновляем процент готовности на кассе.
+		params := postgres.MarkItemCookedParams{
+			OrderID:          p.order.id,
+			OrderItemID:      cookedOrderItemID,
+			ReadinessPercent: readyPercent,
+			CacheOrderStatus: cacheOrderStatus,
+			At:               workflow.Now(ctx).In(p.loc),
+			Outbox: postgres.Outbox{
+				Notifications: []sse.Event{
+					sse.NewItemListUpdatedEvent().ForKitchen().WithID(p.order.point.kitchenID),
+					sse.NewOrderListUpdatedEvent().ForCache().WithID(p.order.point.cacheID),
+				},
+			},
 		}
 
-		p.order.status = orderStatusReady
-
 		// Если заказ готов, то обновляем статус заказа для клиента пользователя.
-		if err := workflow.ExecuteActivity(ctx, p.storage.UpdateOrderStatus, p.order.id, p.order.status).Get(ctx, nil); err != nil {
-			return err
+		if p.order.status == orderStatusReady {
+			event := p.statusEvent(ctx, orderActorKitchen, map[string]interface{}{
+				"order_item_id": cookedOrderItemID.String(),
+			})
+			params.Event = &event
+			params.Outbox = p.statusOutbox(params.Outbox.Notifications...)
 		}
 
-		// Ообновляем статус заказа в индексе.
-		if err := workflow.ExecuteActivity(ctx, p.search.UpdateOrder, p.order.id, &elasticsearch.Order{Status: p.order.status}, true).Get(ctx, nil); err != nil {
+		if err := workflow.ExecuteActivity(ctx, p.storage.MarkItemCooked, params).Get(ctx, nil); err != nil {
 			return err
 		}
 
-		// Уведомляем клиент пользователя, что статус заказа изменился.
-		if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-			sse.NewOrderListUpdatedEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
-
-			return err
+		if readyPercent == 100 {
+			break
 		}
-
-		break
 	}
 
-	return nil
+	return p.upsertSearchAttributes(ctx)
 }
 
 func (p *orderProcessing) giveAway(ctx workflow.Context) error {
@@ -656,70 +928,37 @@ func (p *orderProcessing) giveAway(ctx workflow.Context) error {
 			Text: text,
 		})
 
-		// Логируем, что была неудачная попытка ввести пинкод.
+		// Логируем, что была неудачная попытка ввести пинкод, обновляем логи в
+		// индексе и отправляем уведомление.
 		if err := workflow.ExecuteActivity(ctx, p.storage.LogAttemptToEnterWrongPINCode, postgres.LogAttemptToEnterWrongPINCodeParams{
 			ID:      logID,
 			Reason:  text,
 			OrderID: p.order.id,
+			Outbox: postgres.Outbox{
+				UpdateOrder: &postgres.OutboxOrderUpdate{
+					Version: p.order.nextSearchVersion(),
+					Doc:     &elasticsearch.Order{LogItems: p.searchLogs()},
+				},
+				Notifications: []sse.Event{sse.NewAttemptToEnterWrongPINCodeEvent().ForUser().WithID(p.order.user.id)},
+			},
 		}).Get(ctx, nil); err != nil {
 			return err
 		}
-
-		// Обновляем логи в индексе.
-
-		logs := make([]*elasticsearch.LogItem, 0)
-		for _, l := range p.order.logs {
-			logs = append(logs, &elasticsearch.LogItem{
-				ID:      l.id.String(),
-				Text:    l.Text,
-				OrderID: p.order.id.String(),
-			})
-		}
-
-		if err := workflow.ExecuteActivity(ctx, p.search.UpdateOrder, p.order.id, &elasticsearch.Order{LogItems: logs}, true).Get(ctx, nil); err != nil {
-			return err
-		}
-
-		// Отправляем уведомление.
-		if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-			sse.NewAttemptToEnterWrongPINCodeEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
-
-			return err
-		}
 	}
 
 	return nil
 }
 
 func (p *orderProcessing) cleanUp(ctx workflow.Context) error {
-	// Убираем заказ из списка закакоз для кассы.
-	if err := workflow.ExecuteActivity(ctx, p.storage.RemoveCacheOrderAsReady, p.order.id).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Уведомляем кассу, что бы обновила список заказов.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewOrderListUpdatedEvent().ForCache().WithID(p.order.point.cacheID)).Get(ctx, nil); err != nil {
-
-		return err
-	}
-
-	// Обновляем статус заказа для клиента пользователя.
-	if err := workflow.ExecuteActivity(ctx, p.storage.UpdateOrderStatus, p.order.id, p.order.status).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Ообновляем статус заказа в индексе.
-	if err := workflow.ExecuteActivity(ctx, p.search.UpdateOrder, p.order.id, &elasticsearch.Order{Status: p.order.status}, true).Get(ctx, nil); err != nil {
-		return err
-	}
-
-	// Уведомляем клиент пользователя, что статус заказа изменился.
-	if err := workflow.ExecuteActivity(ctx, p.sseService.SendNotification,
-		sse.NewOrderListUpdatedEvent().ForUser().WithID(p.order.user.id)).Get(ctx, nil); err != nil {
-
+	// Убираем заказ из списка закакоз для кассы и обновляем статус заказа для
+	// клиента пользователя. Кассу и пользователя уведомит релей outbox.
+	if err := workflow.ExecuteActivity(ctx, p.storage.HandOverOrder, postgres.HandOverOrderParams{
+		OrderID: p.order.id,
+		Event:   p.statusEvent(ctx, orderActorCache, nil),
+		Outbox:  p.statusOutbox(sse.NewOrderListUpdatedEvent().ForCache().WithID(p.order.point.cacheID)),
+	}).Get(ctx, nil); err != nil {
 		return err
 	}
 
-	return nil
+	return p.upsertSearchAttributes(ctx)
 }
@@ -0,0 +1,493 @@
+package backend
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/krocos/coffee-shop/fiscal"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// Активити мокаются по имени, заглушки нужны только что бы его взять.
+var (
+	storage       Storage = activityStub{}
+	fiscalService Fiscal  = activityStub{}
+)
+
+type OrderWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+
+	initialData OrderInitialData
+	userData    postgres.UserData
+	itemsData   []postgres.ItemData
+	pointData   postgres.PointData
+
+	// Что воркфлоу передал в активити, пинкод и идентификаторы позиций
+	// создаются в нём самом.
+	created  postgres.OrderParams
+	cooked   []postgres.MarkItemCookedParams
+	statuses []string
+}
+
+func TestOrderWorkflow(t *testing.T) {
+	suite.Run(t, new(OrderWorkflowTestSuite))
+}
+
+func (s *OrderWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+
+	s.userData = postgres.UserData{ID: uuid.New(), Name: "Иван Иванович"}
+	s.itemsData = []postgres.ItemData{
+		{ID: uuid.New(), Title: "Латте", Price: 9550, Currency: "RUB", VATRate: 20},
+		{ID: uuid.New(), Title: "Пончики", Price: 4995, Currency: "RUB", VATRate: 10},
+	}
+	s.pointData = postgres.PointData{
+		ID:        uuid.New(),
+		Addr:      "Банковский переулок 10",
+		KitchenID: uuid.New(),
+		CacheID:   uuid.New(),
+	}
+	s.initialData = OrderInitialData{
+		ID:      uuid.New(),
+		UserID:  s.userData.ID,
+		PointID: s.pointData.ID,
+		Items: []ItemInitialData{
+			{ID: s.itemsData[0].ID, Quantity: 2},
+			{ID: s.itemsData[1].ID, Quantity: 1},
+		},
+	}
+
+	s.created = postgres.OrderParams{}
+	s.cooked = nil
+	s.statuses = nil
+}
+
+func (s *OrderWorkflowTestSuite) TearDownTest() {
+	s.env.AssertExpectations(s.T())
+}
+
+// totalPrice 2 латте и пончики.
+const totalPrice = 2*9550 + 4995
+
+func (s *OrderWorkflowTestSuite) onPrepareOrder() {
+	s.env.OnActivity(storage.GetUserData, mock.Anything, s.userData.ID).Return(s.userData, nil)
+	s.env.OnActivity(storage.GetItemsData, mock.Anything, []uuid.UUID{s.itemsData[0].ID, s.itemsData[1].ID}).Return(s.itemsData, nil)
+	s.env.OnActivity(storage.GetPointData, mock.Anything, s.pointData.ID).Return(s.pointData, nil)
+	s.env.OnActivity(storage.CreateOrder, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		s.created = args.Get(1).(postgres.OrderParams)
+		s.statuses = append(s.statuses, s.created.Event.Status)
+	})
+}
+
+func (s *OrderWorkflowTestSuite) onChangeOrderStatus() {
+	s.env.OnActivity(storage.ChangeOrderStatus, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		s.statuses = append(s.statuses, args.Get(1).(postgres.ChangeOrderStatusParams).Event.Status)
+	})
+}
+
+// onPaidOrder всё, что вызывается после оплаты до выдачи заказа.
+func (s *OrderWorkflowTestSuite) onPaidOrder() {
+	s.env.OnActivity(fiscalService.RegisterReceipt, mock.Anything, mock.Anything).Return(fiscal.Registration{FiscalNumber: "0001"}, nil)
+	s.env.OnActivity(storage.SaveReceipt, mock.Anything, mock.Anything).Return(nil)
+	s.env.OnActivity(storage.StartCooking, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		s.statuses = append(s.statuses, args.Get(1).(postgres.StartCookingParams).Event.Status)
+	})
+	s.env.OnActivity(storage.MarkItemCooked, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		params := args.Get(1).(postgres.MarkItemCookedParams)
+		s.cooked = append(s.cooked, params)
+		if params.Event != nil {
+			s.statuses = append(s.statuses, params.Event.Status)
+		}
+	})
+	s.env.OnActivity(storage.HandOverOrder, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		s.statuses = append(s.statuses, args.Get(1).(postgres.HandOverOrderParams).Event.Status)
+	})
+}
+
+// onUpsertOrderSearchAttributes собирает атрибуты заказа, которые пишет
+// воркфлоу. Через этот же мок идут и атрибуты версий от GetVersion, их
+// пропускаем.
+func (s *OrderWorkflowTestSuite) onUpsertOrderSearchAttributes() *[]map[string]interface{} {
+	upserted := make([]map[string]interface{}, 0)
+	s.env.OnUpsertSearchAttributes(mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		attributes := args.Get(0).(map[string]interface{})
+		if _, ok := attributes[SearchAttributeOrderStatus]; ok {
+			upserted = append(upserted, attributes)
+		}
+	})
+	return &upserted
+}
+
+func (s *OrderWorkflowTestSuite) payAt(after time.Duration, status, reason string) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow("payment_signals", PaymentSignal{Status: status, Reason: reason})
+	}, after)
+}
+
+// cookAt отмечает позицию заказа приготовленной, идентификаторы позиций
+// известны только после CreateOrder.
+func (s *OrderWorkflowTestSuite) cookAt(after time.Duration, item int) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow("cooking_signals", CookingSignal{OrderItemID: s.created.Items[item].ID})
+	}, after)
+}
+
+func (s *OrderWorkflowTestSuite) receiveAt(after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow("receive_signals", ReceiveSignal{PINCode: s.created.PINCode})
+	}, after)
+}
+
+func (s *OrderWorkflowTestSuite) receiveWithWrongPINAt(after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		pinCode := "0000"
+		if s.created.PINCode == pinCode {
+			pinCode = "0001"
+		}
+		s.env.SignalWorkflow("receive_signals", ReceiveSignal{PINCode: pinCode})
+	}, after)
+}
+
+func (s *OrderWorkflowTestSuite) orderState() OrderState {
+	value, err := s.env.QueryWorkflow(OrderStateQuery)
+	s.Require().NoError(err)
+
+	var state OrderState
+	s.Require().NoError(value.Get(&state))
+	return state
+}
+
+func (s *OrderWorkflowTestSuite) execute() {
+	s.env.ExecuteWorkflow(OrderWorkflow, s.initialData)
+	s.True(s.env.IsWorkflowCompleted())
+}
+
+func (s *OrderWorkflowTestSuite) TestCompleted() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+	s.cookAt(5*time.Minute, 0)
+	s.cookAt(6*time.Minute, 1)
+	s.receiveAt(10 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Equal([]string{
+		orderStatusWaitingForPayment,
+		orderStatusPaid,
+		orderStatusCooking,
+		orderStatusReady,
+		orderStatusReceived,
+	}, s.statuses)
+
+	s.Equal(int64(totalPrice), s.created.TotalPrice)
+	s.Equal("RUB", s.created.Currency)
+	s.Len(s.created.PINCode, 4)
+
+	s.env.AssertCalled(s.T(), "RegisterReceipt", mock.Anything, mock.MatchedBy(func(receipt fiscal.Receipt) bool {
+		return receipt.OrderID == s.initialData.ID.String() && receipt.TotalPrice == totalPrice && len(receipt.Lines) == 2
+	}))
+	s.env.AssertCalled(s.T(), "SaveReceipt", mock.Anything, mock.MatchedBy(func(params postgres.SaveReceiptParams) bool {
+		return params.OrderID == s.initialData.ID && params.FiscalNumber == "0001"
+	}))
+}
+
+func (s *OrderWorkflowTestSuite) TestSearchAttributes() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+
+	upserted := s.onUpsertOrderSearchAttributes()
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+	s.cookAt(5*time.Minute, 0)
+	s.cookAt(6*time.Minute, 1)
+	s.receiveAt(10 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	statuses := make([]string, 0)
+	for _, attributes := range *upserted {
+		statuses = append(statuses, attributes[SearchAttributeOrderStatus].(string))
+	}
+	s.Equal(s.statuses, statuses)
+
+	s.Equal(map[string]interface{}{
+		SearchAttributeOrderStatus: orderStatusReceived,
+		SearchAttributePointID:     s.pointData.ID.String(),
+		SearchAttributeKitchenID:   s.pointData.KitchenID.String(),
+		SearchAttributeCacheID:     s.pointData.CacheID.String(),
+		SearchAttributeUserID:      s.userData.ID.String(),
+		SearchAttributeTotalPrice:  int64(totalPrice),
+	}, (*upserted)[len(*upserted)-1])
+}
+
+// TestSearchAttributesBeforeVersion заказы, начатые до orderSearchAttributes,
+// атрибуты не пишут.
+func (s *OrderWorkflowTestSuite) TestSearchAttributesBeforeVersion() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.env.OnGetVersion(orderSearchAttributes, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	upserted := s.onUpsertOrderSearchAttributes()
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Equal([]string{orderStatusWaitingForPayment, orderStatusPaymentTimeout}, s.statuses)
+	s.Empty(*upserted)
+}
+
+func (s *OrderWorkflowTestSuite) TestPaymentTimeout() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Equal([]string{orderStatusWaitingForPayment, orderStatusPaymentTimeout}, s.statuses)
+	s.env.AssertCalled(s.T(), "ChangeOrderStatus", mock.Anything, mock.MatchedBy(func(params postgres.ChangeOrderStatusParams) bool {
+		return params.Event.Actor == orderActorSystem && params.Event.At.Sub(s.created.CreatedAt) == time.Hour
+	}))
+}
+
+func (s *OrderWorkflowTestSuite) TestPaymentCanceled() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+
+	s.payAt(time.Minute, paymentSignalCanceled, "")
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Equal([]string{orderStatusWaitingForPayment, orderStatusPaymentCanceled}, s.statuses)
+	s.env.AssertCalled(s.T(), "ChangeOrderStatus", mock.Anything, mock.MatchedBy(func(params postgres.ChangeOrderStatusParams) bool {
+		return params.Event.Actor == orderActorPaymentGateway
+	}))
+}
+
+func (s *OrderWorkflowTestSuite) TestUnsuccessfulThenSuccessfulPayment() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+	s.env.OnActivity(storage.LogUnsuccessfulPayment, mock.Anything, mock.MatchedBy(func(params postgres.LogUnsuccessfulPaymentParams) bool {
+		return params.OrderID == s.initialData.ID && params.Reason == "Оплата: недостаточно средств"
+	})).Return(nil).Once()
+
+	s.payAt(time.Minute, paymentSignalUnsuccessful, "недостаточно средств")
+	s.env.RegisterDelayedCallback(func() {
+		state := s.orderState()
+		s.Equal(orderStatusWaitingForPayment, state.Status)
+		s.Equal(1, state.LogItems)
+	}, 2*time.Minute)
+	// Таймер оплаты после неудачной попытки заводится заново, поэтому час от
+	// создания заказа уже не таймаут.
+	s.payAt(time.Hour+30*time.Second, paymentSignalSuccessful, "")
+	s.cookAt(65*time.Minute, 0)
+	s.cookAt(66*time.Minute, 1)
+	s.receiveAt(70 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Equal([]string{
+		orderStatusWaitingForPayment,
+		orderStatusPaid,
+		orderStatusCooking,
+		orderStatusReady,
+		orderStatusReceived,
+	}, s.statuses)
+}
+
+func (s *OrderWorkflowTestSuite) TestPartialCooking() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+	s.cookAt(5*time.Minute, 1)
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(orderStatusCooking, s.orderState().Status)
+	}, 10*time.Minute)
+	// Повторный сигнал о той же позиции и сигнал о чужой пропускаются.
+	s.cookAt(15*time.Minute, 1)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow("cooking_signals", CookingSignal{OrderItemID: uuid.New()})
+	}, 17*time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(orderStatusCooking, s.orderState().Status)
+	}, 20*time.Minute)
+	s.cookAt(25*time.Minute, 0)
+	s.receiveAt(30 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Require().Len(s.cooked, 2)
+
+	s.Equal(s.created.Items[1].ID, s.cooked[0].OrderItemID)
+	s.Equal(50, s.cooked[0].ReadinessPercent)
+	s.Equal(cacheOrderStatusCooking, s.cooked[0].CacheOrderStatus)
+	s.Nil(s.cooked[0].Event)
+
+	s.Equal(s.created.Items[0].ID, s.cooked[1].OrderItemID)
+	s.Equal(100, s.cooked[1].ReadinessPercent)
+	s.Equal(cacheOrderStatusReady, s.cooked[1].CacheOrderStatus)
+	s.Require().NotNil(s.cooked[1].Event)
+	s.Equal(orderActorKitchen, s.cooked[1].Event.Actor)
+}
+
+// TestStaleCookingSignalsBeforeSkip заказы, начатые до skipStaleCookingSignals,
+// отмечают повторный сигнал как раньше.
+func (s *OrderWorkflowTestSuite) TestStaleCookingSignalsBeforeSkip() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+	s.env.OnGetVersion(skipStaleCookingSignals, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+	s.cookAt(5*time.Minute, 1)
+	s.cookAt(15*time.Minute, 1)
+	s.cookAt(25*time.Minute, 0)
+	s.receiveAt(30 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Require().Len(s.cooked, 3)
+	s.Equal(s.created.Items[1].ID, s.cooked[1].OrderItemID)
+	s.Equal(50, s.cooked[1].ReadinessPercent)
+	s.Nil(s.cooked[1].Event)
+	s.Equal(100, s.cooked[2].ReadinessPercent)
+}
+
+func (s *OrderWorkflowTestSuite) TestWrongPINCode() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+	s.env.OnActivity(storage.LogAttemptToEnterWrongPINCode, mock.Anything, mock.MatchedBy(func(params postgres.LogAttemptToEnterWrongPINCodeParams) bool {
+		return params.OrderID == s.initialData.ID
+	})).Return(nil).Twice()
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+	s.cookAt(5*time.Minute, 0)
+	s.cookAt(6*time.Minute, 1)
+	s.receiveWithWrongPINAt(10 * time.Minute)
+	s.receiveWithWrongPINAt(11 * time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		state := s.orderState()
+		s.Equal(orderStatusReady, state.Status)
+		s.Equal(2, state.LogItems)
+	}, 12*time.Minute)
+	s.receiveAt(13 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Equal(orderStatusReceived, s.statuses[len(s.statuses)-1])
+}
+
+func (s *OrderWorkflowTestSuite) TestActivityRetried() {
+	// Первый вызов регистратора падает, темпорал повторяет активити. Мок с Once
+	// должен быть раньше общего, иначе общий перехватит и первый вызов.
+	s.env.OnActivity(fiscalService.RegisterReceipt, mock.Anything, mock.Anything).Return(fiscal.Registration{}, errors.New("registrar unavailable")).Once()
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.onPaidOrder()
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+	s.cookAt(5*time.Minute, 0)
+	s.cookAt(6*time.Minute, 1)
+	s.receiveAt(10 * time.Minute)
+
+	s.execute()
+	s.NoError(s.env.GetWorkflowError())
+
+	s.env.AssertNumberOfCalls(s.T(), "RegisterReceipt", 2)
+	s.Equal(orderStatusReceived, s.statuses[len(s.statuses)-1])
+}
+
+func (s *OrderWorkflowTestSuite) TestActivityFailure() {
+	s.onPrepareOrder()
+	s.onChangeOrderStatus()
+	s.env.OnActivity(fiscalService.RegisterReceipt, mock.Anything, mock.Anything).Return(fiscal.Registration{FiscalNumber: "0001"}, nil)
+	s.env.OnActivity(storage.SaveReceipt, mock.Anything, mock.Anything).Return(nil)
+	s.env.OnActivity(storage.StartCooking, mock.Anything, mock.Anything).
+		Return(temporal.NewNonRetryableApplicationError("kitchen not found", "NotFound", nil))
+
+	s.payAt(time.Minute, paymentSignalSuccessful, "")
+
+	s.execute()
+
+	err := s.env.GetWorkflowError()
+	s.Require().Error(err)
+
+	var applicationErr *temporal.ApplicationError
+	s.Require().True(errors.As(err, &applicationErr))
+	s.Equal("NotFound", applicationErr.Type())
+
+	s.Equal([]string{orderStatusWaitingForPayment, orderStatusPaid}, s.statuses)
+}
+
+// TestOrderWorkflowReplay проигрывает записанные истории заказов на текущем
+// коде воркфлоу. Падает, если изменение воркфлоу несовместимо с уже идущими
+// заказами, такие изменения надо закрывать workflow.GetVersion. Истории
+// записаны на devstack: оплаченный заказ с неудачной оплатой и неверным
+// пинкодом, отменённая оплата, заказ целиком по подарочной карте, заказ с
+// повторным и чужим сигналом кухни после skipStaleCookingSignals и заказ с
+// поисковыми атрибутами после orderSearchAttributes. Новые
+// выгружаются go run ./cmd/replay -out <dir> -query "WorkflowId = 'order:<id>'".
+// Истории из testdata/drained тут не проигрываются, см.
+// TestOrderWorkflowReplayDrained.
+func TestOrderWorkflowReplay(t *testing.T) {
+	files, err := filepath.Glob("testdata/order_workflow_*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflow(OrderWorkflow)
+
+			require.NoError(t, replayer.ReplayWorkflowHistoryFromJSONFile(nil, file))
+		})
+	}
+}
+
+// TestOrderWorkflowReplayDrained истории заказов, начатых до изменений без
+// версий из versions.go. Текущий код их проигрывать не должен, такие заказы
+// дренируются на старом воркере. История в testdata/drained записана на первой
+// версии воркфлоу с заглушками активити: неудачная оплата, оплата, две позиции
+// на кухне и неверный пинкод перед выдачей. Если она вдруг проигралась, то
+// старые заказы снова совместимы, и дренаж из versions.go надо пересмотреть.
+func TestOrderWorkflowReplayDrained(t *testing.T) {
+	files, err := filepath.Glob("testdata/drained/order_workflow_*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflow(OrderWorkflow)
+
+			require.Error(t, replayer.ReplayWorkflowHistoryFromJSONFile(nil, file))
+		})
+	}
+}
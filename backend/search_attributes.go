@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Поисковые атрибуты воркфлоу заказа. По ним заказы ищутся в visibility
+// темпорала, например все застрявшие в готовке на точке, без похода в базу.
+const (
+	SearchAttributeOrderStatus = "OrderStatus"
+	SearchAttributePointID     = "PointID"
+	SearchAttributeKitchenID   = "KitchenID"
+	SearchAttributeCacheID     = "CacheID"
+	SearchAttributeUserID      = "UserID"
+	SearchAttributeTotalPrice  = "TotalPrice"
+)
+
+// OrderSearchAttributes типы поисковых атрибутов заказа для регистрации в
+// неймспейсе.
+var OrderSearchAttributes = map[string]enums.IndexedValueType{
+	SearchAttributeOrderStatus: enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributePointID:     enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeKitchenID:   enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeCacheID:     enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeUserID:      enums.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeTotalPrice:  enums.INDEXED_VALUE_TYPE_INT,
+}
+
+// EnsureSearchAttributes регистрирует в неймспейсе недостающие поисковые
+// атрибуты заказа. Без них воркфлоу упадёт на первом же обновлении атрибутов.
+func EnsureSearchAttributes(ctx context.Context, c client.Client, namespace string) error {
+	resp, err := c.OperatorService().ListSearchAttributes(ctx, &operatorservice.ListSearchAttributesRequest{
+		Namespace: namespace,
+	})
+	if err != nil {
+		return fmt.Errorf("list search attributes: %v", err)
+	}
+
+	missing := make(map[string]enums.IndexedValueType)
+	for name, valueType := range OrderSearchAttributes {
+		existing, ok := resp.CustomAttributes[name]
+		if !ok {
+			missing[name] = valueType
+			continue
+		}
+		if existing != valueType {
+			return fmt.Errorf("search attribute %s has type %s, want %s", name, existing, valueType)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if _, err = c.OperatorService().AddSearchAttributes(ctx, &operatorservice.AddSearchAttributesRequest{
+		SearchAttributes: missing,
+		Namespace:        namespace,
+	}); err != nil {
+		return fmt.Errorf("add search attributes: %v", err)
+	}
+
+	return nil
+}
+
+// upsertSearchAttributes обновляет поисковые атрибуты после смены статуса
+// заказа. Атрибуты пишутся целиком, так проще и не надо помнить, какие уже
+// записаны.
+func (p *orderProcessing) upsertSearchAttributes(ctx workflow.Context) error {
+	if !p.withSearchAttributes {
+		return nil
+	}
+
+	return workflow.UpsertSearchAttributes(ctx, map[string]interface{}{
+		SearchAttributeOrderStatus: p.order.status,
+		SearchAttributePointID:     p.order.point.id.String(),
+		SearchAttributeKitchenID:   p.order.point.kitchenID.String(),
+		SearchAttributeCacheID:     p.order.point.cacheID.String(),
+		SearchAttributeUserID:      p.order.user.id.String(),
+		SearchAttributeTotalPrice:  p.order.totalPrice,
+	})
+}
@@ -0,0 +1,40 @@
+package backend
+
+// Изменения поведения OrderWorkflow закрываются workflow.GetVersion. Заказ может
+// часами ждать оплаты или выдачи, и воркер с новым кодом проигрывает историю,
+// записанную старым. Если новый код выполняет другие команды (активити, таймеры,
+// сайд-эффекты, поисковые атрибуты), реплей падает на недетерминизме и заказ
+// встаёт. Поэтому новое поведение включается только у заказов, в истории которых
+// записана новая версия, а уже идущие доживают по старой ветке.
+//
+// Ветку workflow.DefaultVersion можно убрать, когда заказов старше изменения не
+// осталось. Перед деплоем cmd/replay проигрывает идущие и недавние заказы на
+// новом коде, а backend.TestOrderWorkflowReplay записанные истории из testdata.
+//
+// Изменения до skipStaleCookingSignals версиями не закрыты: деньги в копейках
+// вместо float (результат GetItemsData старого заказа уже не разбирается),
+// фискальный чек, подарочные карты и запись заказа вместе с outbox одной
+// транзакцией вместо отдельных активити базы, поиска и sse. Старая ветка
+// потянула бы в воркер и все старые активити, поэтому заказы, начатые до этих
+// изменений, не переводятся на новый код, а дренируются:
+//
+//  1. новые api_server и worker выкатываются с новой temporal.task_queue, а
+//     старый воркер остаётся на старой очереди и доводит начатые им заказы,
+//     вместе с его активити IndexOrder, SendNotification, AddItemsForKitchen;
+//  2. go run ./cmd/replay -running проигрывает идущие заказы на новом коде и
+//     падает, пока среди них есть начатые старым кодом;
+//  3. когда проверка прошла, старый воркер останавливается.
+//
+// Что заказ первой версии на новом коде не проигрывается, проверяет
+// backend.TestOrderWorkflowReplayDrained на истории из testdata/drained.
+//
+// Так же выкатывается любое следующее изменение, которое не удаётся закрыть
+// версией.
+const (
+	// skipStaleCookingSignals сигнал о чужой или уже приготовленной позиции
+	// больше не вызывает MarkItemCooked.
+	skipStaleCookingSignals = "skip-stale-cooking-signals"
+	// orderSearchAttributes заказ пишет поисковые атрибуты при каждой смене
+	// статуса.
+	orderSearchAttributes = "order-search-attributes"
+)
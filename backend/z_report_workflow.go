@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// zReportCron время запуска Z-отчёта по времени точки. Отчёт строится за
+// прошедший день, поэтому запускаемся чуть позже полуночи.
+const zReportCron = "5 0 * * *"
+
+// ZReportWorkflow закрывает день точки: считает итоги предыдущего дня в часовом
+// поясе точки и сохраняет Z-отчёт.
+func ZReportWorkflow(ctx workflow.Context, pointID uuid.UUID) error {
+	ao := workflow.ActivityOptions{StartToCloseTimeout: 10 * time.Minute}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	var storage Storage = activityStub{}
+
+	var pointData postgres.PointData
+	if err := workflow.ExecuteActivity(ctx, storage.GetPointData, pointID).Get(ctx, &pointData); err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(pointData.Timezone)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("load timezone of point %s", pointID), "bad_timezone", err)
+	}
+
+	now := workflow.Now(ctx).In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	day := today.AddDate(0, 0, -1)
+
+	if err = workflow.ExecuteActivity(ctx, storage.CreateZReport, postgres.CreateZReportParams{
+		PointID:  pointID,
+		Day:      day.Format("2006-01-02"),
+		StartsAt: day,
+		EndsAt:   today,
+	}).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func zReportScheduleID(pointID uuid.UUID) string {
+	return fmt.Sprintf("z-report:%s", pointID.String())
+}
+
+// EnsureZReportSchedules заводит в темпорале расписание Z-отчёта для каждой
+// точки. Если расписание уже есть, то обновляется его часовой пояс.
+func EnsureZReportSchedules(ctx context.Context, c client.Client, taskQueue string, points []postgres.PointData) error {
+	for _, point := range points {
+		spec := client.ScheduleSpec{
+			CronExpressions: []string{zReportCron},
+			TimeZoneName:    point.Timezone,
+		}
+
+		_, err := c.ScheduleClient().Create(ctx, client.ScheduleOptions{
+			ID:   zReportScheduleID(point.ID),
+			Spec: spec,
+			Action: &client.ScheduleWorkflowAction{
+				ID:        zReportScheduleID(point.ID),
+				Workflow:  ZReportWorkflow,
+				Args:      []interface{}{point.ID},
+				TaskQueue: taskQueue,
+			},
+		})
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+			return fmt.Errorf("create z-report schedule for point %s: %v", point.ID, err)
+		}
+
+		handle := c.ScheduleClient().GetHandle(ctx, zReportScheduleID(point.ID))
+		if err = handle.Update(ctx, client.ScheduleUpdateOptions{
+			DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+				input.Description.Schedule.Spec = &spec
+				return &client.ScheduleUpdate{Schedule: &input.Description.Schedule}, nil
+			},
+		}); err != nil {
+			return fmt.Errorf("update z-report schedule for point %s: %v", point.ID, err)
+		}
+	}
+
+	return nil
+}
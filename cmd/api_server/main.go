@@ -3,13 +3,14 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"go.temporal.io/sdk/client"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/krocos/coffee-shop/config"
 	"github.com/krocos/coffee-shop/elasticsearch"
 	"github.com/krocos/coffee-shop/handling"
 	"github.com/krocos/coffee-shop/postgres"
@@ -17,38 +18,41 @@ import (
 )
 
 func main() {
-	config := zap.NewDevelopmentConfig()
-	config.EncoderConfig.TimeKey = "time"
-	config.EncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
-	config.Level.SetLevel(zap.DebugLevel)
+	cfg := config.MustLoad()
 
-	logger, err := config.Build()
+	logConfig := zap.NewDevelopmentConfig()
+	logConfig.EncoderConfig.TimeKey = "time"
+	logConfig.EncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+	logConfig.Level.SetLevel(zap.DebugLevel)
+
+	logger, err := logConfig.Build()
 	if err != nil {
 		panic(err)
 	}
 
 	search, err := elasticsearch.New(elasticsearch.Config{
-		Index: "coffee_shop_search_index",
-		URL:   "http://localhost:9202",
+		Index: cfg.Elasticsearch.Index,
+		URL:   cfg.Elasticsearch.URL,
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	db, err := postgres.NewGorm(postgres.GormConfig{
-		Host:     "localhost",
-		Port:     "5442",
-		Database: "postgres",
-		Username: "postgres",
-		Password: "postgres",
-	})
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
+	if err != nil {
+		panic(err)
+	}
+
+	// Меню, историю кухни и кассы и Z-отчёты читаем с реплик, если они есть.
+	// Экраны кухни и кассы читают с основной базы, см. ListKitchenCookItems.
+	replicas, err := postgres.NewGormReplicas(postgres.GormConfig(cfg.Postgres))
 	if err != nil {
 		panic(err)
 	}
 
 	c, err := client.Dial(client.Options{
-		HostPort:  client.DefaultHostPort,
-		Namespace: client.DefaultNamespace,
+		HostPort:  cfg.Temporal.HostPort,
+		Namespace: cfg.Temporal.Namespace,
 		Logger:    zapadapter.NewZapAdapter(logger),
 	})
 	if err != nil {
@@ -56,20 +60,14 @@ func main() {
 	}
 	defer c.Close()
 
-	h := handling.NewHandling(c, postgres.NewPostgres(db), search)
-	router := mux.NewRouter()
+	storage := postgres.NewPostgres(db, replicas...)
 
-	router.HandleFunc("/user-api/menu", h.GetMenu).Methods(http.MethodGet)
-	router.HandleFunc("/user-api/order", h.CreateOrder).Methods(http.MethodPost)
-	router.HandleFunc("/user-api/user/{user_id}/orders", h.ListUserOrders).Methods(http.MethodGet)
+	// Если эластик лежит, то список заказов пользователя отдаём из базы.
+	userOrders := handling.NewUserOrdersBreaker(search, storage, 3, 30*time.Second)
 
-	router.HandleFunc("/payment-gateway-api/order/{order_id}/payment-event", h.PaymentEvent).Methods(http.MethodPost)
-	router.HandleFunc("/kitchen-api/order/{order_id}/item-cooked", h.OrderItemCooked).Methods(http.MethodPost)
-	router.HandleFunc("/kitchen-api/kitchen/{kitchen_id}/cook-items", h.ListKitchenCookItems).Methods(http.MethodGet)
-	router.HandleFunc("/cache-api/order/{order_id}/receive-order", h.ReceiveOrder).Methods(http.MethodPost)
-	router.HandleFunc("/cache-api/cache/{cache_id}/orders", h.ListCacheOrders).Methods(http.MethodGet)
+	h := handling.NewHandling(c, cfg.Temporal.TaskQueue, storage, search, userOrders)
 
-	if err = http.ListenAndServe(":8888", cors.AllowAll().Handler(router)); err != nil {
+	if err = http.ListenAndServe(cfg.API.Addr, cors.AllowAll().Handler(h.Router())); err != nil {
 		log.Println(err)
 	}
 }
@@ -2,14 +2,30 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
 
+	"github.com/krocos/coffee-shop/config"
 	"github.com/krocos/coffee-shop/elasticsearch"
 )
 
+// Подкоманды:
+//
+//	recreate (по умолчанию)               удаляет все версии индекса и создаёт пустой индекс v1;
+//	migrate [-delete-old]                 переносит заказы в индекс следующей версии с текущим маппингом без простоя;
+//	rollover [-max-age 30d] [-max-docs N] начинает новое поколение индекса, если текущее старше или больше;
+//	retention [-months 12] [-archive]     удаляет или закрывает поколения старше N месяцев.
+//
+// rollover и retention рассчитаны на запуск по крону, например раз в сутки.
+// Флаги настроек (-config, -elasticsearch-url и другие) идут до подкоманды.
 func main() {
+	cfg := config.MustLoad()
+
 	search, err := elasticsearch.New(elasticsearch.Config{
-		Index: "coffee_shop_search_index",
-		URL:   "http://localhost:9202",
+		Index: cfg.Elasticsearch.Index,
+		URL:   cfg.Elasticsearch.URL,
 	})
 	if err != nil {
 		panic(err)
@@ -17,6 +33,53 @@ func main() {
 
 	ctx := context.Background()
 
+	command, args := "recreate", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "recreate":
+		recreate(ctx, search)
+	case "migrate":
+		flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+		deleteOld := flags.Bool("delete-old", false, "delete the previous index version after the switch")
+		_ = flags.Parse(args)
+
+		if err = search.Migrate(ctx, *deleteOld, log.Printf); err != nil {
+			panic(err)
+		}
+	case "rollover":
+		flags := flag.NewFlagSet("rollover", flag.ExitOnError)
+		maxAge := flags.String("max-age", "30d", "roll over when the current index is older than this")
+		maxDocs := flags.Int64("max-docs", 0, "roll over when the current index has more orders than this, 0 to ignore")
+		_ = flags.Parse(args)
+
+		newIndex, rolledOver, err := search.Rollover(ctx, *maxAge, *maxDocs)
+		if err != nil {
+			panic(err)
+		}
+		if rolledOver {
+			log.Printf("rolled over to %s", newIndex)
+		} else {
+			log.Printf("conditions not met, keep writing to the current index")
+		}
+	case "retention":
+		flags := flag.NewFlagSet("retention", flag.ExitOnError)
+		months := flags.Int("months", 12, "keep indices written to within this many months")
+		archive := flags.Bool("archive", false, "close old indices and remove them from aliases instead of deleting")
+		_ = flags.Parse(args)
+
+		if err = search.ApplyRetention(ctx, *months, *archive, log.Printf); err != nil {
+			panic(err)
+		}
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q, use recreate, migrate, rollover or retention\n", command)
+		os.Exit(2)
+	}
+}
+
+func recreate(ctx context.Context, search *elasticsearch.Search) {
 	exists, err := search.IsExists(ctx)
 	if err != nil {
 		panic(err)
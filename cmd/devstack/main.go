@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/rs/cors"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/config"
+	"github.com/krocos/coffee-shop/fiscal"
+	"github.com/krocos/coffee-shop/handling"
+	"github.com/krocos/coffee-shop/memory"
+	"github.com/krocos/coffee-shop/outbox"
+	"github.com/krocos/coffee-shop/postgres"
+	"github.com/krocos/coffee-shop/proxy"
+	"github.com/krocos/coffee-shop/sse"
+	"github.com/krocos/coffee-shop/zapadapter"
+)
+
+// Запускает всю кофейню одним процессом без постгреса, эластика и отдельного
+// темпорала: dev server темпорала, воркер, апи, сервер уведомлений, релей
+// outbox и клиенты пользователя, кухни и кассы. Хранилище и поиск в памяти с
+// данными из postgres.DevSeed, всё пропадает при выходе. Адреса те же, что в
+// config, запускать из корня репозитория, клиенты берутся из cmd/*_ui.
+func main() {
+	var (
+		temporalCLI = flag.String("temporal-cli", "", "path to the temporal cli, downloaded on first run if empty")
+		temporalUI  = flag.Bool("temporal-ui", true, "run temporal web ui next to the dev server")
+		buildUI     = flag.Bool("build-ui", true, "build app.wasm of the clients before start")
+	)
+	cfg := config.MustLoad()
+
+	logConfig := zap.NewDevelopmentConfig()
+	logConfig.EncoderConfig.TimeKey = "time"
+	logConfig.EncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+	logConfig.Level.SetLevel(zap.InfoLevel)
+
+	logger, err := logConfig.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	uis := []uiServer{
+		{dir: "cmd/user_ui", addr: cfg.UI.UserAddr, title: "Coffee-Shop"},
+		{dir: "cmd/kitchen_ui", addr: cfg.UI.KitchenAddr, title: "Kitchen UI"},
+		{dir: "cmd/cache_ui", addr: cfg.UI.CacheAddr, title: "Cache UI"},
+	}
+	if *buildUI {
+		for _, ui := range uis {
+			if err = ui.build(ctx); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// Поисковые атрибуты заказа заводятся сразу при старте dev server.
+	searchAttributes := make([]string, 0)
+	for name, valueType := range backend.OrderSearchAttributes {
+		searchAttributes = append(searchAttributes, "--search-attribute", fmt.Sprintf("%s=%s", name, valueType))
+	}
+
+	server, err := testsuite.StartDevServer(ctx, testsuite.DevServerOptions{
+		ExistingPath: *temporalCLI,
+		ClientOptions: &client.Options{
+			HostPort:  cfg.Temporal.HostPort,
+			Namespace: cfg.Temporal.Namespace,
+			Logger:    zapadapter.NewZapAdapter(logger),
+		},
+		EnableUI:  *temporalUI,
+		LogLevel:  "error",
+		ExtraArgs: searchAttributes,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = server.Stop() }()
+
+	c := server.Client()
+	defer c.Close()
+
+	storage := memory.NewStorage(postgres.DevSeed())
+	search := memory.NewSearch()
+
+	registrar, err := fiscal.NewFileRegistrar(cfg.Fiscal.ReceiptsDir)
+	if err != nil {
+		panic(err)
+	}
+
+	points, err := storage.ListPoints(ctx)
+	if err != nil {
+		panic(err)
+	}
+	if err = backend.EnsureZReportSchedules(ctx, c, cfg.Temporal.TaskQueue, points); err != nil {
+		panic(err)
+	}
+
+	// Сверки нет: базы и поиска по отдельности тут нет, сверять нечего.
+	w := worker.New(c, cfg.Temporal.TaskQueue, worker.Options{})
+	w.RegisterWorkflow(backend.OrderWorkflow)
+	w.RegisterWorkflow(backend.ZReportWorkflow)
+	w.RegisterActivity(storage)
+	w.RegisterActivity(fiscal.NewFiscal(registrar))
+
+	if err = w.Start(); err != nil {
+		panic(err)
+	}
+	defer w.Stop()
+
+	// Сервер уведомлений сам и уведомитель для релея, без похода по http.
+	notifier := sse.NewServer()
+
+	relay := outbox.NewRelay(storage, search, notifier, outbox.Config{
+		BatchSize:    cfg.Outbox.BatchSize,
+		PollInterval: cfg.Outbox.PollInterval,
+		MaxBackoff:   cfg.Outbox.MaxBackoff,
+		MaxAttempts:  cfg.Outbox.MaxAttempts,
+	})
+	go func() {
+		if err := relay.Run(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	h := handling.NewHandling(c, cfg.Temporal.TaskQueue, storage, search, search)
+
+	servers := []*http.Server{
+		{Addr: cfg.API.Addr, Handler: cors.AllowAll().Handler(h.Router())},
+		{Addr: cfg.SSE.Addr, Handler: notifier.Handler()},
+	}
+
+	// На сервере страницы клиентов только отдаются, рисует их app.wasm в
+	// браузере, поэтому маршрут один общий с пустой заглушкой.
+	app.Route("/", new(uiPlaceholder))
+	for _, ui := range uis {
+		servers = append(servers, proxy.CreateServer(ui.addr, cfg, ui.handler()))
+	}
+
+	for _, s := range servers {
+		go func(s *http.Server) {
+			if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("serve %s: %v", s.Addr, err)
+				stop()
+			}
+		}(s)
+	}
+
+	log.Printf("devstack started: user ui %s, kitchen ui %s, cache ui %s, api %s, temporal %s",
+		cfg.UI.UserAddr, cfg.UI.KitchenAddr, cfg.UI.CacheAddr, cfg.API.Addr, server.FrontendHostPort())
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, s := range servers {
+		_ = s.Shutdown(shutdownCtx)
+	}
+}
+
+type uiServer struct {
+	dir   string
+	addr  string
+	title string
+}
+
+// build собирает app.wasm клиента в его web, как для отдельного запуска.
+func (u uiServer) build(ctx context.Context) error {
+	log.Printf("build %s", u.dir)
+
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", filepath.Join(u.dir, "web", "app.wasm"), "./"+u.dir)
+	cmd.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (u uiServer) handler() http.Handler {
+	return &app.Handler{
+		Resources: uiResources{Handler: http.FileServer(http.Dir(u.dir))},
+		Icon: app.Icon{
+			Default:    "/web/favicon.ico",
+			Large:      "/web/apple-touch-icon.png",
+			AppleTouch: "/web/apple-touch-icon.png",
+		},
+		BackgroundColor: "#ffffff",
+		ThemeColor:      "#ffffff",
+		Title:           u.title,
+		RawHeaders: []string{
+			`<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.2.2/dist/css/bootstrap.min.css" rel="stylesheet">
+<script src="https://cdn.jsdelivr.net/npm/@popperjs/core@2.11.6/dist/umd/popper.min.js" defer></script>
+<script src="https://cdn.jsdelivr.net/npm/bootstrap@5.2.2/dist/js/bootstrap.min.js" defer></script>`,
+		},
+	}
+}
+
+// uiResources отдаёт /web/ из каталога клиента, как будто он запущен из него.
+type uiResources struct {
+	http.Handler
+}
+
+func (uiResources) Package() string { return "" }
+func (uiResources) Static() string  { return "" }
+func (uiResources) AppWASM() string { return "/web/app.wasm" }
+
+type uiPlaceholder struct {
+	app.Compo
+}
+
+func (p *uiPlaceholder) Render() app.UI {
+	return app.Div()
+}
@@ -10,10 +10,13 @@ import (
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
 	"github.com/r3labs/sse/v2"
 
+	"github.com/krocos/coffee-shop/config"
 	"github.com/krocos/coffee-shop/proxy"
 )
 
-const host = "localhost:8091"
+// host адрес, с которого загружен клиент. Сервер клиента проксирует запросы к
+// апи и уведомлениям, поэтому ходим туда же.
+var host string
 
 type (
 	User struct {
@@ -21,9 +24,10 @@ type (
 		Name string    `json:"name"`
 	}
 	Item struct {
-		ID    uuid.UUID `json:"id"`
-		Title string    `json:"title"`
-		Price float64   `json:"price"`
+		ID       uuid.UUID `json:"id"`
+		Title    string    `json:"title"`
+		Price    int64     `json:"price"`
+		Currency string    `json:"currency"`
 	}
 	Point struct {
 		ID        uuid.UUID `json:"id"`
@@ -161,9 +165,14 @@ func (u *KitchenUI) updateKitchenCookItemsAction(ctx app.Context, action app.Act
 
 func main() {
 	app.Route("/", new(KitchenUI))
+	if app.IsClient {
+		host = app.Window().URL().Host
+	}
 	app.RunWhenOnBrowser()
 
-	http.Handle("/", &app.Handler{
+	cfg := config.MustLoad()
+
+	ui := &app.Handler{
 		Icon: app.Icon{
 			Default:    "/web/favicon.ico",
 			Large:      "/web/apple-touch-icon.png",
@@ -178,9 +187,9 @@ func main() {
 <script src="https://cdn.jsdelivr.net/npm/@popperjs/core@2.11.6/dist/umd/popper.min.js" defer></script>
 <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.2.2/dist/js/bootstrap.min.js" defer></script>`,
 		},
-	})
+	}
 
-	if err := proxy.CreateServer(":8091").ListenAndServe(); err != nil {
+	if err := proxy.CreateServer(cfg.UI.KitchenAddr, cfg, ui).ListenAndServe(); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/krocos/coffee-shop/config"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// Подкоманды:
+//
+//	up [-to N]       применяет неприменённые миграции, все или до версии N (по умолчанию);
+//	down [-steps 1]  откатывает последние применённые миграции;
+//	status           показывает, какие миграции применены;
+//	baseline         переводит на миграции базу, созданную через AutoMigrate.
+//
+// Миграции лежат в postgres/migrations и вшиты в бинарник. Запускать можно
+// сколько угодно раз и хоть с нескольких машин сразу, лишние запуски дождутся
+// первого и ничего не сделают. Флаги настроек (-config, -postgres-host и
+// другие) идут до подкоманды.
+//
+// База, созданная через AutoMigrate (create_schema), хоть самой первой
+// версии с деньгами в рублях, обновляется так:
+//
+//  1. migrate baseline доводит схему до 0001_init, деньги переводятся в
+//     копейки, и отмечает 0001 применённой;
+//  2. migrate up ставит остальные миграции;
+//  3. индекс поиска пересоздаётся через create_index recreate и заполняется
+//     через reindex, в маппинге поменялись типы денег.
+func main() {
+	cfg := config.MustLoad()
+
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
+	if err != nil {
+		panic(err)
+	}
+
+	migrator, err := postgres.NewMigrator(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+
+	command, args := "up", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "up":
+		flags := flag.NewFlagSet("up", flag.ExitOnError)
+		to := flags.Int("to", 0, "apply migrations up to this version, 0 for all")
+		_ = flags.Parse(args)
+
+		if err = migrator.Up(ctx, *to, log.Printf); err != nil {
+			panic(err)
+		}
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "how many applied migrations to revert")
+		_ = flags.Parse(args)
+
+		if err = migrator.Down(ctx, *steps, log.Printf); err != nil {
+			panic(err)
+		}
+	case "status":
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			panic(err)
+		}
+		for _, m := range list {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, applied)
+		}
+	case "baseline":
+		if err = migrator.Baseline(ctx, log.Printf); err != nil {
+			panic(err)
+		}
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q, use up, down, status or baseline\n", command)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/krocos/coffee-shop/config"
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/outbox"
+	"github.com/krocos/coffee-shop/postgres"
+	"github.com/krocos/coffee-shop/sse"
+)
+
+// Доставляет изменения заказов из outbox в поиск и клиентам. Можно запустить
+// несколько штук, и все они доставляют одновременно: каждый берёт свои записи
+// через FOR UPDATE SKIP LOCKED и держит их арендой, пока доставляет, а записи
+// одного заказа всё равно уходят по очереди. Если релей упал, то его записи
+// возьмут другие, когда кончится аренда. Настройки в секции outbox конфига.
+func main() {
+	cfg := config.MustLoad()
+
+	search, err := elasticsearch.New(elasticsearch.Config{
+		Index:             cfg.Elasticsearch.Index,
+		URL:               cfg.Elasticsearch.URL,
+		BulkSize:          cfg.Outbox.BulkSize,
+		BulkFlushInterval: cfg.Outbox.BulkFlushInterval,
+		BulkQueueSize:     cfg.Outbox.BulkQueueSize,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer search.Close()
+
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
+	if err != nil {
+		panic(err)
+	}
+
+	notifier, err := sse.NewSSE(cfg.SSE.SendEventURL())
+	if err != nil {
+		panic(err)
+	}
+
+	relay := outbox.NewRelay(postgres.NewPostgres(db), search, notifier, outbox.Config{
+		BatchSize:    cfg.Outbox.BatchSize,
+		PollInterval: cfg.Outbox.PollInterval,
+		MaxBackoff:   cfg.Outbox.MaxBackoff,
+		MaxAttempts:  cfg.Outbox.MaxAttempts,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Println("outbox relay started")
+
+	if err = relay.Run(ctx); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/krocos/coffee-shop/config"
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/postgres"
+	"github.com/krocos/coffee-shop/reconcile"
+)
+
+// Сверяет заказы в базе, поиске и воркфлоу и списки кухни и кассы. Без -repair
+// только печатает расхождения. Код выхода 1, если остались непочиненные.
+func main() {
+	var (
+		since  = flag.Duration("since", 48*time.Hour, "check orders created within this period")
+		repair = flag.Bool("repair", false, "repair mismatches")
+		settle = flag.Duration("settle", 10*time.Second, "recheck mismatches after this delay to skip in-flight updates")
+		asJSON = flag.Bool("json", false, "print the report as json")
+	)
+	cfg := config.MustLoad()
+
+	search, err := elasticsearch.New(elasticsearch.Config{
+		Index: cfg.Elasticsearch.Index,
+		URL:   cfg.Elasticsearch.URL,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer search.Close()
+
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := client.Dial(client.Options{
+		HostPort:  cfg.Temporal.HostPort,
+		Namespace: cfg.Temporal.Namespace,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	reconciler := reconcile.NewReconciler(postgres.NewPostgres(db), search, c)
+
+	report, err := reconciler.Reconcile(context.Background(), reconcile.Params{
+		Since:  time.Now().Add(-*since),
+		Repair: *repair,
+		Settle: *settle,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		for _, m := range report.Mismatches {
+			state := "found"
+			switch {
+			case m.Repaired:
+				state = "repaired"
+			case m.RepairError != "":
+				state = "repair failed: " + m.RepairError
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", m.OrderID, m.Kind, m.Details, state)
+		}
+		log.Printf("checked %d orders, %d mismatches, %d unrepaired",
+			report.CheckedOrders, len(report.Mismatches), report.Unrepaired())
+	}
+
+	if report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
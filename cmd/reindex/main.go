@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/krocos/coffee-shop/config"
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// checkpoint сохраняется после каждой пачки, что бы после падения продолжить
+// с того же места, а не индексировать всю историю заново.
+type checkpoint struct {
+	Cursor  postgres.OrderCursor `json:"cursor"`
+	Indexed int                  `json:"indexed"`
+	Failed  int                  `json:"failed"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	bb, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return new(checkpoint), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cp := new(checkpoint)
+	if err = json.Unmarshal(bb, cp); err != nil {
+		return nil, fmt.Errorf("parse checkpoint %s: %v", path, err)
+	}
+
+	return cp, nil
+}
+
+func (cp *checkpoint) save(path string) error {
+	bb, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, bb, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Перестраивает индекс заказов по данным из базы. Индекс должен уже существовать
+// (см. create_index).
+func main() {
+	var (
+		batchSize      = flag.Int("batch", 500, "orders per bulk request")
+		checkpointPath = flag.String("checkpoint", "reindex.checkpoint.json", "file to resume from")
+		failuresPath   = flag.String("failures", "reindex.failures.log", "file to append failed order ids to")
+		reset          = flag.Bool("reset", false, "ignore the checkpoint and start from the first order")
+	)
+	cfg := config.MustLoad()
+
+	search, err := elasticsearch.New(elasticsearch.Config{
+		Index: cfg.Elasticsearch.Index,
+		URL:   cfg.Elasticsearch.URL,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
+	if err != nil {
+		panic(err)
+	}
+
+	storage := postgres.NewPostgres(db)
+
+	cp := new(checkpoint)
+	if !*reset {
+		if cp, err = loadCheckpoint(*checkpointPath); err != nil {
+			panic(err)
+		}
+	}
+
+	failures, err := os.OpenFile(*failuresPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = failures.Close() }()
+
+	ctx := context.Background()
+
+	log.Printf("reindex from %s/%s, already indexed %d, failed %d",
+		cp.Cursor.CreatedAt.Format("2006-01-02T15:04:05Z07:00"), cp.Cursor.ID, cp.Indexed, cp.Failed)
+
+	for {
+		orders, err := storage.ListOrdersBatch(ctx, cp.Cursor, *batchSize)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(orders) == 0 {
+			break
+		}
+
+		docs := make([]*elasticsearch.Order, 0, len(orders))
+		for _, order := range orders {
+			docs = append(docs, order.SearchDoc())
+		}
+
+		failed, err := search.BulkIndexOrders(ctx, docs)
+		if err != nil {
+			// Чекпоинт не двигаем, при следующем запуске пачка пойдёт заново.
+			panic(err)
+		}
+
+		for _, f := range failed {
+			log.Printf("failed to index order %s: %s", f.ID, f.Reason)
+			_, _ = fmt.Fprintf(failures, "%s\t%s\n", f.ID, f.Reason)
+		}
+
+		last := orders[len(orders)-1]
+
+		cp.Cursor = postgres.OrderCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		cp.Indexed += len(orders) - len(failed)
+		cp.Failed += len(failed)
+
+		if err = cp.save(*checkpointPath); err != nil {
+			panic(err)
+		}
+
+		log.Printf("indexed %d, failed %d, last order %s at %s",
+			cp.Indexed, cp.Failed, last.ID, last.CreatedAt.Format("2006-01-02T15:04:05Z07:00"))
+	}
+
+	log.Printf("done: indexed %d, failed %d", cp.Indexed, cp.Failed)
+
+	if cp.Failed > 0 {
+		log.Printf("failed order ids are in %s", *failuresPath)
+		os.Exit(1)
+	}
+}
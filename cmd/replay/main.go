@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+
+	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/config"
+)
+
+// Проигрывает истории заказов на текущем коде OrderWorkflow, запускать перед
+// деплоем воркера. Без аргументов берёт идущие и закрытые заказы из темпорала по
+// -query, с аргументами проигрывает json файлы историй, выгруженные через -out
+// или лежащие в backend/testdata. Код выхода 1, если хоть одна история не
+// проигралась, это недетерминизм и заказы на новом воркере встанут.
+//
+// С -running берутся только идущие заказы. Это проверка дренажа из
+// backend/versions.go: старый воркер можно останавливать, когда она проходит.
+func main() {
+	var (
+		query   = flag.String("query", "WorkflowType = 'OrderWorkflow'", "visibility query for workflows to replay")
+		limit   = flag.Int("limit", 1000, "replay at most this many workflows from temporal")
+		out     = flag.String("out", "", "save downloaded histories as json into this directory")
+		running = flag.Bool("running", false, "replay only running workflows")
+	)
+	cfg := config.MustLoad()
+
+	if *running {
+		*query = fmt.Sprintf("(%s) AND ExecutionStatus = 'Running'", *query)
+	}
+
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflow(backend.OrderWorkflow)
+
+	var replayed, failed int
+	report := func(name string, err error) {
+		replayed++
+		if err != nil {
+			failed++
+			log.Printf("FAIL %s: %v", name, err)
+		}
+	}
+
+	if files := flag.Args(); len(files) > 0 {
+		for _, file := range files {
+			report(file, replayer.ReplayWorkflowHistoryFromJSONFile(nil, file))
+		}
+	} else {
+		ctx := context.Background()
+
+		c, err := client.Dial(client.Options{
+			HostPort:  cfg.Temporal.HostPort,
+			Namespace: cfg.Temporal.Namespace,
+		})
+		if err != nil {
+			panic(err)
+		}
+		defer c.Close()
+
+		if *out != "" {
+			if err = os.MkdirAll(*out, 0o755); err != nil {
+				panic(err)
+			}
+		}
+
+		var token []byte
+		for replayed < *limit {
+			resp, err := c.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+				Namespace:     cfg.Temporal.Namespace,
+				PageSize:      int32(min(*limit-replayed, 100)),
+				NextPageToken: token,
+				Query:         *query,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			for _, execution := range resp.Executions {
+				if replayed >= *limit {
+					break
+				}
+
+				workflowID, runID := execution.Execution.WorkflowId, execution.Execution.RunId
+				name := fmt.Sprintf("%s %s", workflowID, runID)
+
+				history, err := getHistory(ctx, c, workflowID, runID)
+				if err != nil {
+					panic(err)
+				}
+
+				if *out != "" {
+					file := filepath.Join(*out, fmt.Sprintf("%s_%s.json", strings.ReplaceAll(workflowID, ":", "_"), runID))
+					if err = saveHistory(file, history); err != nil {
+						panic(err)
+					}
+				}
+
+				// У идущего заказа история неполная, реплеер проигрывает то, что есть.
+				report(name, replayer.ReplayWorkflowHistory(nil, history))
+			}
+
+			token = resp.NextPageToken
+			if len(token) == 0 {
+				break
+			}
+		}
+	}
+
+	log.Printf("replayed %d histories, %d failed", replayed, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func getHistory(ctx context.Context, c client.Client, workflowID, runID string) (*historypb.History, error) {
+	history := &historypb.History{}
+
+	iter := c.GetWorkflowHistory(ctx, workflowID, runID, false, enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("get history of %s: %v", workflowID, err)
+		}
+		history.Events = append(history.Events, event)
+	}
+
+	return history, nil
+}
+
+// saveHistory пишет историю в том json, который читает реплеер SDK.
+func saveHistory(file string, history *historypb.History) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("create %s: %v", file, err)
+	}
+	defer f.Close()
+
+	marshaler := jsonpb.Marshaler{Indent: "  "}
+	if err = marshaler.Marshal(f, history); err != nil {
+		return fmt.Errorf("write %s: %v", file, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/davecgh/go-spew/spew"
+	"gorm.io/gorm"
+
+	"github.com/krocos/coffee-shop/config"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// Заполняет базу пользователями, меню и точками. Схему создаёт cmd/migrate.
+// Повторный запуск ничего не задваивает: пользователи ищутся по имени, позиции
+// меню по названию, точки по адресу, и создаются только недостающие.
+func main() {
+	cfg := config.MustLoad()
+
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
+	if err != nil {
+		panic(err)
+	}
+
+	seed := postgres.DevSeed()
+
+	if err = db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range seed.Users {
+			if err := tx.Where("name = ?", user.Name).FirstOrCreate(user).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, item := range seed.Items {
+			if err := tx.Where("title = ?", item.Title).FirstOrCreate(item).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, point := range seed.Points {
+			if err := tx.Where("addr = ?", point.Addr).FirstOrCreate(point).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("USERS")
+	fmt.Println()
+
+	spew.Dump(seed.Users)
+
+	fmt.Println()
+	fmt.Println("ITEMS")
+	fmt.Println()
+
+	spew.Dump(seed.Items)
+
+	fmt.Println()
+	fmt.Println("POINTS")
+	fmt.Println()
+
+	spew.Dump(seed.Points)
+}
@@ -4,11 +4,14 @@ import (
 	"fmt"
 
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
+
+	"github.com/krocos/coffee-shop/money"
 )
 
 type ItemsListCompo struct {
 	app.Compo
-	Items []*UserOrderItemResponse
+	Items    []*UserOrderItemResponse
+	Currency string
 }
 
 func (c *ItemsListCompo) Render() app.UI {
@@ -19,9 +22,9 @@ func (c *ItemsListCompo) Render() app.UI {
 					app.Div().Class("row").Body(
 						app.Div().Class("col-4", "col-sm-4", "col-md-5", "col-lg-5").Text(c.Items[i].Title),
 						app.Div().Class("col-4", "col-sm-4", "col-md-4", "col-lg-4", "text-end").
-							Body(app.Small().Text(fmt.Sprintf("%.0f по %.2f₽", c.Items[i].Quantity, c.Items[i].Price))),
+							Body(app.Small().Text(fmt.Sprintf("%.0f по %s", c.Items[i].Quantity, money.Format(c.Items[i].Price, c.Currency)))),
 						app.Div().Class("col-4", "col-sm-4", "col-md-3", "col-lg-3", "text-end").
-							Text(fmt.Sprintf("%.2f₽", c.Items[i].TotalPrice)),
+							Text(money.Format(c.Items[i].TotalPrice, c.Currency)),
 					),
 					app.Div().Class("row").Body(
 						app.Div().Class("col").Style("font-size", "0.8em").Body(
@@ -6,16 +6,21 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
 	"github.com/r3labs/sse/v2"
 
+	"github.com/krocos/coffee-shop/config"
 	"github.com/krocos/coffee-shop/proxy"
 )
 
-const host = "localhost:8090"
+// host адрес, с которого загружен клиент. Сервер клиента проксирует запросы к
+// апи и уведомлениям, поэтому ходим туда же.
+var host string
 
 func init() {
 	rand.Seed(time.Now().UnixMicro())
@@ -27,9 +32,10 @@ type (
 		Name string    `json:"name"`
 	}
 	Item struct {
-		ID    uuid.UUID `json:"id"`
-		Title string    `json:"title"`
-		Price float64   `json:"price"`
+		ID       uuid.UUID `json:"id"`
+		Title    string    `json:"title"`
+		Price    int64     `json:"price"`
+		Currency string    `json:"currency"`
 	}
 	Point struct {
 		ID   uuid.UUID `json:"id"`
@@ -42,42 +48,74 @@ type (
 	}
 
 	UserOrderResponse struct {
-		ID         uuid.UUID                `json:"id"`
-		CreatedAt  time.Time                `json:"created_at"`
-		Status     string                   `json:"status"`
-		TotalPrice float64                  `json:"total_price"`
-		PINCode    string                   `json:"pin_code"`
-		Point      *PointResponse           `json:"point"`
-		Items      []*UserOrderItemResponse `json:"items"`
-		LogItems   []*LogItemResponse       `json:"log_items"`
+		ID             uuid.UUID                `json:"id"`
+		CreatedAt      time.Time                `json:"created_at"`
+		Status         string                   `json:"status"`
+		TotalPrice     int64                    `json:"total_price"`
+		Currency       string                   `json:"currency"`
+		GiftCardAmount int64                    `json:"gift_card_amount"`
+		PINCode        string                   `json:"pin_code"`
+		Point          *PointResponse           `json:"point"`
+		Items          []*UserOrderItemResponse `json:"items"`
+		LogItems       []*LogItemResponse       `json:"log_items"`
+		Timeline       []*TimelineEventResponse `json:"timeline"`
 	}
 	UserOrderItemResponse struct {
 		ID         uuid.UUID `json:"id"`
 		Title      string    `json:"title"`
-		Price      float64   `json:"price"`
+		Price      int64     `json:"price"`
 		Quantity   float64   `json:"quantity"`
-		TotalPrice float64   `json:"total_price"`
+		TotalPrice int64     `json:"total_price"`
 	}
 	LogItemResponse struct {
 		ID   uuid.UUID `json:"id"`
 		Text string    `json:"text"`
 	}
+	TimelineEventResponse struct {
+		Status string    `json:"status"`
+		At     time.Time `json:"at"`
+		Actor  string    `json:"actor"`
+	}
 	PointResponse struct {
 		ID   uuid.UUID `json:"id"`
 		Addr string    `json:"addr"`
 	}
+	UserOrdersPageResponse struct {
+		Orders     []*UserOrderResponse `json:"orders"`
+		NextCursor string               `json:"next_cursor"`
+	}
 )
 
+// ordersPageSize сколько заказов подгружаем за раз.
+const ordersPageSize = 10
+
 type UserUI struct {
 	app.Compo
 	Menu         *MenuResponse
 	SelectedUser *User
 	Orders       []*UserOrderResponse
+	NextCursor   string
+	loadingMore  bool
 }
 
 func (u *UserUI) OnMount(ctx app.Context) {
 	ctx.Handle("userSelected", u.userSelectedAction)
 	ctx.Handle("updateOrders", u.updateOrdersAction)
+	ctx.Handle("loadMoreOrders", u.loadMoreOrdersAction)
+
+	// Подгружаем следующую страницу заказов, когда до конца страницы осталось
+	// меньше одного экрана.
+	app.Window().AddEventListener("scroll", func(_ app.Context, _ app.Event) {
+		if u.SelectedUser == nil || u.NextCursor == "" || u.loadingMore {
+			return
+		}
+
+		win := app.Window()
+		scrolled := win.Get("scrollY").Float() + 2*win.Get("innerHeight").Float()
+		if scrolled >= win.Get("document").Get("documentElement").Get("scrollHeight").Float() {
+			ctx.NewAction("loadMoreOrders")
+		}
+	})
 
 	ctx.Async(func() {
 		res, err := http.Get(fmt.Sprintf("http://%s/user-api/menu", host))
@@ -144,7 +182,7 @@ func (u *UserUI) Render() app.UI {
 				app.Div().Class("col-sm-12", "col-md-9", "col-xl-6").Body(
 					app.Br(),
 					app.H2().Text("Заказы"),
-					&OrderListing{Orders: u.Orders},
+					&OrderListing{Orders: u.Orders, HasMore: u.NextCursor != ""},
 				),
 			),
 		)
@@ -177,21 +215,73 @@ func (u *UserUI) userSelectedAction(ctx app.Context, action app.Action) {
 	})
 }
 
+// updateOrdersAction перечитывает первую страницу заказов. Уже подгруженные
+// более старые заказы остаются на месте.
 func (u *UserUI) updateOrdersAction(ctx app.Context, action app.Action) {
-	orders, err := getUserOrders(u.SelectedUser.ID)
+	page, err := getUserOrders(u.SelectedUser.ID, "")
 	if err != nil {
 		app.Log(err)
 		return
 	}
 
+	if len(u.Orders) <= len(page.Orders) || len(page.Orders) == 0 {
+		u.Orders = page.Orders
+		u.NextCursor = page.NextCursor
+		return
+	}
+
+	fresh := make(map[uuid.UUID]bool)
+	for _, order := range page.Orders {
+		fresh[order.ID] = true
+	}
+
+	oldest := page.Orders[len(page.Orders)-1].CreatedAt
+	orders := page.Orders
+	for _, order := range u.Orders {
+		if !fresh[order.ID] && order.CreatedAt.Before(oldest) {
+			orders = append(orders, order)
+		}
+	}
+
 	u.Orders = orders
 }
 
+func (u *UserUI) loadMoreOrdersAction(ctx app.Context, action app.Action) {
+	if u.NextCursor == "" || u.loadingMore {
+		return
+	}
+	u.loadingMore = true
+	defer func() { u.loadingMore = false }()
+
+	page, err := getUserOrders(u.SelectedUser.ID, u.NextCursor)
+	if err != nil {
+		app.Log(err)
+		return
+	}
+
+	known := make(map[uuid.UUID]bool)
+	for _, order := range u.Orders {
+		known[order.ID] = true
+	}
+	for _, order := range page.Orders {
+		if !known[order.ID] {
+			u.Orders = append(u.Orders, order)
+		}
+	}
+
+	u.NextCursor = page.NextCursor
+}
+
 func main() {
 	app.Route("/", new(UserUI))
+	if app.IsClient {
+		host = app.Window().URL().Host
+	}
 	app.RunWhenOnBrowser()
 
-	http.Handle("/", &app.Handler{
+	cfg := config.MustLoad()
+
+	ui := &app.Handler{
 		Name:      "Coffee-Shop",
 		ShortName: "Coffee-Shop",
 		Icon: app.Icon{
@@ -208,15 +298,21 @@ func main() {
 <script src="https://cdn.jsdelivr.net/npm/@popperjs/core@2.11.6/dist/umd/popper.min.js" defer></script>
 <script src="https://cdn.jsdelivr.net/npm/bootstrap@5.2.2/dist/js/bootstrap.min.js" defer></script>`,
 		},
-	})
+	}
 
-	if err := proxy.CreateServer(":8090").ListenAndServe(); err != nil {
+	if err := proxy.CreateServer(cfg.UI.UserAddr, cfg, ui).ListenAndServe(); err != nil {
 		panic(err)
 	}
 }
 
-func getUserOrders(userID uuid.UUID) ([]*UserOrderResponse, error) {
-	res, err := http.Get(fmt.Sprintf("http://%s/user-api/user/%s/orders", host, userID.String()))
+func getUserOrders(userID uuid.UUID, cursor string) (*UserOrdersPageResponse, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(ordersPageSize))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	res, err := http.Get(fmt.Sprintf("http://%s/user-api/user/%s/orders?%s", host, userID.String(), query.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -233,10 +329,10 @@ func getUserOrders(userID uuid.UUID) ([]*UserOrderResponse, error) {
 		return nil, err
 	}
 
-	list := make([]*UserOrderResponse, 0)
-	if err = json.NewDecoder(res.Body).Decode(&list); err != nil {
+	page := new(UserOrdersPageResponse)
+	if err = json.NewDecoder(res.Body).Decode(page); err != nil {
 		return nil, err
 	}
 
-	return list, nil
+	return page, nil
 }
@@ -9,6 +9,8 @@ import (
 	"net/http"
 
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
+
+	"github.com/krocos/coffee-shop/money"
 )
 
 type OrderCompo struct {
@@ -27,7 +29,7 @@ func (c *OrderCompo) Render() app.UI {
 							app.H5().Class("card-title").Text(c.Order.Point.Addr),
 						),
 						app.Div().Class("col", "text-end").Body(
-							app.H5().Class("card-title").Text(fmt.Sprintf("%.2f₽", c.Order.TotalPrice)),
+							app.H5().Class("card-title").Text(money.Format(c.Order.TotalPrice, c.Order.Currency)),
 						),
 					),
 					app.Div().Class("row").Body(
@@ -38,6 +40,15 @@ func (c *OrderCompo) Render() app.UI {
 							statusText(c.Order.Status),
 						),
 					),
+					app.If(c.Order.GiftCardAmount > 0,
+						app.Div().Class("row").Body(
+							app.Div().Class("col").Body(
+								app.Small().Class("text-muted").Text(fmt.Sprintf("Подарочной картой %s, к оплате %s",
+									money.Format(c.Order.GiftCardAmount, c.Order.Currency),
+									money.Format(c.Order.TotalPrice-c.Order.GiftCardAmount, c.Order.Currency))),
+							),
+						),
+					),
 					app.If(c.Order.Status == "waiting_for_payment",
 						app.Div().Class("row").Body(
 							app.Div().Class("col", "text-end").Body(
@@ -67,7 +78,20 @@ func (c *OrderCompo) Render() app.UI {
 					),
 					app.If(true,
 						app.Hr(),
-						&ItemsListCompo{Items: c.Order.Items},
+						&ItemsListCompo{Items: c.Order.Items, Currency: c.Order.Currency},
+					),
+					app.If(len(c.Order.Timeline) > 0,
+						app.Hr(),
+						app.Div().Class("row").Body(
+							app.Div().Class("col").Body(
+								app.Range(c.Order.Timeline).Slice(func(i int) app.UI {
+									event := c.Order.Timeline[i]
+									return app.Div().Class("card-text", "text-muted").Style("font-size", "0.8em").
+										Text(fmt.Sprintf("%s %s%s", event.At.Local().Format("15:04:05"),
+											statusTitle(event.Status), actorText(event.Actor)))
+								}),
+							),
+						),
 					),
 					app.If(len(c.Order.LogItems) > 0,
 						app.Hr(),
@@ -190,6 +214,39 @@ func (c *OrderCompo) simulateUnsuccessfulPayment(ctx app.Context, e app.Event) {
 	}
 }
 
+// statusTitle название статуса для истории заказа.
+func statusTitle(status string) string {
+	switch status {
+	case "waiting_for_payment":
+		return "Создан"
+	case "payment_timeout":
+		return "Таймаут оплаты"
+	case "paid":
+		return "Оплачен"
+	case "payment_canceled":
+		return "Отменён"
+	case "cooking":
+		return "Готовится"
+	case "ready":
+		return "Готов"
+	case "received":
+		return "Отдан"
+	}
+	return status
+}
+
+func actorText(actor string) string {
+	switch actor {
+	case "payment_gateway":
+		return " · платёжный шлюз"
+	case "kitchen":
+		return " · кухня"
+	case "cache":
+		return " · касса"
+	}
+	return ""
+}
+
 func statusText(status string) app.UI {
 	var text app.UI
 	switch status {
@@ -7,7 +7,8 @@ import (
 type OrderListing struct {
 	app.Compo
 
-	Orders []*UserOrderResponse
+	Orders  []*UserOrderResponse
+	HasMore bool
 }
 
 func (l *OrderListing) Render() app.UI {
@@ -20,6 +21,17 @@ func (l *OrderListing) Render() app.UI {
 			app.Range(l.Orders).Slice(func(i int) app.UI {
 				return &OrderCompo{Order: l.Orders[i]}
 			}),
+			app.If(l.HasMore,
+				// Обычно следующая страница подгружается при прокрутке, кнопка на
+				// случай, когда заказов меньше чем на экран.
+				app.Div().Class("text-center", "my-3").Body(
+					app.Button().Class("btn", "btn-outline-secondary", "btn-sm").
+						Text("Показать ещё").
+						OnClick(func(ctx app.Context, e app.Event) {
+							ctx.NewAction("loadMoreOrders")
+						}),
+				),
+			),
 		),
 	)
 }
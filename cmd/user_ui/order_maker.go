@@ -9,6 +9,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
+
+	"github.com/krocos/coffee-shop/money"
 )
 
 type OrderMaker struct {
@@ -19,13 +21,15 @@ type OrderMaker struct {
 	points []*Point
 
 	selectedItems   map[string]*selectedItemState
-	totalPrice      float64
+	totalPrice      int64
+	currency        string
 	selectedPointID uuid.UUID
+	giftCardCode    string
 }
 
 type selectedItemState struct {
 	num   int
-	total float64
+	total int64
 }
 
 func NewUserOrderMaker(userID uuid.UUID, items []*Item, points []*Point) *OrderMaker {
@@ -34,6 +38,11 @@ func NewUserOrderMaker(userID uuid.UUID, items []*Item, points []*Point) *OrderM
 		items:         items,
 		points:        points,
 		selectedItems: make(map[string]*selectedItemState),
+		currency:      money.DefaultCurrency,
+	}
+
+	if len(items) > 0 {
+		m.currency = items[0].Currency
 	}
 
 	for _, item := range items {
@@ -51,7 +60,7 @@ func (m *OrderMaker) Render() app.UI {
 					app.Range(m.items).Slice(func(i int) app.UI {
 						return app.Div().Class("row").Body(
 							app.Div().Class("col-4", "col-sm-4", "col-md-2", "col-lg-2", "text-end").Body(
-								app.P().Text(fmt.Sprintf("%.2f₽", m.items[i].Price)),
+								app.P().Text(money.Format(m.items[i].Price, m.items[i].Currency)),
 							),
 							app.Div().Class("col-5", "col-sm-5", "col-md-4", "col-lg-4").Body(
 								app.P().Text(m.items[i].Title),
@@ -68,7 +77,7 @@ func (m *OrderMaker) Render() app.UI {
 								app.P().Text(fmt.Sprintf("%d", m.selectedItems[m.items[i].ID.String()].num)),
 							),
 							app.Div().Class("col-8", "col-sm-8", "col-md-2", "col-lg-2", "text-end").Body(
-								app.P().Text(fmt.Sprintf("%.2f₽", m.selectedItems[m.items[i].ID.String()].total)),
+								app.P().Text(money.Format(m.selectedItems[m.items[i].ID.String()].total, m.items[i].Currency)),
 							),
 						)
 					}),
@@ -85,7 +94,15 @@ func (m *OrderMaker) Render() app.UI {
 								app.H4().Text("Итого"),
 							),
 							app.Div().Class("col", "text-end").Body(
-								app.H4().Text(fmt.Sprintf("%.2f₽", m.totalPrice)),
+								app.H4().Text(money.Format(m.totalPrice, m.currency)),
+							),
+						),
+						app.Div().Class("row").Body(
+							app.Div().Class("col").Body(
+								app.Input().Type("text").Class("form-control").
+									Attr("placeholder", "Код подарочной карты").
+									Value(m.giftCardCode).OnInput(m.ValueTo(&m.giftCardCode)),
+								app.Br(),
 							),
 						),
 						app.Div().Class("row").Body(
@@ -119,9 +136,10 @@ func (m *OrderMaker) Render() app.UI {
 
 type (
 	CreateOrderRequest struct {
-		UserID  uuid.UUID                 `json:"user_id"`
-		PointID uuid.UUID                 `json:"point_id"`
-		Items   []*InitialItemDataRequest `json:"items"`
+		UserID       uuid.UUID                 `json:"user_id"`
+		PointID      uuid.UUID                 `json:"point_id"`
+		Items        []*InitialItemDataRequest `json:"items"`
+		GiftCardCode string                    `json:"gift_card_code"`
 	}
 	InitialItemDataRequest struct {
 		ID       uuid.UUID `json:"id"`
@@ -131,9 +149,10 @@ type (
 
 func (m *OrderMaker) createNewOrder(ctx app.Context, e app.Event) {
 	req := &CreateOrderRequest{
-		UserID:  m.userID,
-		PointID: m.selectedPointID,
-		Items:   make([]*InitialItemDataRequest, 0),
+		UserID:       m.userID,
+		PointID:      m.selectedPointID,
+		Items:        make([]*InitialItemDataRequest, 0),
+		GiftCardCode: m.giftCardCode,
 	}
 
 	for id, state := range m.selectedItems {
@@ -187,23 +206,24 @@ func (m *OrderMaker) clearSelectedHandler(ctx app.Context, e app.Event) {
 func (m *OrderMaker) clearSelected() {
 	for _, state := range m.selectedItems {
 		state.num = 0
-		state.total = 0.0
+		state.total = 0
 	}
-	m.totalPrice = 0.0
+	m.totalPrice = 0
 	m.selectedPointID = uuid.Nil
+	m.giftCardCode = ""
 }
 
-func (m *OrderMaker) itemPrice(itemID uuid.UUID) float64 {
+func (m *OrderMaker) itemPrice(itemID uuid.UUID) int64 {
 	for _, item := range m.items {
 		if item.ID.String() == itemID.String() {
 			return item.Price
 		}
 	}
-	return 0.0
+	return 0
 }
 
 func (m *OrderMaker) updateTotalPrice() {
-	var total = 0.0
+	var total int64
 	for _, state := range m.selectedItems {
 		total += state.total
 	}
@@ -217,7 +237,7 @@ func (m *OrderMaker) increaseItemNum(ctx app.Context, e app.Event) {
 	if state.num > 5 {
 		state.num = 5
 	}
-	state.total = float64(state.num) * m.itemPrice(itemID)
+	state.total = money.LineTotal(m.itemPrice(itemID), float64(state.num))
 	m.updateTotalPrice()
 }
 
@@ -228,6 +248,6 @@ func (m *OrderMaker) decreaseItemNum(ctx app.Context, e app.Event) {
 	if state.num < 0 {
 		state.num = 0
 	}
-	state.total = float64(state.num) * m.itemPrice(itemID)
+	state.total = money.LineTotal(m.itemPrice(itemID), float64(state.num))
 	m.updateTotalPrice()
 }
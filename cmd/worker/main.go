@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"time"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -9,50 +12,55 @@ import (
 	"go.uber.org/zap/zapcore"
 
 	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/config"
 	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/fiscal"
 	"github.com/krocos/coffee-shop/postgres"
-	"github.com/krocos/coffee-shop/sse"
+	"github.com/krocos/coffee-shop/reconcile"
 	"github.com/krocos/coffee-shop/zapadapter"
 )
 
 func main() {
-	config := zap.NewDevelopmentConfig()
-	config.EncoderConfig.TimeKey = "time"
-	config.EncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
-	config.Level.SetLevel(zap.DebugLevel)
+	var (
+		reconcileEvery    = flag.Duration("reconcile-every", 0, "run scheduled reconcile this often, 0 to leave the schedule as is")
+		reconcileLookback = flag.Duration("reconcile-lookback", 48*time.Hour, "reconcile orders created within this period")
+		reconcileRepair   = flag.Bool("reconcile-repair", false, "repair mismatches found by scheduled reconcile")
+	)
+	cfg := config.MustLoad()
 
-	logger, err := config.Build()
+	logConfig := zap.NewDevelopmentConfig()
+	logConfig.EncoderConfig.TimeKey = "time"
+	logConfig.EncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+	logConfig.Level.SetLevel(zap.DebugLevel)
+
+	logger, err := logConfig.Build()
 	if err != nil {
 		panic(err)
 	}
 
+	// Поиск нужен только сверке, заказы в него пишет релей outbox.
 	search, err := elasticsearch.New(elasticsearch.Config{
-		Index: "coffee_shop_search_index",
-		URL:   "http://localhost:9202",
+		Index: cfg.Elasticsearch.Index,
+		URL:   cfg.Elasticsearch.URL,
 	})
 	if err != nil {
 		panic(err)
 	}
+	defer search.Close()
 
-	db, err := postgres.NewGorm(postgres.GormConfig{
-		Host:     "localhost",
-		Port:     "5442",
-		Database: "postgres",
-		Username: "postgres",
-		Password: "postgres",
-	})
+	db, err := postgres.NewGorm(postgres.GormConfig(cfg.Postgres))
 	if err != nil {
 		panic(err)
 	}
 
-	newSSE, err := sse.NewSSE("http://localhost:7995/send-event")
+	registrar, err := fiscal.NewFileRegistrar(cfg.Fiscal.ReceiptsDir)
 	if err != nil {
 		panic(err)
 	}
 
 	c, err := client.Dial(client.Options{
-		HostPort:  client.DefaultHostPort,
-		Namespace: client.DefaultNamespace,
+		HostPort:  cfg.Temporal.HostPort,
+		Namespace: cfg.Temporal.Namespace,
 		Logger:    zapadapter.NewZapAdapter(logger),
 	})
 	if err != nil {
@@ -60,12 +68,41 @@ func main() {
 	}
 	defer c.Close()
 
-	w := worker.New(c, "coffee", worker.Options{})
+	if err = backend.EnsureSearchAttributes(context.Background(), c, cfg.Temporal.Namespace); err != nil {
+		panic(err)
+	}
+
+	storage := postgres.NewPostgres(db)
+
+	points, err := storage.ListPoints(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	if err = backend.EnsureZReportSchedules(context.Background(), c, cfg.Temporal.TaskQueue, points); err != nil {
+		panic(err)
+	}
+
+	if *reconcileEvery > 0 {
+		if err = reconcile.EnsureSchedule(context.Background(), c, cfg.Temporal.TaskQueue, *reconcileEvery, reconcile.WorkflowParams{
+			Lookback: *reconcileLookback,
+			Repair:   *reconcileRepair,
+		}); err != nil {
+			panic(err)
+		}
+	}
+
+	w := worker.New(c, cfg.Temporal.TaskQueue, worker.Options{})
 
+	// Активити заказов старого кода (IndexOrder, SendNotification и прочие) тут не
+	// регистрируются, начатые на нём заказы доводит старый воркер на своей
+	// очереди, см. backend/versions.go.
 	w.RegisterWorkflow(backend.OrderWorkflow)
-	w.RegisterActivity(postgres.NewPostgres(db))
-	w.RegisterActivity(newSSE)
-	w.RegisterActivity(search)
+	w.RegisterWorkflow(backend.ZReportWorkflow)
+	w.RegisterWorkflow(reconcile.ReconcileWorkflow)
+	w.RegisterActivity(storage)
+	w.RegisterActivity(fiscal.NewFiscal(registrar))
+	w.RegisterActivity(reconcile.NewReconciler(storage, search, c))
 
 	if err = w.Run(worker.InterruptCh()); err != nil {
 		log.Println(err)
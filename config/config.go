@@ -0,0 +1,322 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config настройки всех бинарников кофейни. Каждый берёт из него только то,
+// что ему нужно. Откуда берутся значения, описано у Load. Пример второго
+// окружения рядом с основным в staging.example.json.
+type Config struct {
+	Postgres      Postgres      `json:"postgres"`
+	Elasticsearch Elasticsearch `json:"elasticsearch"`
+	Temporal      Temporal      `json:"temporal"`
+	API           Server        `json:"api"`
+	SSE           Server        `json:"sse"`
+	UI            UI            `json:"ui"`
+	Fiscal        Fiscal        `json:"fiscal"`
+	Outbox        Outbox        `json:"outbox"`
+}
+
+// Postgres поля совпадают с postgres.GormConfig, поэтому его можно получить
+// простым приведением типа. Длительности в файле пишутся строкой, например
+// "30s" или "5m".
+type Postgres struct {
+	Host             string        `json:"host"`
+	Port             string        `json:"port"`
+	Database         string        `json:"database"`
+	Username         string        `json:"username"`
+	Password         string        `json:"password"`
+	SSLMode          string        `json:"ssl_mode"`
+	ApplicationName  string        `json:"application_name"`
+	StatementTimeout time.Duration `json:"statement_timeout"`
+	MaxOpenConns     int           `json:"max_open_conns"`
+	MaxIdleConns     int           `json:"max_idle_conns"`
+	ConnMaxLifetime  time.Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime  time.Duration `json:"conn_max_idle_time"`
+	ReplicaDSNs      []string      `json:"replica_dsns"`
+}
+
+func (p *Postgres) UnmarshalJSON(bb []byte) error {
+	type plain Postgres
+	var raw struct {
+		*plain
+		StatementTimeout *duration `json:"statement_timeout"`
+		ConnMaxLifetime  *duration `json:"conn_max_lifetime"`
+		ConnMaxIdleTime  *duration `json:"conn_max_idle_time"`
+	}
+	raw.plain = (*plain)(p)
+	raw.StatementTimeout = (*duration)(&p.StatementTimeout)
+	raw.ConnMaxLifetime = (*duration)(&p.ConnMaxLifetime)
+	raw.ConnMaxIdleTime = (*duration)(&p.ConnMaxIdleTime)
+
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&raw)
+}
+
+// duration time.Duration, который в json пишется строкой.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(bb []byte) error {
+	var value string
+	if err := json.Unmarshal(bb, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+type Elasticsearch struct {
+	URL   string `json:"url"`
+	Index string `json:"index"`
+}
+
+type Temporal struct {
+	HostPort  string `json:"host_port"`
+	Namespace string `json:"namespace"`
+	TaskQueue string `json:"task_queue"`
+}
+
+// Server на каком адресе сервер слушает и по какому URL до него ходят другие.
+type Server struct {
+	Addr string `json:"addr"`
+	URL  string `json:"url"`
+}
+
+// UI адреса, на которых слушают клиенты пользователя, кухни и кассы.
+type UI struct {
+	UserAddr    string `json:"user_addr"`
+	KitchenAddr string `json:"kitchen_addr"`
+	CacheAddr   string `json:"cache_addr"`
+}
+
+type Fiscal struct {
+	// ReceiptsDir куда файловый регистратор складывает чеки.
+	ReceiptsDir string `json:"receipts_dir"`
+}
+
+// Outbox настройки cmd/outbox_relay: как часто и какими пачками разбирать
+// outbox и как писать в поиск. Длительности в файле пишутся строкой.
+type Outbox struct {
+	// BatchSize сколько заказов разбирать за раз.
+	BatchSize int `json:"batch_size"`
+	// PollInterval как часто заглядывать в outbox, когда он пуст.
+	PollInterval time.Duration `json:"poll_interval"`
+	// MaxBackoff предел паузы между попытками доставить одну запись.
+	MaxBackoff time.Duration `json:"max_backoff"`
+	// MaxAttempts после стольких неудачных попыток запись откладывается насовсем.
+	MaxAttempts int `json:"max_attempts"`
+	// BulkSize, BulkFlushInterval и BulkQueueSize пачки записей в поиск.
+	BulkSize          int           `json:"bulk_size"`
+	BulkFlushInterval time.Duration `json:"bulk_flush_interval"`
+	BulkQueueSize     int           `json:"bulk_queue_size"`
+}
+
+func (o *Outbox) UnmarshalJSON(bb []byte) error {
+	type plain Outbox
+	var raw struct {
+		*plain
+		PollInterval      *duration `json:"poll_interval"`
+		MaxBackoff        *duration `json:"max_backoff"`
+		BulkFlushInterval *duration `json:"bulk_flush_interval"`
+	}
+	raw.plain = (*plain)(o)
+	raw.PollInterval = (*duration)(&o.PollInterval)
+	raw.MaxBackoff = (*duration)(&o.MaxBackoff)
+	raw.BulkFlushInterval = (*duration)(&o.BulkFlushInterval)
+
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(&raw)
+}
+
+// Default настройки для запуска всего на одной машине, как в docker-compose.
+func Default() *Config {
+	return &Config{
+		Postgres: Postgres{
+			Host:     "localhost",
+			Port:     "5442",
+			Database: "postgres",
+			Username: "postgres",
+			Password: "postgres",
+			SSLMode:  "prefer",
+			// StatementTimeout без предела, что бы не обрывать миграции и
+			// переиндексацию. Серверам лучше задать его в конфиге.
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Elasticsearch: Elasticsearch{
+			URL:   "http://localhost:9202",
+			Index: "coffee_shop_search_index",
+		},
+		Temporal: Temporal{
+			HostPort:  "localhost:7233",
+			Namespace: "default",
+			TaskQueue: "coffee",
+		},
+		API: Server{
+			Addr: ":8888",
+			URL:  "http://localhost:8888",
+		},
+		SSE: Server{
+			Addr: ":7995",
+			URL:  "http://localhost:7995",
+		},
+		UI: UI{
+			UserAddr:    ":8090",
+			KitchenAddr: ":8091",
+			CacheAddr:   ":8092",
+		},
+		Fiscal: Fiscal{
+			ReceiptsDir: "receipts",
+		},
+		Outbox: Outbox{
+			BatchSize:         100,
+			PollInterval:      200 * time.Millisecond,
+			MaxBackoff:        time.Minute,
+			MaxAttempts:       100,
+			BulkSize:          100,
+			BulkFlushInterval: 50 * time.Millisecond,
+			BulkQueueSize:     1000,
+		},
+	}
+}
+
+// SendEventURL куда слать уведомления для клиентов.
+func (s Server) SendEventURL() string {
+	return strings.TrimRight(s.URL, "/") + "/send-event"
+}
+
+// Validate проверяет сразу все поля и возвращает все ошибки одной.
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(name string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	check("postgres.host", notEmpty(c.Postgres.Host))
+	check("postgres.port", validPort(c.Postgres.Port))
+	check("postgres.database", notEmpty(c.Postgres.Database))
+	check("postgres.username", notEmpty(c.Postgres.Username))
+	check("postgres.ssl_mode", oneOf(c.Postgres.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"))
+	check("postgres.statement_timeout", notNegative(int64(c.Postgres.StatementTimeout)))
+	check("postgres.max_open_conns", notNegative(int64(c.Postgres.MaxOpenConns)))
+	check("postgres.max_idle_conns", notNegative(int64(c.Postgres.MaxIdleConns)))
+	check("postgres.conn_max_lifetime", notNegative(int64(c.Postgres.ConnMaxLifetime)))
+	check("postgres.conn_max_idle_time", notNegative(int64(c.Postgres.ConnMaxIdleTime)))
+	if c.Postgres.MaxOpenConns > 0 && c.Postgres.MaxIdleConns > c.Postgres.MaxOpenConns {
+		check("postgres.max_idle_conns", fmt.Errorf("must not exceed max_open_conns %d", c.Postgres.MaxOpenConns))
+	}
+	for i, dsn := range c.Postgres.ReplicaDSNs {
+		check(fmt.Sprintf("postgres.replica_dsns[%d]", i), notEmpty(dsn))
+	}
+
+	check("elasticsearch.url", validURL(c.Elasticsearch.URL))
+	check("elasticsearch.index", notEmpty(c.Elasticsearch.Index))
+
+	check("temporal.host_port", validAddr(c.Temporal.HostPort))
+	check("temporal.namespace", notEmpty(c.Temporal.Namespace))
+	check("temporal.task_queue", notEmpty(c.Temporal.TaskQueue))
+
+	check("api.addr", validAddr(c.API.Addr))
+	check("api.url", validURL(c.API.URL))
+	check("sse.addr", validAddr(c.SSE.Addr))
+	check("sse.url", validURL(c.SSE.URL))
+
+	check("ui.user_addr", validAddr(c.UI.UserAddr))
+	check("ui.kitchen_addr", validAddr(c.UI.KitchenAddr))
+	check("ui.cache_addr", validAddr(c.UI.CacheAddr))
+
+	check("fiscal.receipts_dir", notEmpty(c.Fiscal.ReceiptsDir))
+
+	check("outbox.batch_size", positive(int64(c.Outbox.BatchSize)))
+	check("outbox.poll_interval", positive(int64(c.Outbox.PollInterval)))
+	check("outbox.max_backoff", positive(int64(c.Outbox.MaxBackoff)))
+	check("outbox.max_attempts", positive(int64(c.Outbox.MaxAttempts)))
+	check("outbox.bulk_size", positive(int64(c.Outbox.BulkSize)))
+	check("outbox.bulk_flush_interval", positive(int64(c.Outbox.BulkFlushInterval)))
+	check("outbox.bulk_queue_size", positive(int64(c.Outbox.BulkQueueSize)))
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func notEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+func notNegative(value int64) error {
+	if value < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+func positive(value int64) error {
+	if value <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	return nil
+}
+
+func oneOf(value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q must be one of %s", value, strings.Join(allowed, ", "))
+}
+
+func validPort(value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("bad port %q", value)
+	}
+	return nil
+}
+
+// validAddr адрес вида host:port, host можно не указывать.
+func validAddr(value string) error {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return err
+	}
+	return validPort(port)
+}
+
+func validURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http or https url", value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", value)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	envPrefix = "COFFEE_SHOP_"
+	// fileEnv путь к файлу, если не задан флаг -config.
+	fileEnv = envPrefix + "CONFIG"
+)
+
+// override флаг настройки. Значение только запоминается, а применяется после
+// файла и переменных окружения, что бы флаг был главнее них.
+type override struct {
+	defValue string
+	value    *string
+}
+
+func (o *override) String() string {
+	if o.value != nil {
+		return *o.value
+	}
+	return o.defValue
+}
+
+func (o *override) Set(value string) error {
+	o.value = &value
+	return nil
+}
+
+// Load разбирает args в fs и собирает настройки. Значения по возрастанию
+// приоритета:
+//
+//   - умолчания из Default;
+//   - json файл из флага -config или переменной COFFEE_SHOP_CONFIG, в нём
+//     можно указать только часть полей;
+//   - переменные окружения, например COFFEE_SHOP_POSTGRES_HOST;
+//   - флаги, например -postgres-host.
+//
+// Свои флаги команда объявляет в fs до вызова Load. Итог проверяется через
+// Validate.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+	fields := c.fields()
+
+	path := fs.String("config", os.Getenv(fileEnv), fmt.Sprintf("json config file, env %s", fileEnv))
+
+	overrides := make(map[string]*override)
+	fields.VisitAll(func(f *flag.Flag) {
+		o := &override{defValue: f.DefValue}
+		overrides[f.Name] = o
+		fs.Var(o, f.Name, fmt.Sprintf("%s, env %s", f.Usage, envName(f.Name)))
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := c.readFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fields.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err = f.Value.Set(value); err != nil {
+				err = fmt.Errorf("bad %s: %v", envName(f.Name), err)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, o := range overrides {
+		if o.value == nil {
+			continue
+		}
+		if err = fields.Set(name, *o.value); err != nil {
+			return nil, fmt.Errorf("bad -%s: %v", name, err)
+		}
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// MustLoad то же, что Load из командной строки, но падает с ошибкой, как
+// и остальная настройка в командах.
+func MustLoad() *Config {
+	c, err := Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *Config) readFile(path string) error {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %v", err)
+	}
+
+	// Неизвестное поле это скорее всего опечатка, молча её пропускать нельзя.
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(c); err != nil {
+		return fmt.Errorf("parse config %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// fields все настройки в виде флагов, привязанных к полям c. Имя флага
+// это путь к полю через дефис, из него же получается переменная окружения.
+func (c *Config) fields() *flag.FlagSet {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+
+	fs.StringVar(&c.Postgres.Host, "postgres-host", c.Postgres.Host, "postgres host")
+	fs.StringVar(&c.Postgres.Port, "postgres-port", c.Postgres.Port, "postgres port")
+	fs.StringVar(&c.Postgres.Database, "postgres-database", c.Postgres.Database, "postgres database")
+	fs.StringVar(&c.Postgres.Username, "postgres-username", c.Postgres.Username, "postgres user")
+	fs.StringVar(&c.Postgres.Password, "postgres-password", c.Postgres.Password, "postgres password")
+	fs.StringVar(&c.Postgres.SSLMode, "postgres-ssl-mode", c.Postgres.SSLMode, "postgres sslmode")
+	fs.StringVar(&c.Postgres.ApplicationName, "postgres-application-name", c.Postgres.ApplicationName, "application_name for postgres, binary name if empty")
+	fs.DurationVar(&c.Postgres.StatementTimeout, "postgres-statement-timeout", c.Postgres.StatementTimeout, "postgres statement_timeout, 0 for none")
+	fs.IntVar(&c.Postgres.MaxOpenConns, "postgres-max-open-conns", c.Postgres.MaxOpenConns, "postgres pool size, 0 for unlimited")
+	fs.IntVar(&c.Postgres.MaxIdleConns, "postgres-max-idle-conns", c.Postgres.MaxIdleConns, "idle connections kept in the postgres pool")
+	fs.DurationVar(&c.Postgres.ConnMaxLifetime, "postgres-conn-max-lifetime", c.Postgres.ConnMaxLifetime, "close postgres connections older than this, 0 to keep")
+	fs.DurationVar(&c.Postgres.ConnMaxIdleTime, "postgres-conn-max-idle-time", c.Postgres.ConnMaxIdleTime, "close postgres connections idle longer than this, 0 to keep")
+	fs.Var((*listValue)(&c.Postgres.ReplicaDSNs), "postgres-replica-dsns", "comma separated read replica dsns")
+
+	fs.StringVar(&c.Elasticsearch.URL, "elasticsearch-url", c.Elasticsearch.URL, "elasticsearch url")
+	fs.StringVar(&c.Elasticsearch.Index, "elasticsearch-index", c.Elasticsearch.Index, "search index alias")
+
+	fs.StringVar(&c.Temporal.HostPort, "temporal-host-port", c.Temporal.HostPort, "temporal frontend address")
+	fs.StringVar(&c.Temporal.Namespace, "temporal-namespace", c.Temporal.Namespace, "temporal namespace")
+	fs.StringVar(&c.Temporal.TaskQueue, "temporal-task-queue", c.Temporal.TaskQueue, "temporal task queue")
+
+	fs.StringVar(&c.API.Addr, "api-addr", c.API.Addr, "api server listen address")
+	fs.StringVar(&c.API.URL, "api-url", c.API.URL, "api server url for the ui proxies")
+	fs.StringVar(&c.SSE.Addr, "sse-addr", c.SSE.Addr, "sse server listen address")
+	fs.StringVar(&c.SSE.URL, "sse-url", c.SSE.URL, "sse server url for the ui proxies and notifications")
+
+	fs.StringVar(&c.UI.UserAddr, "ui-user-addr", c.UI.UserAddr, "user ui listen address")
+	fs.StringVar(&c.UI.KitchenAddr, "ui-kitchen-addr", c.UI.KitchenAddr, "kitchen ui listen address")
+	fs.StringVar(&c.UI.CacheAddr, "ui-cache-addr", c.UI.CacheAddr, "cache ui listen address")
+
+	fs.StringVar(&c.Fiscal.ReceiptsDir, "fiscal-receipts-dir", c.Fiscal.ReceiptsDir, "directory for issued receipts")
+
+	fs.IntVar(&c.Outbox.BatchSize, "outbox-batch-size", c.Outbox.BatchSize, "orders the outbox relay handles at once")
+	fs.DurationVar(&c.Outbox.PollInterval, "outbox-poll-interval", c.Outbox.PollInterval, "how often the outbox relay polls an empty outbox")
+	fs.DurationVar(&c.Outbox.MaxBackoff, "outbox-max-backoff", c.Outbox.MaxBackoff, "max pause between delivery attempts of one outbox entry")
+	fs.IntVar(&c.Outbox.MaxAttempts, "outbox-max-attempts", c.Outbox.MaxAttempts, "failed attempts before an outbox entry is parked")
+	fs.IntVar(&c.Outbox.BulkSize, "outbox-bulk-size", c.Outbox.BulkSize, "search writes sent in one bulk request")
+	fs.DurationVar(&c.Outbox.BulkFlushInterval, "outbox-bulk-flush-interval", c.Outbox.BulkFlushInterval, "max wait before a partial bulk request is sent")
+	fs.IntVar(&c.Outbox.BulkQueueSize, "outbox-bulk-queue-size", c.Outbox.BulkQueueSize, "search writes waiting for a bulk request")
+
+	return fs
+}
+
+// listValue список через запятую. Новое значение заменяет старое целиком.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*l = list
+	return nil
+}
+
+// envName postgres-host -> COFFEE_SHOP_POSTGRES_HOST.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		// file содержимое json файла, пустое без файла.
+		file string
+		// fileFromEnv путь к файлу передаётся через COFFEE_SHOP_CONFIG, а не -config.
+		fileFromEnv bool
+		env         map[string]string
+		args        []string
+		check       func(t *testing.T, c *Config)
+		wantErr     []string
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, Default(), c)
+			},
+		},
+		{
+			name: "file over defaults",
+			file: `{"postgres": {"host": "file-host", "statement_timeout": "30s"}, "temporal": {"task_queue": "file-queue"}}`,
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, "file-host", c.Postgres.Host)
+				require.Equal(t, 30*time.Second, c.Postgres.StatementTimeout)
+				require.Equal(t, "file-queue", c.Temporal.TaskQueue)
+				// Поля, которых нет в файле, остаются по умолчанию.
+				require.Equal(t, "5442", c.Postgres.Port)
+				require.Equal(t, 30*time.Minute, c.Postgres.ConnMaxLifetime)
+				require.Equal(t, "default", c.Temporal.Namespace)
+			},
+		},
+		{
+			name:        "file from env",
+			file:        `{"api": {"addr": ":9888"}}`,
+			fileFromEnv: true,
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, ":9888", c.API.Addr)
+			},
+		},
+		{
+			name: "env over file",
+			file: `{"postgres": {"host": "file-host"}, "temporal": {"task_queue": "file-queue"}}`,
+			env: map[string]string{
+				"COFFEE_SHOP_POSTGRES_HOST":         "env-host",
+				"COFFEE_SHOP_POSTGRES_REPLICA_DSNS": "host=a, host=b",
+			},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, "env-host", c.Postgres.Host)
+				require.Equal(t, []string{"host=a", "host=b"}, c.Postgres.ReplicaDSNs)
+				require.Equal(t, "file-queue", c.Temporal.TaskQueue)
+			},
+		},
+		{
+			name: "flag over env",
+			file: `{"postgres": {"host": "file-host"}, "temporal": {"task_queue": "file-queue"}}`,
+			env: map[string]string{
+				"COFFEE_SHOP_POSTGRES_HOST":           "env-host",
+				"COFFEE_SHOP_TEMPORAL_TASK_QUEUE":     "env-queue",
+				"COFFEE_SHOP_POSTGRES_MAX_IDLE_CONNS": "5",
+			},
+			args: []string{"-postgres-host", "flag-host", "-postgres-statement-timeout", "1m"},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, "flag-host", c.Postgres.Host)
+				require.Equal(t, time.Minute, c.Postgres.StatementTimeout)
+				require.Equal(t, "env-queue", c.Temporal.TaskQueue)
+				require.Equal(t, 5, c.Postgres.MaxIdleConns)
+			},
+		},
+		{
+			name: "outbox from file and env",
+			file: `{"outbox": {"poll_interval": "1s", "max_attempts": 5}}`,
+			env:  map[string]string{"COFFEE_SHOP_OUTBOX_BULK_FLUSH_INTERVAL": "10ms"},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, time.Second, c.Outbox.PollInterval)
+				require.Equal(t, 5, c.Outbox.MaxAttempts)
+				require.Equal(t, 10*time.Millisecond, c.Outbox.BulkFlushInterval)
+				require.Equal(t, time.Minute, c.Outbox.MaxBackoff)
+			},
+		},
+		{
+			name:    "unknown outbox field in file",
+			file:    `{"outbox": {"batch": 10}}`,
+			wantErr: []string{"parse config", `unknown field "batch"`},
+		},
+		{
+			name:    "zero outbox batch",
+			args:    []string{"-outbox-batch-size", "0"},
+			wantErr: []string{"outbox.batch_size: must be positive"},
+		},
+		{
+			name:    "unknown field in file",
+			file:    `{"postgres": {"hots": "typo"}}`,
+			wantErr: []string{"parse config", `unknown field "hots"`},
+		},
+		{
+			name:    "unknown section in file",
+			file:    `{"redis": {}}`,
+			wantErr: []string{"parse config", `unknown field "redis"`},
+		},
+		{
+			name:    "number duration in file",
+			file:    `{"postgres": {"statement_timeout": 30}}`,
+			wantErr: []string{"parse config", "duration must be a string"},
+		},
+		{
+			name:    "bad env duration",
+			env:     map[string]string{"COFFEE_SHOP_POSTGRES_CONN_MAX_LIFETIME": "forever"},
+			wantErr: []string{"bad COFFEE_SHOP_POSTGRES_CONN_MAX_LIFETIME"},
+		},
+		{
+			name:    "bad flag int",
+			args:    []string{"-postgres-max-open-conns", "many"},
+			wantErr: []string{"bad -postgres-max-open-conns"},
+		},
+		{
+			name:    "bad port",
+			env:     map[string]string{"COFFEE_SHOP_POSTGRES_PORT": "x"},
+			wantErr: []string{"invalid config", `postgres.port: bad port "x"`},
+		},
+		{
+			name:    "idle over open",
+			args:    []string{"-postgres-max-open-conns", "4"},
+			wantErr: []string{"postgres.max_idle_conns: must not exceed max_open_conns 4"},
+		},
+		{
+			name:    "bad ssl mode",
+			file:    `{"postgres": {"ssl_mode": "on"}}`,
+			wantErr: []string{`postgres.ssl_mode: "on" must be one of`},
+		},
+		{
+			name: "all problems at once",
+			args: []string{"-elasticsearch-url", "localhost:9202", "-api-addr", "8888", "-temporal-task-queue", ""},
+			wantErr: []string{
+				"elasticsearch.url:",
+				"api.addr:",
+				"temporal.task_queue: must not be empty",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Переменные окружения машины не должны влиять на тест.
+			t.Setenv(fileEnv, "")
+
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				require.NoError(t, os.WriteFile(path, []byte(tt.file), 0o600))
+				if tt.fileFromEnv {
+					t.Setenv(fileEnv, path)
+				} else {
+					args = append([]string{"-config", path}, args...)
+				}
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+
+			c, err := Load(fs, args)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				for _, want := range tt.wantErr {
+					require.Contains(t, err.Error(), want)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestStagingExample(t *testing.T) {
+	t.Setenv(fileEnv, "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	c, err := Load(fs, []string{"-config", "staging.example.json"})
+	require.NoError(t, err)
+	require.Equal(t, "coffee_staging", c.Postgres.Database)
+	require.Equal(t, 30*time.Second, c.Postgres.StatementTimeout)
+}
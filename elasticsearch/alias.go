@@ -0,0 +1,467 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Индекс заказов версионируется и ротируется: данные лежат в индексах
+// <alias>_v<N>-<поколение>, читаем через алиас <alias>, а пишем через алиас
+// <alias>_write, у которого индекс записи только последнее поколение. Маппинг
+// новых поколений берётся из шаблона индекса, который собирается из mapping.json.
+//
+// Во время миграции маппинга запись дополнительно идёт в индекс новой версии
+// через алиас <alias>_migrate, а алиасы чтения и записи переключаются на новую
+// версию атомарно в самом конце.
+
+// writeIndicesTTL как долго помним, в какие индексы писать. Миграция ждёт дольше
+// этого, что бы все писатели увидели новый индекс.
+const writeIndicesTTL = 5 * time.Second
+
+func (s *Search) migrateAlias() string {
+	return s.indexName + "_migrate"
+}
+
+// versionedIndex первое поколение версии. Номер поколения в конце имени нужен
+// rollover'у, что бы он сам считал имя следующего индекса.
+func (s *Search) versionedIndex(version int) string {
+	return fmt.Sprintf("%s_v%d-000001", s.indexName, version)
+}
+
+// indexVersion разбирает имя индекса на версию и поколение.
+func (s *Search) indexVersion(index string) (version int, generation int, ok bool) {
+	suffix := strings.TrimPrefix(index, s.indexName+"_v")
+	if suffix == index {
+		return 0, 0, false
+	}
+
+	// Индексы до ротации были без поколения.
+	versionPart, generationPart, found := strings.Cut(suffix, "-")
+	generation = 1
+	if found {
+		var err error
+		if generation, err = strconv.Atoi(generationPart); err != nil {
+			return 0, 0, false
+		}
+	}
+
+	version, err := strconv.Atoi(versionPart)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return version, generation, true
+}
+
+func (s *Search) sortByVersion(indices []string) {
+	sort.Slice(indices, func(i, j int) bool {
+		vi, gi, _ := s.indexVersion(indices[i])
+		vj, gj, _ := s.indexVersion(indices[j])
+		if vi != vj {
+			return vi < vj
+		}
+		return gi < gj
+	})
+}
+
+func (s *Search) getAliases(ctx context.Context, alias string) (*elastic.AliasesResult, error) {
+	res, err := s.client.Aliases().Alias(alias).Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get indices of alias %s: %v", alias, err)
+	}
+
+	return res, nil
+}
+
+// aliasIndices отдаёт индексы, на которые указывает алиас, по возрастанию
+// версии и поколения.
+func (s *Search) aliasIndices(ctx context.Context, alias string) ([]string, error) {
+	res, err := s.getAliases(ctx, alias)
+	if err != nil || res == nil {
+		return nil, err
+	}
+
+	indices := res.IndicesByAlias(alias)
+	s.sortByVersion(indices)
+
+	return indices, nil
+}
+
+// aliasWriteIndex индекс записи алиаса или пустая строка, если алиаса нет.
+func (s *Search) aliasWriteIndex(ctx context.Context, alias string) (string, error) {
+	res, err := s.getAliases(ctx, alias)
+	if err != nil || res == nil {
+		return "", err
+	}
+
+	indices := res.IndicesByAlias(alias)
+	if len(indices) == 1 {
+		return indices[0], nil
+	}
+
+	for _, index := range indices {
+		for _, a := range res.Indices[index].Aliases {
+			if a.AliasName == alias && a.IsWriteIndex {
+				return index, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("alias %s points to %d indices without a write index", alias, len(indices))
+}
+
+// versionedIndices все версии и поколения индекса, которые есть в кластере.
+func (s *Search) versionedIndices(ctx context.Context) ([]string, error) {
+	names, err := s.client.IndexNames()
+	if err != nil {
+		return nil, fmt.Errorf("list indices: %v", err)
+	}
+
+	indices := make([]string, 0)
+	for _, name := range names {
+		if _, _, ok := s.indexVersion(name); ok {
+			indices = append(indices, name)
+		}
+	}
+	s.sortByVersion(indices)
+
+	return indices, nil
+}
+
+// writeIndices индексы, в которые надо писать: текущее поколение и, во время
+// миграции, индекс новой версии. Если алиаса записи нет (индекс создан до
+// версионирования), то пишем напрямую в индекс с именем алиаса.
+func (s *Search) writeIndices(ctx context.Context) ([]string, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if time.Since(s.writeIndicesAt) < writeIndicesTTL && len(s.writeIndicesCache) > 0 {
+		return s.writeIndicesCache, nil
+	}
+
+	index, err := s.aliasWriteIndex(ctx, s.writeAlias)
+	if err != nil {
+		return nil, err
+	}
+	if index == "" {
+		index = s.indexName
+	}
+	indices := []string{index}
+
+	migrating, err := s.aliasWriteIndex(ctx, s.migrateAlias())
+	if err != nil {
+		return nil, err
+	}
+	if migrating != "" {
+		indices = append(indices, migrating)
+	}
+
+	s.writeIndicesCache = indices
+	s.writeIndicesAt = time.Now()
+
+	return indices, nil
+}
+
+// orderIndex ищет индекс, в котором лежит заказ. Нужен для обновления заказов,
+// которые остались в прошлых поколениях.
+func (s *Search) orderIndex(ctx context.Context, id string) (string, error) {
+	res, err := s.client.Search(s.indexName).
+		Query(elastic.NewIdsQuery().Ids(id)).
+		Size(1).
+		FetchSource(false).
+		Do(ctx)
+	if err != nil {
+		return "", fmt.Errorf("find index of order %s: %v", id, err)
+	}
+	if len(res.Hits.Hits) == 0 {
+		return "", nil
+	}
+
+	return res.Hits.Hits[0].Index, nil
+}
+
+// orderIndices индексы, в которых лежат заказы, по идентификаторам. Если заказ
+// по ошибке есть в нескольких поколениях, то берётся последнее.
+func (s *Search) orderIndices(ctx context.Context, ids []string) (map[string]string, error) {
+	res, err := s.client.Search(s.indexName).
+		Query(elastic.NewIdsQuery().Ids(ids...)).
+		Size(len(ids) * 2).
+		FetchSource(false).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find indices of %d orders: %v", len(ids), err)
+	}
+
+	indices := make(map[string]string)
+	for _, hit := range res.Hits.Hits {
+		if index, ok := indices[hit.Id]; ok {
+			pair := []string{index, hit.Index}
+			s.sortByVersion(pair)
+			indices[hit.Id] = pair[1]
+			continue
+		}
+		indices[hit.Id] = hit.Index
+	}
+
+	return indices, nil
+}
+
+// putTemplate сохраняет шаблон, по которому создаются новые поколения индекса.
+func (s *Search) putTemplate(ctx context.Context) error {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(s.mapping), &body); err != nil {
+		return fmt.Errorf("parse mapping: %v", err)
+	}
+
+	if _, err := s.client.IndexPutIndexTemplate(s.indexName).BodyJson(map[string]interface{}{
+		"index_patterns": []string{s.indexName + "_v*"},
+		"template":       body,
+	}).Do(ctx); err != nil {
+		return fmt.Errorf("put index template %s: %v", s.indexName, err)
+	}
+
+	return nil
+}
+
+// CurrentVersion версия индекса, на который указывает алиас чтения, или 0,
+// если индексов ещё нет.
+func (s *Search) CurrentVersion(ctx context.Context) (int, error) {
+	indices, err := s.aliasIndices(ctx, s.indexName)
+	if err != nil {
+		return 0, err
+	}
+	if len(indices) == 0 {
+		return 0, nil
+	}
+
+	version, _, ok := s.indexVersion(indices[len(indices)-1])
+	if !ok {
+		return 0, fmt.Errorf("alias %s points to unversioned index %s", s.indexName, indices[len(indices)-1])
+	}
+
+	return version, nil
+}
+
+// Rollover начинает новое поколение индекса, если текущее старше maxAge или в
+// нём больше maxDocs заказов. Пустые условия ротируют индекс безусловно.
+// Новое поколение сразу добавляется в алиас чтения. Индекс, созданный до
+// ротации (без номера поколения в имени), надо сначала перенести через Migrate.
+func (s *Search) Rollover(ctx context.Context, maxAge string, maxDocs int64) (string, bool, error) {
+	if err := s.putTemplate(ctx); err != nil {
+		return "", false, err
+	}
+
+	conditions := make(map[string]interface{})
+	if maxAge != "" {
+		conditions["max_age"] = maxAge
+	}
+	if maxDocs > 0 {
+		conditions["max_docs"] = maxDocs
+	}
+
+	res, err := s.client.RolloverIndex(s.writeAlias).BodyJson(map[string]interface{}{
+		"conditions": conditions,
+		"aliases": map[string]interface{}{
+			s.indexName: map[string]interface{}{},
+		},
+	}).Do(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("rollover %s: %v", s.writeAlias, err)
+	}
+
+	s.writeMu.Lock()
+	s.writeIndicesAt = time.Time{}
+	s.writeMu.Unlock()
+
+	return res.NewIndex, res.RolledOver, nil
+}
+
+// ApplyRetention удаляет поколения индекса, в которые перестали писать больше
+// months месяцев назад. С archive индекс не удаляется, а убирается из алиасов и
+// закрывается, его можно открыть и вернуть в алиас руками. Текущее поколение
+// не трогается никогда.
+func (s *Search) ApplyRetention(ctx context.Context, months int, archive bool, logf func(format string, args ...interface{})) error {
+	if months <= 0 {
+		return fmt.Errorf("retention must be at least one month, got %d", months)
+	}
+
+	indices, err := s.aliasIndices(ctx, s.indexName)
+	if err != nil {
+		return err
+	}
+	if len(indices) < 2 {
+		return nil
+	}
+
+	settings, err := s.client.IndexGetSettings(indices...).FlatSettings(true).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("get settings of %s: %v", strings.Join(indices, ", "), err)
+	}
+
+	createdAt := make(map[string]time.Time)
+	for _, index := range indices {
+		res, ok := settings[index]
+		if !ok {
+			return fmt.Errorf("no settings for index %s", index)
+		}
+		raw, _ := res.Settings["index.creation_date"].(string)
+		ms, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse creation date %q of %s: %v", raw, index, err)
+		}
+		createdAt[index] = time.UnixMilli(ms)
+	}
+
+	writeIndices, err := s.aliasIndices(ctx, s.writeAlias)
+	if err != nil {
+		return err
+	}
+	inWriteAlias := make(map[string]bool)
+	for _, index := range writeIndices {
+		inWriteAlias[index] = true
+	}
+
+	cutoff := time.Now().AddDate(0, -months, 0)
+
+	// В поколение перестали писать, когда создали следующее.
+	for i, index := range indices[:len(indices)-1] {
+		closedAt := createdAt[indices[i+1]]
+		if !closedAt.Before(cutoff) {
+			continue
+		}
+
+		if !archive {
+			logf("delete %s, last written at %s", index, closedAt.Format(time.RFC3339))
+			if _, err = s.client.DeleteIndex(index).Do(ctx); err != nil {
+				return fmt.Errorf("delete index %s: %v", index, err)
+			}
+			continue
+		}
+
+		logf("archive %s, last written at %s", index, closedAt.Format(time.RFC3339))
+		actions := []elastic.AliasAction{elastic.NewAliasRemoveAction(s.indexName).Index(index)}
+		if inWriteAlias[index] {
+			actions = append(actions, elastic.NewAliasRemoveAction(s.writeAlias).Index(index))
+		}
+		if _, err = s.client.Alias().Action(actions...).Do(ctx); err != nil {
+			return fmt.Errorf("remove %s from aliases: %v", index, err)
+		}
+		if _, err = s.client.CloseIndex(index).Do(ctx); err != nil {
+			return fmt.Errorf("close index %s: %v", index, err)
+		}
+	}
+
+	return nil
+}
+
+// Migrate переносит заказы в индекс следующей версии с текущим маппингом без
+// простоя: создаёт новый индекс, включает запись в оба индекса, переливает в
+// новый индекс документы из всех поколений старой версии и атомарно
+// переключает алиасы. С deleteOld старые индексы удаляются после переключения
+// и ещё одного ожидания писателей.
+func (s *Search) Migrate(ctx context.Context, deleteOld bool, logf func(format string, args ...interface{})) error {
+	current, err := s.CurrentVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return fmt.Errorf("alias %s does not exist, create index first", s.indexName)
+	}
+
+	oldIndices, err := s.aliasIndices(ctx, s.indexName)
+	if err != nil {
+		return err
+	}
+	oldWriteIndices, err := s.aliasIndices(ctx, s.writeAlias)
+	if err != nil {
+		return err
+	}
+	newIndex := s.versionedIndex(current + 1)
+
+	if err = s.putTemplate(ctx); err != nil {
+		return err
+	}
+
+	logf("create %s", newIndex)
+	if _, err = s.client.CreateIndex(newIndex).BodyString(s.mapping).Do(ctx); err != nil {
+		return fmt.Errorf("create index %s: %v", newIndex, err)
+	}
+
+	logf("add %s to %s, writes go to both %s and %s", newIndex, s.migrateAlias(), oldIndices[len(oldIndices)-1], newIndex)
+	if _, err = s.client.Alias().Add(newIndex, s.migrateAlias()).Do(ctx); err != nil {
+		return fmt.Errorf("add %s to alias %s: %v", newIndex, s.migrateAlias(), err)
+	}
+
+	// Ждём пока все писатели перечитают алиасы, иначе часть записей попадёт
+	// только в старый индекс уже после переливки.
+	select {
+	case <-time.After(2 * writeIndicesTTL):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	logf("backfill %s from %s", newIndex, strings.Join(oldIndices, ", "))
+
+	// Документы, которые уже записаны в новый индекс, новее переливаемых, поэтому
+	// создаём только отсутствующие, а конфликты пропускаем.
+	res, err := s.client.Reindex().
+		Source(elastic.NewReindexSource().Index(oldIndices...)).
+		Destination(elastic.NewReindexDestination().Index(newIndex).OpType("create")).
+		Conflicts("proceed").
+		WaitForCompletion(true).
+		Refresh("true").
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("backfill %s: %v", newIndex, err)
+	}
+	if len(res.Failures) > 0 {
+		return fmt.Errorf("backfill %s: %d failures, first: %v", newIndex, len(res.Failures), res.Failures[0])
+	}
+
+	logf("backfilled %d documents, %d already there", res.Created, res.VersionConflicts)
+
+	logf("switch %s and %s to %s", s.indexName, s.writeAlias, newIndex)
+	actions := []elastic.AliasAction{
+		elastic.NewAliasRemoveAction(s.indexName).Index(oldIndices...),
+		elastic.NewAliasAddAction(s.indexName).Index(newIndex),
+		elastic.NewAliasAddAction(s.writeAlias).Index(newIndex).IsWriteIndex(true),
+		elastic.NewAliasRemoveAction(s.migrateAlias()).Index(newIndex),
+	}
+	if len(oldWriteIndices) > 0 {
+		actions = append(actions, elastic.NewAliasRemoveAction(s.writeAlias).Index(oldWriteIndices...))
+	}
+	if _, err = s.client.Alias().Action(actions...).Do(ctx); err != nil {
+		return fmt.Errorf("switch aliases to %s: %v", newIndex, err)
+	}
+
+	if deleteOld {
+		// Писатели ещё до writeIndicesTTL помнят старые индексы. Запись в уже
+		// удалённый индекс создала бы его заново по шаблону без алиасов, и
+		// заказ пропал бы из чтения, поэтому ждём, как и перед переливкой.
+		logf("wait for writers to leave %s", strings.Join(oldIndices, ", "))
+		select {
+		case <-time.After(2 * writeIndicesTTL):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		for _, index := range oldIndices {
+			logf("delete %s", index)
+			if _, err = s.client.DeleteIndex(index).Do(ctx); err != nil {
+				return fmt.Errorf("delete index %s: %v", index, err)
+			}
+		}
+	}
+
+	return nil
+}
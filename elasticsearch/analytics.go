@@ -0,0 +1,255 @@
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+)
+
+// paidStatuses заказы, за которые получены деньги. Так же считает выручку и
+// Z-отчёт в постгресе.
+var paidStatuses = []interface{}{"paid", "cooking", "ready", "received"}
+
+// AnalyticsFilter период и, если задана, точка. To не включительно.
+type AnalyticsFilter struct {
+	From    time.Time
+	To      time.Time
+	PointID uuid.UUID
+}
+
+func (f AnalyticsFilter) query() *elastic.BoolQuery {
+	query := elastic.NewBoolQuery().Filter(elastic.NewRangeQuery("created_at").
+		Gte(f.From.Format(time.RFC3339)).
+		Lt(f.To.Format(time.RFC3339)))
+
+	if f.PointID != uuid.Nil {
+		query.Filter(elastic.NewTermQuery("point.id", f.PointID.String()))
+	}
+
+	return query
+}
+
+func paidFilter() elastic.Query {
+	return elastic.NewTermsQuery("status", paidStatuses...)
+}
+
+func sumValue(aggs elastic.Aggregations, name string) int64 {
+	sum, ok := aggs.Sum(name)
+	if !ok || sum.Value == nil {
+		return 0
+	}
+
+	return int64(*sum.Value)
+}
+
+type PointTimezone struct {
+	ID       uuid.UUID
+	Timezone string
+}
+
+type RevenueParams struct {
+	From time.Time
+	To   time.Time
+	// Interval day или hour, границы считаются в часовом поясе точки.
+	Interval string
+	Points   []PointTimezone
+}
+
+type RevenueBucket struct {
+	Start       string `json:"start"`
+	OrdersCount int64  `json:"orders_count"`
+	PaidCount   int64  `json:"paid_count"`
+	Revenue     int64  `json:"revenue"`
+}
+
+type PointRevenue struct {
+	PointID     uuid.UUID        `json:"point_id"`
+	Timezone    string           `json:"timezone"`
+	OrdersCount int64            `json:"orders_count"`
+	PaidCount   int64            `json:"paid_count"`
+	Revenue     int64            `json:"revenue"`
+	Buckets     []*RevenueBucket `json:"buckets"`
+}
+
+// paidAggregation количество оплаченных заказов и выручка по ним.
+func paidAggregation() *elastic.FilterAggregation {
+	return elastic.NewFilterAggregation().
+		Filter(paidFilter()).
+		SubAggregation("revenue", elastic.NewSumAggregation().Field("total_price"))
+}
+
+// Revenue выручка и количество заказов по точкам с разбивкой по дням или часам.
+// У каждой точки свой часовой пояс, поэтому на точку своя гистограмма.
+func (s *Search) Revenue(ctx context.Context, params RevenueParams) ([]*PointRevenue, error) {
+	searchService := s.client.Search(s.indexName).
+		Query(AnalyticsFilter{From: params.From, To: params.To}.query()).
+		Size(0)
+
+	for _, point := range params.Points {
+		histogram := elastic.NewDateHistogramAggregation().
+			Field("created_at").
+			CalendarInterval(params.Interval).
+			TimeZone(point.Timezone).
+			Format("yyyy-MM-dd'T'HH:mm:ssXXX").
+			MinDocCount(0).
+			ExtendedBounds(params.From.Format(time.RFC3339), params.To.Add(-time.Millisecond).Format(time.RFC3339)).
+			SubAggregation("paid", paidAggregation())
+
+		searchService.Aggregation(point.ID.String(), elastic.NewFilterAggregation().
+			Filter(elastic.NewTermQuery("point.id", point.ID.String())).
+			SubAggregation("paid", paidAggregation()).
+			SubAggregation("histogram", histogram))
+	}
+
+	res, err := searchService.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate revenue: %v", err)
+	}
+
+	list := make([]*PointRevenue, 0)
+	for _, point := range params.Points {
+		agg, ok := res.Aggregations.Filter(point.ID.String())
+		if !ok {
+			return nil, fmt.Errorf("no revenue aggregation for point %s", point.ID)
+		}
+
+		pointRevenue := &PointRevenue{
+			PointID:     point.ID,
+			Timezone:    point.Timezone,
+			OrdersCount: agg.DocCount,
+			Buckets:     make([]*RevenueBucket, 0),
+		}
+		if paid, ok := agg.Filter("paid"); ok {
+			pointRevenue.PaidCount = paid.DocCount
+			pointRevenue.Revenue = sumValue(paid.Aggregations, "revenue")
+		}
+
+		if histogram, ok := agg.DateHistogram("histogram"); ok {
+			for _, b := range histogram.Buckets {
+				bucket := &RevenueBucket{OrdersCount: b.DocCount}
+				if b.KeyAsString != nil {
+					bucket.Start = *b.KeyAsString
+				}
+				if paid, ok := b.Filter("paid"); ok {
+					bucket.PaidCount = paid.DocCount
+					bucket.Revenue = sumValue(paid.Aggregations, "revenue")
+				}
+				pointRevenue.Buckets = append(pointRevenue.Buckets, bucket)
+			}
+		}
+
+		list = append(list, pointRevenue)
+	}
+
+	return list, nil
+}
+
+type TopItem struct {
+	ItemID   string  `json:"item_id"`
+	Title    string  `json:"title"`
+	Quantity float64 `json:"quantity"`
+	Revenue  int64   `json:"revenue"`
+	Orders   int64   `json:"orders"`
+}
+
+// TopItems самые продаваемые позиции оплаченных заказов. byRevenue сортирует по
+// выручке, иначе по количеству.
+func (s *Search) TopItems(ctx context.Context, filter AnalyticsFilter, byRevenue bool, limit int) ([]*TopItem, error) {
+	orderBy := "quantity"
+	if byRevenue {
+		orderBy = "revenue"
+	}
+
+	items := elastic.NewTermsAggregation().
+		Field("items.item_id").
+		Size(limit).
+		OrderByAggregation(orderBy, false).
+		SubAggregation("quantity", elastic.NewSumAggregation().Field("items.quantity")).
+		SubAggregation("revenue", elastic.NewSumAggregation().Field("items.total_price")).
+		SubAggregation("title", elastic.NewTermsAggregation().Field("items.title.keyword").Size(1)).
+		SubAggregation("orders", elastic.NewReverseNestedAggregation())
+
+	res, err := s.client.Search(s.indexName).
+		Query(filter.query().Filter(paidFilter())).
+		Size(0).
+		Aggregation("items", elastic.NewNestedAggregation().Path("items").SubAggregation("top", items)).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate top items: %v", err)
+	}
+
+	list := make([]*TopItem, 0)
+
+	nested, ok := res.Aggregations.Nested("items")
+	if !ok {
+		return list, nil
+	}
+	top, ok := nested.Terms("top")
+	if !ok {
+		return list, nil
+	}
+
+	for _, b := range top.Buckets {
+		item := &TopItem{
+			ItemID:  fmt.Sprint(b.Key),
+			Revenue: sumValue(b.Aggregations, "revenue"),
+		}
+		if quantity, ok := b.Sum("quantity"); ok && quantity.Value != nil {
+			item.Quantity = *quantity.Value
+		}
+		if title, ok := b.Terms("title"); ok && len(title.Buckets) > 0 {
+			item.Title = fmt.Sprint(title.Buckets[0].Key)
+		}
+		if orders, ok := b.ReverseNested("orders"); ok {
+			item.Orders = orders.DocCount
+		}
+		list = append(list, item)
+	}
+
+	return list, nil
+}
+
+type SalesSummary struct {
+	OrdersCount        int64   `json:"orders_count"`
+	PaidCount          int64   `json:"paid_count"`
+	Revenue            int64   `json:"revenue"`
+	AverageOrderValue  int64   `json:"average_order_value"`
+	PaymentTimeouts    int64   `json:"payment_timeouts"`
+	PaymentTimeoutRate float64 `json:"payment_timeout_rate"`
+}
+
+// SalesSummary итоги за период: средний чек по оплаченным заказам и доля
+// заказов, которые не оплатили вовремя.
+func (s *Search) SalesSummary(ctx context.Context, filter AnalyticsFilter) (*SalesSummary, error) {
+	res, err := s.client.Search(s.indexName).
+		Query(filter.query()).
+		Size(0).
+		TrackTotalHits(true).
+		Aggregation("paid", paidAggregation().SubAggregation("average", elastic.NewAvgAggregation().Field("total_price"))).
+		Aggregation("payment_timeouts", elastic.NewFilterAggregation().Filter(elastic.NewTermQuery("status", "payment_timeout"))).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate sales summary: %v", err)
+	}
+
+	summary := &SalesSummary{OrdersCount: res.TotalHits()}
+
+	if agg, ok := res.Aggregations.Filter("paid"); ok {
+		summary.PaidCount = agg.DocCount
+		summary.Revenue = sumValue(agg.Aggregations, "revenue")
+		if avg, ok := agg.Avg("average"); ok && avg.Value != nil {
+			summary.AverageOrderValue = int64(*avg.Value + 0.5)
+		}
+	}
+	if agg, ok := res.Aggregations.Filter("payment_timeouts"); ok {
+		summary.PaymentTimeouts = agg.DocCount
+	}
+	if summary.OrdersCount > 0 {
+		summary.PaymentTimeoutRate = float64(summary.PaymentTimeouts) / float64(summary.OrdersCount)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,166 @@
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+const (
+	defaultBulkSize          = 100
+	defaultBulkFlushInterval = 50 * time.Millisecond
+	defaultBulkQueueSize     = 1000
+	bulkRequestTimeout       = 30 * time.Second
+)
+
+// bulkOp одна запись, которая ждёт отправки пачкой.
+type bulkOp struct {
+	request elastic.BulkableRequest
+	// waitFor запись должна стать видна поиску до ответа, потому что сразу за
+	// ней клиент перечитает список заказов.
+	waitFor bool
+	result  chan error
+}
+
+// bulkWriter собирает записи из всех активити в пачки и отправляет их одним
+// bulk-запросом, когда набралось size записей или прошло interval с первой
+// записи в пачке. Очередь ограничена, если эластик не успевает, то писатели
+// ждут места в очереди.
+type bulkWriter struct {
+	client   *elastic.Client
+	size     int
+	interval time.Duration
+	queue    chan *bulkOp
+	done     chan struct{}
+}
+
+func newBulkWriter(client *elastic.Client, size int, interval time.Duration, queueSize int) *bulkWriter {
+	if size <= 0 {
+		size = defaultBulkSize
+	}
+	if interval <= 0 {
+		interval = defaultBulkFlushInterval
+	}
+	if queueSize <= 0 {
+		queueSize = defaultBulkQueueSize
+	}
+
+	w := &bulkWriter{
+		client:   client,
+		size:     size,
+		interval: interval,
+		queue:    make(chan *bulkOp, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// submit ставит запись в очередь и ждёт результата именно по ней.
+func (w *bulkWriter) submit(ctx context.Context, request elastic.BulkableRequest, waitFor bool) error {
+	op := &bulkOp{
+		request: request,
+		waitFor: waitFor,
+		result:  make(chan error, 1),
+	}
+
+	select {
+	case w.queue <- op:
+	case <-ctx.Done():
+		return fmt.Errorf("wait for bulk queue: %v", ctx.Err())
+	}
+
+	select {
+	case err := <-op.result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("wait for bulk result: %v", ctx.Err())
+	}
+}
+
+// close отправляет то, что осталось в очереди. После него писать нельзя.
+func (w *bulkWriter) close() {
+	close(w.queue)
+	<-w.done
+}
+
+func (w *bulkWriter) run() {
+	defer close(w.done)
+
+	batch := make([]*bulkOp, 0, w.size)
+	var flushAt <-chan time.Time
+
+	for {
+		select {
+		case op, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+
+			if len(batch) == 0 {
+				flushAt = time.After(w.interval)
+			}
+			batch = append(batch, op)
+
+			if len(batch) >= w.size {
+				w.flush(batch)
+				batch, flushAt = batch[:0], nil
+			}
+		case <-flushAt:
+			w.flush(batch)
+			batch, flushAt = batch[:0], nil
+		}
+	}
+}
+
+func (w *bulkWriter) flush(batch []*bulkOp) {
+	if len(batch) == 0 {
+		return
+	}
+
+	bulk := w.client.Bulk()
+	for _, op := range batch {
+		bulk.Add(op.request)
+		if op.waitFor {
+			bulk.Refresh("wait_for")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bulkRequestTimeout)
+	defer cancel()
+
+	res, err := bulk.Do(ctx)
+	if err != nil {
+		for _, op := range batch {
+			op.result <- fmt.Errorf("bulk request of %d operations: %v", len(batch), err)
+		}
+		return
+	}
+
+	// Ответы по документам идут в том же порядке, что и запросы.
+	for i, op := range batch {
+		if i >= len(res.Items) {
+			op.result <- fmt.Errorf("no bulk response for operation %d of %d", i+1, len(batch))
+			continue
+		}
+		op.result <- bulkItemError(res.Items[i])
+	}
+}
+
+// bulkItemError превращает ответ по документу в ошибку, с которой работают
+// elastic.IsNotFound и elastic.IsConflict.
+func bulkItemError(item map[string]*elastic.BulkResponseItem) error {
+	for _, res := range item {
+		if res.Status >= 200 && res.Status < 300 {
+			return nil
+		}
+		return &elastic.Error{Status: res.Status, Details: res.Error}
+	}
+
+	return fmt.Errorf("empty bulk response item")
+}
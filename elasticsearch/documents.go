@@ -0,0 +1,66 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// StoredOrder документ заказа и индекс, в котором он лежит.
+type StoredOrder struct {
+	Index string
+	Order *Order
+}
+
+// GetOrders документы заказов по идентификаторам. Заказов, которых нет в
+// поиске, нет и в ответе.
+func (s *Search) GetOrders(ctx context.Context, ids []string) (map[string]*StoredOrder, error) {
+	found := make(map[string]*StoredOrder)
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	res, err := s.client.Search(s.indexName).
+		Query(elastic.NewIdsQuery().Ids(ids...)).
+		Size(len(ids)).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get %d orders: %v", len(ids), err)
+	}
+
+	for _, hit := range res.Hits.Hits {
+		doc := new(Order)
+		if err = json.Unmarshal(hit.Source, doc); err != nil {
+			return nil, fmt.Errorf("parse order %s: %v", hit.Id, err)
+		}
+		found[hit.Id] = &StoredOrder{Index: hit.Index, Order: doc}
+	}
+
+	return found, nil
+}
+
+// ReplaceOrder перезаписывает документ заказа целиком там, где он лежит, или
+// создаёт его в текущем индексе записи.
+func (s *Search) ReplaceOrder(ctx context.Context, doc *Order) error {
+	index, err := s.orderIndex(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	indices := []string{index}
+	if index == "" {
+		if indices, err = s.writeIndices(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, index := range indices {
+		if _, err = s.client.Index().Index(index).Id(doc.ID).BodyJson(doc).Do(ctx); err != nil {
+			return fmt.Errorf("replace order %s in %s: %v", doc.ID, index, err)
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,16 @@
 package elasticsearch
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/olivere/elastic/v7"
@@ -12,15 +18,22 @@ import (
 
 type (
 	Order struct {
-		ID         string       `json:"id,omitempty"`
-		CreatedAt  string       `json:"created_at,omitempty"`
-		Status     string       `json:"status,omitempty"`
-		TotalPrice float64      `json:"total_price,omitempty"`
-		PINCode    string       `json:"pin_code,omitempty"`
-		User       *User        `json:"user,omitempty"`
-		Point      *Point       `json:"point,omitempty"`
-		Items      []*OrderItem `json:"items,omitempty"`
-		LogItems   []*LogItem   `json:"log_items,omitempty"`
+		ID             string       `json:"id,omitempty"`
+		CreatedAt      string       `json:"created_at,omitempty"`
+		Status         string       `json:"status,omitempty"`
+		TotalPrice     int64        `json:"total_price,omitempty"`
+		Currency       string       `json:"currency,omitempty"`
+		VATAmount      int64        `json:"vat_amount,omitempty"`
+		GiftCardAmount int64        `json:"gift_card_amount,omitempty"`
+		PINCode        string       `json:"pin_code,omitempty"`
+		User           *User        `json:"user,omitempty"`
+		Point          *Point       `json:"point,omitempty"`
+		Items          []*OrderItem `json:"items,omitempty"`
+		LogItems       []*LogItem   `json:"log_items,omitempty"`
+		// Timeline смены статуса заказа по порядку.
+		Timeline []*TimelineEvent `json:"timeline,omitempty"`
+		// Version версия заказа в воркфлоу, более старые записи отбрасываются.
+		Version int64 `json:"version,omitempty"`
 	}
 	User struct {
 		ID   string `json:"id,omitempty"`
@@ -35,10 +48,12 @@ type (
 	OrderItem struct {
 		ID         string  `json:"id,omitempty"`
 		Title      string  `json:"title,omitempty"`
-		Price      float64 `json:"price,omitempty"`
+		Price      int64   `json:"price,omitempty"`
 		ItemID     string  `json:"item_id,omitempty"`
 		Quantity   float64 `json:"quantity,omitempty"`
-		TotalPrice float64 `json:"total_price,omitempty"`
+		TotalPrice int64   `json:"total_price,omitempty"`
+		VATRate    int     `json:"vat_rate,omitempty"`
+		VATAmount  int64   `json:"vat_amount,omitempty"`
 		OrderID    string  `json:"order_id,omitempty"`
 	}
 	LogItem struct {
@@ -46,17 +61,37 @@ type (
 		Text    string `json:"text,omitempty"`
 		OrderID string `json:"order_id,omitempty"`
 	}
+	TimelineEvent struct {
+		Status  string          `json:"status,omitempty"`
+		At      string          `json:"at,omitempty"`
+		Actor   string          `json:"actor,omitempty"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
 )
 
 type Config struct {
+	// Index имя алиаса чтения, сами индексы называются <Index>_v<N>-<поколение>.
 	Index string
 	URL   string
+
+	// Записи заказов из воркфлоу копятся и уходят пачками по BulkSize штук или
+	// раз в BulkFlushInterval. В очереди ждут не больше BulkQueueSize записей.
+	// Нули означают значения по умолчанию.
+	BulkSize          int
+	BulkFlushInterval time.Duration
+	BulkQueueSize     int
 }
 
 type Search struct {
-	indexName string
-	mapping   string
-	client    *elastic.Client
+	indexName  string
+	writeAlias string
+	mapping    string
+	client     *elastic.Client
+	bulk       *bulkWriter
+
+	writeMu           sync.Mutex
+	writeIndicesCache []string
+	writeIndicesAt    time.Time
 }
 
 //go:embed mapping.json
@@ -65,8 +100,9 @@ var mapping string
 func New(config Config) (*Search, error) {
 	var err error
 	s := &Search{
-		indexName: config.Index,
-		mapping:   mapping,
+		indexName:  config.Index,
+		writeAlias: config.Index + "_write",
+		mapping:    mapping,
 	}
 
 	s.client, err = elastic.NewClient(
@@ -77,9 +113,16 @@ func New(config Config) (*Search, error) {
 		return nil, fmt.Errorf("make new elasticsearch client: %v", err)
 	}
 
+	s.bulk = newBulkWriter(s.client, config.BulkSize, config.BulkFlushInterval, config.BulkQueueSize)
+
 	return s, nil
 }
 
+// Close дописывает накопленные записи заказов.
+func (s *Search) Close() {
+	s.bulk.close()
+}
+
 func (s *Search) IsExists(ctx context.Context) (bool, error) {
 	exists, err := s.client.IndexExists(s.indexName).Do(ctx)
 	if err != nil {
@@ -89,71 +132,352 @@ func (s *Search) IsExists(ctx context.Context) (bool, error) {
 	return exists, nil
 }
 
+// CreateIndex сохраняет шаблон индекса, создаёт первое поколение первой версии
+// и оба алиаса на него.
 func (s *Search) CreateIndex(ctx context.Context) error {
-	if _, err := s.client.CreateIndex(s.indexName).BodyString(s.mapping).Do(ctx); err != nil {
-		return fmt.Errorf("create index %s: %v", s.indexName, err)
+	if err := s.putTemplate(ctx); err != nil {
+		return err
+	}
+
+	index := s.versionedIndex(1)
+
+	if _, err := s.client.CreateIndex(index).BodyString(s.mapping).Do(ctx); err != nil {
+		return fmt.Errorf("create index %s: %v", index, err)
+	}
+
+	if _, err := s.client.Alias().Action(
+		elastic.NewAliasAddAction(s.indexName).Index(index),
+		elastic.NewAliasAddAction(s.writeAlias).Index(index).IsWriteIndex(true),
+	).Do(ctx); err != nil {
+
+		return fmt.Errorf("add aliases %s and %s to %s: %v", s.indexName, s.writeAlias, index, err)
 	}
 
 	return nil
 }
 
+// DeleteIndex удаляет все версии и поколения индекса вместе с шаблоном, а если
+// индекс создан до версионирования, то и его.
 func (s *Search) DeleteIndex(ctx context.Context) error {
-	if _, err := s.client.DeleteIndex(s.indexName).Do(ctx); err != nil {
-		return fmt.Errorf("delete index %s: %v", s.indexName, err)
+	indices, err := s.versionedIndices(ctx)
+	if err != nil {
+		return err
+	}
+
+	aliased, err := s.aliasIndices(ctx, s.indexName)
+	if err != nil {
+		return err
+	}
+	if len(aliased) == 0 {
+		indices = append(indices, s.indexName)
+	}
+
+	for _, index := range indices {
+		if _, err = s.client.DeleteIndex(index).Do(ctx); err != nil && !elastic.IsNotFound(err) {
+			return fmt.Errorf("delete index %s: %v", index, err)
+		}
+	}
+
+	if _, err = s.client.IndexDeleteIndexTemplate(s.indexName).Do(ctx); err != nil && !elastic.IsNotFound(err) {
+		return fmt.Errorf("delete index template %s: %v", s.indexName, err)
 	}
 
 	return nil
 }
 
+// IndexOrder создаёт документ заказа. Если документ уже есть (повтор активити),
+// то запись считается выполненной. С refresh ответ придёт, когда заказ станет
+// виден поиску.
 func (s *Search) IndexOrder(ctx context.Context, id uuid.UUID, doc *Order, refresh bool) error {
-	indexService := s.client.Index().Id(id.String()).Index(s.indexName).BodyJson(doc)
+	indices, err := s.writeIndices(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indices {
+		request := elastic.NewBulkIndexRequest().Index(index).Id(id.String()).OpType("create").Doc(doc)
 
-	if refresh {
-		indexService.Refresh("true")
+		if err = s.bulk.submit(ctx, request, refresh); err != nil {
+			if elastic.IsConflict(err) {
+				continue
+			}
+			return fmt.Errorf("index %s: %v", index, err)
+		}
 	}
 
-	if _, err := indexService.Do(ctx); err != nil {
-		if refresh {
-			return fmt.Errorf("index %s with refresh: %v", s.indexName, err)
-		} else {
-			return fmt.Errorf("index %s: %v", s.indexName, err)
+	return nil
+}
+
+// UpdateOrder обновляет документ заказа, если version новее той, что уже
+// записана. Запись со старой версией молча отбрасывается, что бы запоздавший
+// ретрай не затёр более свежее состояние. refresh как у IndexOrder.
+func (s *Search) UpdateOrder(ctx context.Context, id uuid.UUID, version int64, partialDoc *Order, refresh bool) error {
+	indices, err := s.writeIndices(ctx)
+	if err != nil {
+		return err
+	}
+
+	partialDoc.Version = version
+
+	// source индекс, в котором обновился документ текущего поколения.
+	source := indices[0]
+
+	for i, index := range indices {
+		err = s.updateVersioned(ctx, index, id.String(), partialDoc, refresh)
+		if err != nil && i == 0 && elastic.IsNotFound(err) {
+			// Заказ мог остаться в одном из прошлых поколений индекса.
+			source, err = s.updateInOrderIndex(ctx, id.String(), index, partialDoc, refresh)
+		}
+		if err != nil && i > 0 && elastic.IsNotFound(err) {
+			// Во время миграции в новом индексе документа может ещё не быть.
+			// Переливка могла снять его до этого обновления, а уже созданные
+			// документы она не трогает, поэтому переносим документ сами.
+			err = s.copyOrder(ctx, id.String(), source, index, partialDoc, refresh)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (s *Search) UpdateOrder(ctx context.Context, id uuid.UUID, partialDoc *Order, refresh bool) error {
-	updateService := s.client.Update().
-		Index(s.indexName).
-		Id(id.String()).
-		Doc(partialDoc).
-		DocAsUpsert(false)
+func (s *Search) updateInOrderIndex(ctx context.Context, id, writeIndex string, partialDoc *Order, refresh bool) (string, error) {
+	index, err := s.orderIndex(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if index == "" || index == writeIndex {
+		return "", fmt.Errorf("order %s not found in %s", id, s.indexName)
+	}
+
+	return index, s.updateVersioned(ctx, index, id, partialDoc, refresh)
+}
 
-	if refresh {
-		updateService.Refresh("true")
+// copyOrder создаёт в индексе to документ заказа целиком из индекса from. Если
+// переливка успела создать его раньше, то применяем к нему обновление.
+func (s *Search) copyOrder(ctx context.Context, id, from, to string, partialDoc *Order, refresh bool) error {
+	res, err := s.client.Get().Index(from).Id(id).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("get order %s from %s: %v", id, from, err)
 	}
+	if !res.Found {
+		return fmt.Errorf("order %s not found in %s", id, from)
+	}
+
+	request := elastic.NewBulkIndexRequest().Index(to).Id(id).OpType("create").Doc(res.Source)
 
-	if _, err := updateService.Do(ctx); err != nil {
-		return fmt.Errorf("perform update: %v", err)
+	if err = s.bulk.submit(ctx, request, refresh); err != nil {
+		if elastic.IsConflict(err) {
+			return s.updateVersioned(ctx, to, id, partialDoc, refresh)
+		}
+		return fmt.Errorf("copy order %s to %s: %v", id, to, err)
 	}
 
 	return nil
 }
 
-func (s *Search) ListUserOrders(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error) {
-	res, err := s.client.Search(s.indexName).
-		Query(elastic.NewTermQuery("user.id", userID.String())).Size(100).
-		Sort("created_at", false).Do(ctx)
+// BulkFailure документ, который не удалось проиндексировать пачкой.
+type BulkFailure struct {
+	ID     string
+	Reason string
+}
+
+// BulkIndexOrders индексирует пачку заказов одним запросом. Заказ
+// перезаписывается в том поколении индекса, где он уже лежит, как в
+// ReplaceOrder, иначе после ротации он задвоится в текущем. Новые заказы пишутся
+// в текущий индекс записи. Ошибка возвращается, если не удался весь запрос, а
+// отказы по отдельным документам в списке.
+func (s *Search) BulkIndexOrders(ctx context.Context, docs []*Order) ([]BulkFailure, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	indices, err := s.writeIndices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	stored, err := s.orderIndices(ctx, ids)
 	if err != nil {
 		return nil, err
 	}
 
-	list := make([]json.RawMessage, 0)
+	bulk := s.client.Bulk()
+	for _, doc := range docs {
+		// Во время миграции пишем ещё и в индекс новой версии.
+		targets := indices
+		if index, ok := stored[doc.ID]; ok {
+			targets = append([]string{index}, indices[1:]...)
+		}
+		for _, index := range targets {
+			bulk.Add(elastic.NewBulkIndexRequest().Index(index).Id(doc.ID).Doc(doc))
+		}
+	}
+
+	res, err := bulk.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("bulk index %d orders to %s: %v", len(docs), strings.Join(indices, ", "), err)
+	}
+
+	failures := make([]BulkFailure, 0)
+	for _, item := range res.Failed() {
+		reason := fmt.Sprintf("status %d", item.Status)
+		if item.Error != nil {
+			reason = fmt.Sprintf("%s: %s", item.Error.Type, item.Error.Reason)
+		}
+		failures = append(failures, BulkFailure{ID: item.Id, Reason: reason})
+	}
+
+	return failures, nil
+}
+
+// ListUserOrdersParams фильтры и страница списка заказов пользователя. Пустые
+// фильтры не применяются.
+type ListUserOrdersParams struct {
+	UserID   uuid.UUID
+	Statuses []string
+	PointID  uuid.UUID
+	From     time.Time
+	To       time.Time
+	// Cursor непрозрачная строка из NextCursor предыдущей страницы.
+	Cursor string
+	Limit  int
+}
+
+type UserOrdersPage struct {
+	Orders []json.RawMessage
+	// NextCursor пустой, если это последняя страница.
+	NextCursor string
+	// Source откуда отдан список: elasticsearch или postgres.
+	Source string
+}
+
+const SourceElasticsearch = "elasticsearch"
+
+// ErrBadCursor курсор не удалось разобрать.
+var ErrBadCursor = errors.New("bad cursor")
+
+func encodeCursor(sort []interface{}) (string, error) {
+	bb, err := json.Marshal(sort)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bb), nil
+}
+
+// EncodeOrderCursor курсор после заказа. Он одинаковый у поиска и у базы, так
+// что листать можно продолжить через любой источник.
+func EncodeOrderCursor(createdAt time.Time, id string) (string, error) {
+	return encodeCursor([]interface{}{createdAt.UnixMilli(), id})
+}
+
+func DecodeOrderCursor(cursor string) (time.Time, string, error) {
+	sort, err := decodeCursor(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	number, ok := sort[0].(json.Number)
+	if !ok {
+		return time.Time{}, "", ErrBadCursor
+	}
+	ms, err := number.Int64()
+	if err != nil {
+		return time.Time{}, "", ErrBadCursor
+	}
+	id, ok := sort[1].(string)
+	if !ok {
+		return time.Time{}, "", ErrBadCursor
+	}
+
+	return time.UnixMilli(ms), id, nil
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	bb, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(bb))
+	dec.UseNumber()
+
+	var sort []interface{}
+	if err = dec.Decode(&sort); err != nil || len(sort) != 2 {
+		return nil, ErrBadCursor
+	}
+
+	return sort, nil
+}
+
+// ListUserOrders отдаёт заказы пользователя от новых к старым. Листается через
+// search_after по created_at и id, поэтому глубина не ограничена.
+func (s *Search) ListUserOrders(ctx context.Context, params ListUserOrdersParams) (*UserOrdersPage, error) {
+	query := elastic.NewBoolQuery().Filter(elastic.NewTermQuery("user.id", params.UserID.String()))
+
+	if len(params.Statuses) > 0 {
+		statuses := make([]interface{}, 0, len(params.Statuses))
+		for _, status := range params.Statuses {
+			statuses = append(statuses, status)
+		}
+		query.Filter(elastic.NewTermsQuery("status", statuses...))
+	}
+	if params.PointID != uuid.Nil {
+		query.Filter(elastic.NewTermQuery("point.id", params.PointID.String()))
+	}
+	if !params.From.IsZero() || !params.To.IsZero() {
+		createdAt := elastic.NewRangeQuery("created_at")
+		if !params.From.IsZero() {
+			createdAt.Gte(params.From.Format(time.RFC3339))
+		}
+		if !params.To.IsZero() {
+			createdAt.Lt(params.To.Format(time.RFC3339))
+		}
+		query.Filter(createdAt)
+	}
+
+	// Берём на один больше, что бы понять, есть ли следующая страница.
+	searchService := s.client.Search(s.indexName).
+		Query(query).
+		Size(params.Limit+1).
+		Sort("created_at", false).
+		Sort("id", false)
+
+	if params.Cursor != "" {
+		after, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		searchService.SearchAfter(after...)
+	}
+
+	res, err := searchService.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := res.Hits.Hits
+	page := &UserOrdersPage{
+		Orders: make([]json.RawMessage, 0),
+		Source: SourceElasticsearch,
+	}
+
+	if len(hits) > params.Limit {
+		hits = hits[:params.Limit]
+		if page.NextCursor, err = encodeCursor(hits[len(hits)-1].Sort); err != nil {
+			return nil, fmt.Errorf("encode cursor: %v", err)
+		}
+	}
 
-	for _, hit := range res.Hits.Hits {
-		list = append(list, hit.Source)
+	for _, hit := range hits {
+		page.Orders = append(page.Orders, hit.Source)
 	}
 
-	return list, nil
+	return page, nil
 }
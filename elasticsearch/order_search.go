@@ -0,0 +1,158 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/olivere/elastic/v7"
+)
+
+// fullTextFields поля, по которым ищет поддержка. Все они анализируются
+// russian_text из mapping.json. Позиции заказа лежат nested, поэтому по их
+// названиям ищется отдельным вложенным запросом.
+var fullTextFields = []string{"user.name", "point.addr", "log_items.text"}
+
+const (
+	itemsPath       = "items"
+	itemsTitleField = "items.title"
+)
+
+// SearchOrdersParams запрос поддержки. Text ищется по тексту, остальное это
+// точные фильтры. Пустые фильтры не применяются, цены в копейках.
+type SearchOrdersParams struct {
+	Text      string
+	OrderID   uuid.UUID
+	Statuses  []string
+	KitchenID uuid.UUID
+	CacheID   uuid.UUID
+	PriceFrom int64
+	PriceTo   int64
+	Offset    int
+	Limit     int
+}
+
+type FoundOrder struct {
+	Order json.RawMessage `json:"order"`
+	// Highlight фрагменты с найденными словами по полям.
+	Highlight map[string][]string `json:"highlight,omitempty"`
+}
+
+type SearchOrdersResult struct {
+	Total  int64         `json:"total"`
+	Orders []*FoundOrder `json:"orders"`
+}
+
+// SearchOrders ищет заказы по всем пользователям. Если задан текст, то самые
+// подходящие идут первыми, иначе от новых к старым.
+func (s *Search) SearchOrders(ctx context.Context, params SearchOrdersParams) (*SearchOrdersResult, error) {
+	query := elastic.NewBoolQuery()
+
+	highlight := func() *elastic.Highlight {
+		return elastic.NewHighlight().
+			PreTags("<mark>").
+			PostTags("</mark>").
+			NumOfFragments(3)
+	}
+
+	if params.Text != "" {
+		// Подсветка найденных позиций приходит во вложенных хитах.
+		items := elastic.NewNestedQuery(itemsPath, elastic.NewMatchQuery(itemsTitleField, params.Text).
+			Operator("and").
+			Fuzziness("AUTO")).
+			InnerHit(elastic.NewInnerHit().
+				Name(itemsPath).
+				FetchSource(false).
+				Highlight(highlight().Fields(elastic.NewHighlighterField(itemsTitleField))))
+
+		// Как best_fields: оценка по лучшему из полей.
+		query.Must(elastic.NewDisMaxQuery().Query(
+			elastic.NewMultiMatchQuery(params.Text, fullTextFields...).
+				Type("best_fields").
+				Operator("and").
+				Fuzziness("AUTO"),
+			items,
+		))
+	}
+	if params.OrderID != uuid.Nil {
+		query.Filter(elastic.NewTermQuery("id", params.OrderID.String()))
+	}
+	if len(params.Statuses) > 0 {
+		statuses := make([]interface{}, 0, len(params.Statuses))
+		for _, status := range params.Statuses {
+			statuses = append(statuses, status)
+		}
+		query.Filter(elastic.NewTermsQuery("status", statuses...))
+	}
+	if params.KitchenID != uuid.Nil {
+		query.Filter(elastic.NewTermQuery("point.kitchen_id", params.KitchenID.String()))
+	}
+	if params.CacheID != uuid.Nil {
+		query.Filter(elastic.NewTermQuery("point.cache_id", params.CacheID.String()))
+	}
+	if params.PriceFrom > 0 || params.PriceTo > 0 {
+		price := elastic.NewRangeQuery("total_price")
+		if params.PriceFrom > 0 {
+			price.Gte(params.PriceFrom)
+		}
+		if params.PriceTo > 0 {
+			price.Lte(params.PriceTo)
+		}
+		query.Filter(price)
+	}
+
+	fieldsHighlight := highlight()
+	for _, field := range fullTextFields {
+		fieldsHighlight.Fields(elastic.NewHighlighterField(field))
+	}
+
+	// ПИН выдачи поддержке не показываем, по нему забирают заказ.
+	searchService := s.client.Search(s.indexName).
+		Query(query).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Exclude("pin_code")).
+		Highlight(fieldsHighlight).
+		From(params.Offset).
+		Size(params.Limit).
+		TrackTotalHits(true)
+
+	if params.Text != "" {
+		searchService.SortBy(elastic.NewScoreSort(), elastic.NewFieldSort("created_at").Desc())
+	} else {
+		searchService.Sort("created_at", false)
+	}
+
+	res, err := searchService.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("search orders: %v", err)
+	}
+
+	result := &SearchOrdersResult{
+		Total:  res.TotalHits(),
+		Orders: make([]*FoundOrder, 0),
+	}
+
+	for _, hit := range res.Hits.Hits {
+		found := &FoundOrder{
+			Order:     hit.Source,
+			Highlight: hit.Highlight,
+		}
+
+		if inner, ok := hit.InnerHits[itemsPath]; ok && inner.Hits != nil {
+			for _, item := range inner.Hits.Hits {
+				fragments := item.Highlight[itemsTitleField]
+				if len(fragments) == 0 {
+					continue
+				}
+				if found.Highlight == nil {
+					found.Highlight = make(map[string][]string)
+				}
+				found.Highlight[itemsTitleField] = append(found.Highlight[itemsTitleField], fragments...)
+			}
+		}
+
+		result.Orders = append(result.Orders, found)
+	}
+
+	return result, nil
+}
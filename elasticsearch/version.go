@@ -0,0 +1,84 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// maxConflictAttempts сколько раз перечитываем документ, если его успели
+// изменить между чтением и записью.
+const maxConflictAttempts = 5
+
+// ConflictError документ так и не удалось обновить из-за параллельных записей.
+// Ошибка временная, активити можно повторить.
+type ConflictError struct {
+	OrderID  string
+	Index    string
+	Version  int64
+	Attempts int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("update order %s to version %d in %s: conflicting concurrent writes after %d attempts",
+		e.OrderID, e.Version, e.Index, e.Attempts)
+}
+
+// updateVersioned применяет частичный документ через if_seq_no/if_primary_term,
+// только если его версия новее записанной. Ошибка 404 отдаётся как есть, её
+// разбирает вызывающий.
+func (s *Search) updateVersioned(ctx context.Context, index, id string, partialDoc *Order, refresh bool) error {
+	for attempt := 1; attempt <= maxConflictAttempts; attempt++ {
+		res, err := s.client.Get().
+			Index(index).
+			Id(id).
+			FetchSourceContext(elastic.NewFetchSourceContext(true).Include("version")).
+			Do(ctx)
+		if err != nil {
+			if elastic.IsNotFound(err) {
+				return err
+			}
+			return fmt.Errorf("get order %s from %s: %v", id, index, err)
+		}
+		if !res.Found {
+			return &elastic.Error{Status: 404}
+		}
+
+		var current struct {
+			Version int64 `json:"version"`
+		}
+		if err = json.Unmarshal(res.Source, &current); err != nil {
+			return fmt.Errorf("parse version of order %s in %s: %v", id, index, err)
+		}
+
+		// Уже записано то же или более новое состояние.
+		if current.Version >= partialDoc.Version {
+			return nil
+		}
+
+		request := elastic.NewBulkUpdateRequest().
+			Index(index).
+			Id(id).
+			Doc(partialDoc).
+			IfSeqNo(*res.SeqNo).
+			IfPrimaryTerm(*res.PrimaryTerm)
+
+		if err = s.bulk.submit(ctx, request, refresh); err != nil {
+			if elastic.IsConflict(err) {
+				continue
+			}
+			return fmt.Errorf("perform update in %s: %v", index, err)
+		}
+
+		return nil
+	}
+
+	return &ConflictError{
+		OrderID:  id,
+		Index:    index,
+		Version:  partialDoc.Version,
+		Attempts: maxConflictAttempts,
+	}
+}
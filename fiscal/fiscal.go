@@ -0,0 +1,101 @@
+package fiscal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Registrar фискальный регистратор, который регистрирует чек и возвращает его
+// фискальные признаки. Регистрация должна быть идемпотентной по заказу, так как
+// активити может быть перезапущена темпоралом.
+type Registrar interface {
+	Register(ctx context.Context, receipt Receipt) (Registration, error)
+}
+
+// Fiscal активити для работы с фискальным регистратором.
+type Fiscal struct {
+	registrar Registrar
+}
+
+func NewFiscal(registrar Registrar) *Fiscal {
+	return &Fiscal{registrar: registrar}
+}
+
+func (f *Fiscal) RegisterReceipt(ctx context.Context, receipt Receipt) (Registration, error) {
+	registration, err := f.registrar.Register(ctx, receipt)
+	if err != nil {
+		return Registration{}, fmt.Errorf("register receipt for order %s: %v", receipt.OrderID, err)
+	}
+	return registration, nil
+}
+
+// FileRegistrar локальная замена настоящего регистратора, которая складывает
+// чеки в файлы в каталоге.
+type FileRegistrar struct {
+	dir string
+}
+
+func NewFileRegistrar(dir string) (*FileRegistrar, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("make receipts dir %s: %v", dir, err)
+	}
+	return &FileRegistrar{dir: dir}, nil
+}
+
+func (r *FileRegistrar) Register(_ context.Context, receipt Receipt) (Registration, error) {
+	path := filepath.Join(r.dir, fmt.Sprintf("%s.json", receipt.OrderID))
+
+	// Если чек уже зарегистрирован (повтор активити), то отдаём прежнюю регистрацию.
+	bb, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		registered := new(Receipt)
+		if err = json.Unmarshal(bb, registered); err != nil {
+			return Registration{}, fmt.Errorf("read registered receipt %s: %v", path, err)
+		}
+		if registered.Registration != nil {
+			return *registered.Registration, nil
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return Registration{}, fmt.Errorf("read registered receipt %s: %v", path, err)
+	}
+
+	receipt.Registration = nil
+
+	doc, err := json.Marshal(receipt)
+	if err != nil {
+		return Registration{}, err
+	}
+
+	sum := sha256.Sum256(doc)
+
+	registration := Registration{
+		Registrar:    "file",
+		FiscalNumber: strings.ToUpper(strings.ReplaceAll(receipt.OrderID, "-", "")[:16]),
+		FiscalSign:   fmt.Sprintf("%d", uint64(sum[0])<<24|uint64(sum[1])<<16|uint64(sum[2])<<8|uint64(sum[3])),
+		RegisteredAt: time.Now(),
+	}
+
+	receipt.Registration = &registration
+
+	if doc, err = json.MarshalIndent(receipt, "", "  "); err != nil {
+		return Registration{}, err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, doc, 0o644); err != nil {
+		return Registration{}, fmt.Errorf("write receipt %s: %v", tmp, err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return Registration{}, fmt.Errorf("write receipt %s: %v", path, err)
+	}
+
+	return registration, nil
+}
@@ -0,0 +1,141 @@
+package fiscal
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krocos/coffee-shop/money"
+)
+
+type (
+	// Receipt документ чека, который выдаётся пользователю после оплаты заказа.
+	Receipt struct {
+		OrderID        string         `json:"order_id"`
+		IssuedAt       time.Time      `json:"issued_at"`
+		PointAddr      string         `json:"point_addr"`
+		UserName       string         `json:"user_name"`
+		Currency       string         `json:"currency"`
+		Lines          []*ReceiptLine `json:"lines"`
+		TotalPrice     int64          `json:"total_price"`
+		VATAmount      int64          `json:"vat_amount"`
+		GiftCardAmount int64          `json:"gift_card_amount,omitempty"`
+		Registration   *Registration  `json:"registration,omitempty"`
+	}
+	ReceiptLine struct {
+		Title      string  `json:"title"`
+		Price      int64   `json:"price"`
+		Quantity   float64 `json:"quantity"`
+		TotalPrice int64   `json:"total_price"`
+		VATRate    int     `json:"vat_rate"`
+		VATAmount  int64   `json:"vat_amount"`
+	}
+	// Registration данные регистрации чека в фискальном регистраторе.
+	Registration struct {
+		Registrar    string    `json:"registrar"`
+		FiscalNumber string    `json:"fiscal_number"`
+		FiscalSign   string    `json:"fiscal_sign"`
+		RegisteredAt time.Time `json:"registered_at"`
+	}
+)
+
+// VATByRate НДС чека, сгруппированный по ставкам (так он печатается в чеке).
+type VATByRate struct {
+	Rate   int
+	Amount int64
+}
+
+func (r *Receipt) VATByRate() []VATByRate {
+	amounts := make(map[int]int64)
+	for _, line := range r.Lines {
+		amounts[line.VATRate] += line.VATAmount
+	}
+
+	list := make([]VATByRate, 0, len(amounts))
+	for rate, amount := range amounts {
+		list = append(list, VATByRate{Rate: rate, Amount: amount})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Rate > list[j].Rate })
+
+	return list
+}
+
+// Text отображение чека в виде текста, как на бумажной ленте.
+func (r *Receipt) Text() string {
+	b := new(strings.Builder)
+
+	_, _ = fmt.Fprintln(b, "КАССОВЫЙ ЧЕК / ПРИХОД")
+	_, _ = fmt.Fprintln(b, r.PointAddr)
+	_, _ = fmt.Fprintf(b, "Заказ: %s\n", r.OrderID)
+	_, _ = fmt.Fprintf(b, "Дата: %s\n", r.IssuedAt.Format("02.01.2006 15:04"))
+	_, _ = fmt.Fprintf(b, "Покупатель: %s\n", r.UserName)
+	_, _ = fmt.Fprintln(b, strings.Repeat("-", 40))
+
+	for _, line := range r.Lines {
+		_, _ = fmt.Fprintln(b, line.Title)
+		_, _ = fmt.Fprintf(b, "  %.0f x %s = %s\n", line.Quantity,
+			money.Format(line.Price, r.Currency), money.Format(line.TotalPrice, r.Currency))
+		_, _ = fmt.Fprintf(b, "  НДС %d%%: %s\n", line.VATRate, money.Format(line.VATAmount, r.Currency))
+	}
+
+	_, _ = fmt.Fprintln(b, strings.Repeat("-", 40))
+	_, _ = fmt.Fprintf(b, "ИТОГО: %s\n", money.Format(r.TotalPrice, r.Currency))
+
+	if r.GiftCardAmount > 0 {
+		_, _ = fmt.Fprintf(b, "подарочной картой: %s\n", money.Format(r.GiftCardAmount, r.Currency))
+		_, _ = fmt.Fprintf(b, "электронными: %s\n", money.Format(r.TotalPrice-r.GiftCardAmount, r.Currency))
+	}
+
+	for _, vat := range r.VATByRate() {
+		_, _ = fmt.Fprintf(b, "в т.ч. НДС %d%%: %s\n", vat.Rate, money.Format(vat.Amount, r.Currency))
+	}
+
+	if r.Registration != nil {
+		_, _ = fmt.Fprintln(b, strings.Repeat("-", 40))
+		_, _ = fmt.Fprintf(b, "ФН: %s\n", r.Registration.FiscalNumber)
+		_, _ = fmt.Fprintf(b, "ФП: %s\n", r.Registration.FiscalSign)
+	}
+
+	return b.String()
+}
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"money": money.Format,
+	"date":  func(t time.Time) string { return t.Format("02.01.2006 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Чек {{.OrderID}}</title></head>
+<body>
+<h3>Кассовый чек / приход</h3>
+<p>{{.PointAddr}}<br>Заказ: {{.OrderID}}<br>Дата: {{date .IssuedAt}}<br>Покупатель: {{.UserName}}</p>
+<table>
+{{- range .Lines}}
+<tr><td>{{.Title}}</td><td>{{printf "%.0f" .Quantity}} x {{money .Price $.Currency}}</td><td>{{money .TotalPrice $.Currency}}</td><td>НДС {{.VATRate}}%</td></tr>
+{{- end}}
+</table>
+<p><b>Итого: {{money .TotalPrice .Currency}}</b></p>
+{{- if gt .GiftCardAmount 0}}
+<p>подарочной картой: {{money .GiftCardAmount .Currency}}</p>
+{{- end}}
+{{- range .VATByRate}}
+<p>в т.ч. НДС {{.Rate}}%: {{money .Amount $.Currency}}</p>
+{{- end}}
+{{- with .Registration}}
+<p>ФН: {{.FiscalNumber}}<br>ФП: {{.FiscalSign}}</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML отображение чека для браузера.
+func (r *Receipt) HTML() (string, error) {
+	b := new(bytes.Buffer)
+	if err := receiptTemplate.Execute(b, r); err != nil {
+		return "", fmt.Errorf("render receipt %s: %v", r.OrderID, err)
+	}
+	return b.String(), nil
+}
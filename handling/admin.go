@@ -0,0 +1,186 @@
+package handling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/converter"
+
+	"github.com/krocos/coffee-shop/backend"
+)
+
+const (
+	defaultWorkflowsLimit = 20
+	maxWorkflowsLimit     = 100
+)
+
+// orderStatusPattern статусы подставляются в запрос visibility строкой, поэтому
+// пускаем только то, из чего они состоят.
+var orderStatusPattern = regexp.MustCompile(`^[a-z_]+$`)
+
+type OrderWorkflowResponse struct {
+	WorkflowID      string     `json:"workflow_id"`
+	RunID           string     `json:"run_id"`
+	ExecutionStatus string     `json:"execution_status"`
+	StartTime       *time.Time `json:"start_time,omitempty"`
+	CloseTime       *time.Time `json:"close_time,omitempty"`
+	OrderStatus     string     `json:"order_status,omitempty"`
+	PointID         string     `json:"point_id,omitempty"`
+	KitchenID       string     `json:"kitchen_id,omitempty"`
+	CacheID         string     `json:"cache_id,omitempty"`
+	UserID          string     `json:"user_id,omitempty"`
+	TotalPrice      int64      `json:"total_price,omitempty"`
+}
+
+type OrderWorkflowsResponse struct {
+	Query         string                   `json:"query"`
+	Workflows     []*OrderWorkflowResponse `json:"workflows"`
+	NextPageToken string                   `json:"next_page_token,omitempty"`
+}
+
+// ListOrderWorkflows ищет воркфлоу заказов в visibility темпорала по поисковым
+// атрибутам, например status=cooking&point_id=... это заказы, застрявшие в
+// готовке на точке. Параметры: status (можно несколько через запятую),
+// point_id, kitchen_id, cache_id, user_id, price_from и price_to в копейках,
+// started_before (дата или RFC3339), running (true только идущие, false только
+// закрытые), limit и page_token (next_page_token предыдущей страницы). Заказы,
+// начатые до поисковых атрибутов, находятся только без фильтров по ним.
+func (h *Handling) ListOrderWorkflows(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	conditions := []string{"WorkflowType = 'OrderWorkflow'"}
+
+	if statuses := parseListParam(query, "status"); len(statuses) > 0 {
+		quoted := make([]string, 0)
+		for _, status := range statuses {
+			if !orderStatusPattern.MatchString(status) {
+				http.Error(w, fmt.Sprintf("bad status: %q", status), http.StatusBadRequest)
+				return
+			}
+			quoted = append(quoted, fmt.Sprintf("'%s'", status))
+		}
+		conditions = append(conditions, fmt.Sprintf("%s IN (%s)", backend.SearchAttributeOrderStatus, strings.Join(quoted, ", ")))
+	}
+
+	for _, filter := range []struct{ param, attribute string }{
+		{param: "point_id", attribute: backend.SearchAttributePointID},
+		{param: "kitchen_id", attribute: backend.SearchAttributeKitchenID},
+		{param: "cache_id", attribute: backend.SearchAttributeCacheID},
+		{param: "user_id", attribute: backend.SearchAttributeUserID},
+	} {
+		id, err := parseUUIDParam(query, filter.param)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id != uuid.Nil {
+			conditions = append(conditions, fmt.Sprintf("%s = '%s'", filter.attribute, id.String()))
+		}
+	}
+
+	for _, filter := range []struct{ param, operator string }{
+		{param: "price_from", operator: ">="},
+		{param: "price_to", operator: "<="},
+	} {
+		price, err := parseIntParam(query, filter.param, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query.Get(filter.param) != "" {
+			conditions = append(conditions, fmt.Sprintf("%s %s %d", backend.SearchAttributeTotalPrice, filter.operator, price))
+		}
+	}
+
+	startedBefore, err := parseDateParam(query.Get("started_before"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad started_before: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !startedBefore.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("StartTime < '%s'", startedBefore.Format(time.RFC3339)))
+	}
+
+	switch query.Get("running") {
+	case "":
+	case "true":
+		conditions = append(conditions, "ExecutionStatus = 'Running'")
+	case "false":
+		conditions = append(conditions, "ExecutionStatus != 'Running'")
+	default:
+		http.Error(w, "running must be true or false", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseIntParam(query, "limit", defaultWorkflowsLimit)
+	if err != nil || limit == 0 || limit > maxWorkflowsLimit {
+		http.Error(w, fmt.Sprintf("limit must be from 1 to %d", maxWorkflowsLimit), http.StatusBadRequest)
+		return
+	}
+
+	pageToken, err := base64.RawURLEncoding.DecodeString(query.Get("page_token"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad page_token: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	res := OrderWorkflowsResponse{
+		Query:     strings.Join(conditions, " AND "),
+		Workflows: make([]*OrderWorkflowResponse, 0),
+	}
+
+	resp, err := h.client.ListWorkflow(r.Context(), &workflowservice.ListWorkflowExecutionsRequest{
+		PageSize:      int32(limit),
+		NextPageToken: pageToken,
+		Query:         res.Query,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, execution := range resp.Executions {
+		workflow := &OrderWorkflowResponse{
+			WorkflowID:      execution.Execution.WorkflowId,
+			RunID:           execution.Execution.RunId,
+			ExecutionStatus: execution.Status.String(),
+			StartTime:       execution.StartTime,
+			CloseTime:       execution.CloseTime,
+		}
+
+		// Атрибутов нет у заказов, начатых до них.
+		fields := execution.SearchAttributes.GetIndexedFields()
+		for name, value := range map[string]interface{}{
+			backend.SearchAttributeOrderStatus: &workflow.OrderStatus,
+			backend.SearchAttributePointID:     &workflow.PointID,
+			backend.SearchAttributeKitchenID:   &workflow.KitchenID,
+			backend.SearchAttributeCacheID:     &workflow.CacheID,
+			backend.SearchAttributeUserID:      &workflow.UserID,
+			backend.SearchAttributeTotalPrice:  &workflow.TotalPrice,
+		} {
+			payload, ok := fields[name]
+			if !ok {
+				continue
+			}
+			if err = converter.GetDefaultDataConverter().FromPayload(payload, value); err != nil {
+				http.Error(w, fmt.Sprintf("decode %s of %s: %v", name, workflow.WorkflowID, err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		res.Workflows = append(res.Workflows, workflow)
+	}
+
+	res.NextPageToken = base64.RawURLEncoding.EncodeToString(resp.NextPageToken)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
+}
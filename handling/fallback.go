@@ -0,0 +1,103 @@
+package handling
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+)
+
+type UserOrders interface {
+	ListUserOrders(ctx context.Context, params elasticsearch.ListUserOrdersParams) (*elasticsearch.UserOrdersPage, error)
+}
+
+// UserOrdersBreaker отдаёт заказы пользователя из поиска, а если поиск не
+// отвечает, то из базы. После threshold ошибок подряд поиск не спрашиваем
+// cooldown, потом пробуем снова одним запросом.
+type UserOrdersBreaker struct {
+	primary   UserOrders
+	fallback  UserOrders
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewUserOrdersBreaker(primary, fallback UserOrders, threshold int, cooldown time.Duration) *UserOrdersBreaker {
+	return &UserOrdersBreaker{
+		primary:   primary,
+		fallback:  fallback,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow можно ли сейчас спрашивать поиск. Когда cooldown прошёл, пропускаем
+// только один пробный запрос, остальные идут в базу, пока он не ответит.
+func (b *UserOrdersBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+func (b *UserOrdersBreaker) report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Println("user orders: search is back")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("user orders: search failed %d times in a row, falling back to postgres: %v", b.failures, err)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func (b *UserOrdersBreaker) ListUserOrders(ctx context.Context, params elasticsearch.ListUserOrdersParams) (*elasticsearch.UserOrdersPage, error) {
+	if b.allow() {
+		page, err := b.primary.ListUserOrders(ctx, params)
+
+		// Битый курсор это ошибка клиента, а не поиска.
+		if errors.Is(err, elasticsearch.ErrBadCursor) {
+			b.report(nil)
+			return nil, err
+		}
+		// Клиент ушёл сам, поиск тут ни при чём.
+		if err != nil && ctx.Err() != nil {
+			b.report(nil)
+			return nil, err
+		}
+
+		b.report(err)
+		if err == nil {
+			return page, nil
+		}
+	}
+
+	return b.fallback.ListUserOrders(ctx, params)
+}
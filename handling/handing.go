@@ -2,15 +2,26 @@ package handling
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"go.temporal.io/sdk/client"
+	"gorm.io/gorm"
 
 	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/fiscal"
+	"github.com/krocos/coffee-shop/money"
 	"github.com/krocos/coffee-shop/postgres"
 )
 
@@ -18,30 +29,46 @@ type Storage interface {
 	GetMenu(ctx context.Context) (*postgres.MenuResponse, error)
 	ListKitchenCookItems(ctx context.Context, kitchenID uuid.UUID) ([]*postgres.KitchenCookItemResponse, error)
 	ListCacheOrders(ctx context.Context, cacheID uuid.UUID) ([]*postgres.CacheOrderResponse, error)
+	ListKitchenCookItemsHistory(ctx context.Context, kitchenID uuid.UUID, before postgres.HistoryCursor, limit int) ([]*postgres.KitchenCookItemHistoryResponse, error)
+	ListCacheOrdersHistory(ctx context.Context, cacheID uuid.UUID, before postgres.HistoryCursor, limit int) ([]*postgres.CacheOrderHistoryResponse, error)
+	GetReceipt(ctx context.Context, orderID uuid.UUID) (*postgres.ReceiptResponse, error)
+	IssueGiftCard(ctx context.Context, params postgres.IssueGiftCardParams) (*postgres.GiftCardResponse, error)
+	GetGiftCardBalance(ctx context.Context, code string) (*postgres.GiftCardResponse, error)
+	GetZReport(ctx context.Context, pointID uuid.UUID, day string) (*postgres.ZReportResponse, error)
+	ListZReports(ctx context.Context, pointID uuid.UUID, limit int) ([]*postgres.ZReportResponse, error)
+	ListPoints(ctx context.Context) ([]postgres.PointData, error)
 }
 
 type Search interface {
-	ListUserOrders(ctx context.Context, userID uuid.UUID) ([]json.RawMessage, error)
+	SearchOrders(ctx context.Context, params elasticsearch.SearchOrdersParams) (*elasticsearch.SearchOrdersResult, error)
+	Revenue(ctx context.Context, params elasticsearch.RevenueParams) ([]*elasticsearch.PointRevenue, error)
+	TopItems(ctx context.Context, filter elasticsearch.AnalyticsFilter, byRevenue bool, limit int) ([]*elasticsearch.TopItem, error)
+	SalesSummary(ctx context.Context, filter elasticsearch.AnalyticsFilter) (*elasticsearch.SalesSummary, error)
 }
 
 type Handling struct {
-	client  client.Client
-	storage Storage
-	search  Search
+	client     client.Client
+	taskQueue  string
+	storage    Storage
+	search     Search
+	userOrders UserOrders
 }
 
-func NewHandling(client client.Client, storage Storage, search Search) *Handling {
+func NewHandling(client client.Client, taskQueue string, storage Storage, search Search, userOrders UserOrders) *Handling {
 	return &Handling{
-		client:  client,
-		storage: storage,
-		search:  search,
+		client:     client,
+		taskQueue:  taskQueue,
+		storage:    storage,
+		search:     search,
+		userOrders: userOrders,
 	}
 }
 
 type CreateOrderRequest struct {
-	UserID  uuid.UUID                 `json:"user_id"`
-	PointID uuid.UUID                 `json:"point_id"`
-	Items   []*InitialItemDataRequest `json:"items"`
+	UserID       uuid.UUID                 `json:"user_id"`
+	PointID      uuid.UUID                 `json:"point_id"`
+	Items        []*InitialItemDataRequest `json:"items"`
+	GiftCardCode string                    `json:"gift_card_code"`
 }
 type InitialItemDataRequest struct {
 	ID       uuid.UUID `json:"id"`
@@ -58,10 +85,11 @@ func (h *Handling) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	orderID := uuid.New()
 
 	initialData := backend.OrderInitialData{
-		ID:      orderID,
-		UserID:  req.UserID,
-		PointID: req.PointID,
-		Items:   make([]backend.ItemInitialData, 0),
+		ID:           orderID,
+		UserID:       req.UserID,
+		PointID:      req.PointID,
+		Items:        make([]backend.ItemInitialData, 0),
+		GiftCardCode: strings.ToUpper(strings.TrimSpace(req.GiftCardCode)),
 	}
 	for _, item := range req.Items {
 		initialData.Items = append(initialData.Items, backend.ItemInitialData{
@@ -71,8 +99,8 @@ func (h *Handling) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, err := h.client.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{
-		ID:        orderWorkflowID(orderID),
-		TaskQueue: "coffee",
+		ID:        backend.OrderWorkflowID(orderID),
+		TaskQueue: h.taskQueue,
 	}, backend.OrderWorkflow, initialData); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -101,7 +129,7 @@ func (h *Handling) OrderItemCooked(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = h.client.SignalWorkflow(context.Background(), orderWorkflowID(orderID), "", "cooking_signals", backend.CookingSignal{
+	if err = h.client.SignalWorkflow(context.Background(), backend.OrderWorkflowID(orderID), "", "cooking_signals", backend.CookingSignal{
 		OrderItemID: req.OrderItemID,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -126,7 +154,7 @@ func (h *Handling) ReceiveOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = h.client.SignalWorkflow(context.Background(), orderWorkflowID(orderID), "", "receive_signals", backend.ReceiveSignal{
+	if err = h.client.SignalWorkflow(context.Background(), backend.OrderWorkflowID(orderID), "", "receive_signals", backend.ReceiveSignal{
 		PINCode: req.PINCode,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -152,7 +180,7 @@ func (h *Handling) PaymentEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = h.client.SignalWorkflow(context.Background(), orderWorkflowID(orderID), "", "payment_signals", backend.PaymentSignal{
+	if err = h.client.SignalWorkflow(context.Background(), backend.OrderWorkflowID(orderID), "", "payment_signals", backend.PaymentSignal{
 		Status: req.Status,
 		Reason: req.Reason,
 	}); err != nil {
@@ -167,9 +195,10 @@ type User struct {
 }
 
 type Item struct {
-	ID    uuid.UUID `json:"id"`
-	Title string    `json:"title"`
-	Price float64   `json:"price"`
+	ID       uuid.UUID `json:"id"`
+	Title    string    `json:"title"`
+	Price    int64     `json:"price"`
+	Currency string    `json:"currency"`
 }
 
 type Point struct {
@@ -215,6 +244,50 @@ func (h *Handling) GetMenu(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(res)
 }
 
+const (
+	defaultOrdersLimit = 20
+	maxOrdersLimit     = 100
+
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+type UserOrdersResponse struct {
+	Orders     []json.RawMessage `json:"orders"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Source     string            `json:"source"`
+}
+
+// parseListParam собирает значения параметра, который можно передать несколько
+// раз или через запятую.
+func parseListParam(query url.Values, name string) []string {
+	list := make([]string, 0)
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// parseDateParam понимает и дату (YYYY-MM-DD), и время в RFC3339.
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// ListUserOrders отдаёт страницу заказов пользователя от новых к старым.
+// Параметры: cursor (next_cursor предыдущей страницы), limit, status (можно
+// несколько через запятую), point_id, from и to (to не включительно).
 func (h *Handling) ListUserOrders(w http.ResponseWriter, r *http.Request) {
 	userID, err := uuid.Parse(mux.Vars(r)["user_id"])
 	if err != nil {
@@ -222,15 +295,293 @@ func (h *Handling) ListUserOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rawOrders, err := h.search.ListUserOrders(r.Context(), userID)
+	query := r.URL.Query()
+	params := elasticsearch.ListUserOrdersParams{
+		UserID: userID,
+		Cursor: query.Get("cursor"),
+		Limit:  defaultOrdersLimit,
+	}
+
+	if value := query.Get("limit"); value != "" {
+		if params.Limit, err = strconv.Atoi(value); err != nil || params.Limit <= 0 || params.Limit > maxOrdersLimit {
+			http.Error(w, fmt.Sprintf("limit must be from 1 to %d", maxOrdersLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	params.Statuses = parseListParam(query, "status")
+
+	if value := query.Get("point_id"); value != "" {
+		if params.PointID, err = uuid.Parse(value); err != nil {
+			http.Error(w, fmt.Sprintf("bad point_id: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if params.From, err = parseDateParam(query.Get("from")); err != nil {
+		http.Error(w, fmt.Sprintf("bad from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if params.To, err = parseDateParam(query.Get("to")); err != nil {
+		http.Error(w, fmt.Sprintf("bad to: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.userOrders.ListUserOrders(r.Context(), params)
 	if err != nil {
+		if errors.Is(err, elasticsearch.ErrBadCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Orders-Source", page.Source)
+
+	_ = json.NewEncoder(w).Encode(UserOrdersResponse{
+		Orders:     page.Orders,
+		NextCursor: page.NextCursor,
+		Source:     page.Source,
+	})
+}
+
+// GetReceipt отдаёт чек заказа. Формат выбирается параметром format: json (по
+// умолчанию), text или html.
+func (h *Handling) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(mux.Vars(r)["order_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.storage.GetReceipt(r.Context(), orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("receipt for order %s not found", orderID), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	receipt := new(fiscal.Receipt)
+	if err = json.Unmarshal(res.Document, receipt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+
+		_ = json.NewEncoder(w).Encode(receipt)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		_, _ = io.WriteString(w, receipt.Text())
+	case "html":
+		page, err := receipt.HTML()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		_, _ = io.WriteString(w, page)
+	default:
+		http.Error(w, fmt.Sprintf("unknown receipt format '%s'", r.URL.Query().Get("format")), http.StatusBadRequest)
+	}
+}
+
+type IssueGiftCardRequest struct {
+	InitialBalance int64     `json:"initial_balance"`
+	Currency       string    `json:"currency"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+type GiftCardResponse struct {
+	Code           string    `json:"code"`
+	InitialBalance int64     `json:"initial_balance"`
+	Balance        int64     `json:"balance"`
+	Available      int64     `json:"available"`
+	Currency       string    `json:"currency"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+func (h *Handling) IssueGiftCard(w http.ResponseWriter, r *http.Request) {
+	req := new(IssueGiftCardRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.InitialBalance <= 0 {
+		http.Error(w, "initial balance must be positive", http.StatusBadRequest)
+		return
+	}
+
+	if req.Currency == "" {
+		req.Currency = money.DefaultCurrency
+	}
+
+	if req.ExpiresAt.IsZero() {
+		req.ExpiresAt = time.Now().AddDate(1, 0, 0)
+	}
+
+	card, err := h.storage.IssueGiftCard(r.Context(), postgres.IssueGiftCardParams{
+		InitialBalance: req.InitialBalance,
+		Currency:       req.Currency,
+		ExpiresAt:      req.ExpiresAt,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	_ = json.NewEncoder(w).Encode((*GiftCardResponse)(card))
+}
+
+func (h *Handling) GetGiftCardBalance(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(mux.Vars(r)["code"])
+
+	card, err := h.storage.GetGiftCardBalance(r.Context(), code)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("gift card %s not found", code), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode((*GiftCardResponse)(card))
+}
+
+type ZReportResponse struct {
+	PointID         uuid.UUID `json:"point_id"`
+	Day             string    `json:"day"`
+	StartsAt        time.Time `json:"starts_at"`
+	EndsAt          time.Time `json:"ends_at"`
+	Currency        string    `json:"currency"`
+	OrdersCount     int64     `json:"orders_count"`
+	Revenue         int64     `json:"revenue"`
+	CanceledOrders  int64     `json:"canceled_orders"`
+	PaymentTimeouts int64     `json:"payment_timeouts"`
+	AbandonedOrders int64     `json:"abandoned_orders"`
+	AvgCookSeconds  int64     `json:"avg_cook_seconds"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+var zReportCSVHeader = []string{
+	"point_id", "day", "starts_at", "ends_at", "currency", "orders_count", "revenue",
+	"canceled_orders", "payment_timeouts", "abandoned_orders", "avg_cook_seconds",
+}
+
+func (r *ZReportResponse) csvRecord() []string {
+	return []string{
+		r.PointID.String(),
+		r.Day,
+		r.StartsAt.Format(time.RFC3339),
+		r.EndsAt.Format(time.RFC3339),
+		r.Currency,
+		strconv.FormatInt(r.OrdersCount, 10),
+		strconv.FormatInt(r.Revenue, 10),
+		strconv.FormatInt(r.CanceledOrders, 10),
+		strconv.FormatInt(r.PaymentTimeouts, 10),
+		strconv.FormatInt(r.AbandonedOrders, 10),
+		strconv.FormatInt(r.AvgCookSeconds, 10),
+	}
+}
 
-	_ = json.NewEncoder(w).Encode(rawOrders)
+func writeZReports(w http.ResponseWriter, r *http.Request, filename string, reports []*ZReportResponse, single bool) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+
+		if single {
+			_ = json.NewEncoder(w).Encode(reports[0])
+			return
+		}
+		_ = json.NewEncoder(w).Encode(reports)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+
+		cw := csv.NewWriter(w)
+		_ = cw.Write(zReportCSVHeader)
+		for _, report := range reports {
+			_ = cw.Write(report.csvRecord())
+		}
+		cw.Flush()
+	default:
+		http.Error(w, fmt.Sprintf("unknown report format '%s'", r.URL.Query().Get("format")), http.StatusBadRequest)
+	}
+}
+
+// GetZReport отдаёт Z-отчёт точки за день. Формат выбирается параметром
+// format: json (по умолчанию) или csv.
+func (h *Handling) GetZReport(w http.ResponseWriter, r *http.Request) {
+	pointID, err := uuid.Parse(mux.Vars(r)["point_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	day := mux.Vars(r)["day"]
+	if _, err = time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.storage.GetZReport(r.Context(), pointID, day)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("z-report of point %s for %s not found", pointID, day), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeZReports(w, r, fmt.Sprintf("z-report-%s", day), []*ZReportResponse{(*ZReportResponse)(report)}, true)
+}
+
+// ListZReports отдаёт последние Z-отчёты точки, не больше limit (по умолчанию 31).
+func (h *Handling) ListZReports(w http.ResponseWriter, r *http.Request) {
+	pointID, err := uuid.Parse(mux.Vars(r)["point_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 31
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("bad limit '%s'", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	reports, err := h.storage.ListZReports(r.Context(), pointID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]*ZReportResponse, 0)
+	for _, report := range reports {
+		res = append(res, (*ZReportResponse)(report))
+	}
+
+	writeZReports(w, r, fmt.Sprintf("z-reports-%s", pointID), res, false)
 }
 
 type KitchenCookItemResponse struct {
@@ -297,6 +648,114 @@ func (h *Handling) ListCacheOrders(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(res)
 }
 
-func orderWorkflowID(orderID uuid.UUID) string {
-	return fmt.Sprintf("order:%s", orderID.String())
+// parseHistoryParams параметры истории терминала: before (дата или время в
+// RFC3339, не включительно), before_id и limit. Следующая страница
+// запрашивается с before и before_id последней строки, тогда строки с тем же
+// временем не теряются.
+func parseHistoryParams(query url.Values) (postgres.HistoryCursor, int, error) {
+	var before postgres.HistoryCursor
+	var err error
+
+	if before.At, err = parseDateParam(query.Get("before")); err != nil {
+		return before, 0, fmt.Errorf("bad before: %v", err)
+	}
+	if before.ID, err = parseUUIDParam(query, "before_id"); err != nil {
+		return before, 0, err
+	}
+	if before.ID != uuid.Nil && before.At.IsZero() {
+		return before, 0, fmt.Errorf("before_id needs before")
+	}
+
+	limit := defaultHistoryLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxHistoryLimit {
+			return before, 0, fmt.Errorf("limit must be from 1 to %d", maxHistoryLimit)
+		}
+	}
+
+	return before, limit, nil
+}
+
+type KitchenCookItemHistoryResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Title     string    `json:"title"`
+	Quantity  float64   `json:"quantity"`
+	OrderID   uuid.UUID `json:"order_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ReadyAt   time.Time `json:"ready_at"`
+}
+
+// ListKitchenCookItemsHistory приготовленные на кухне позиции от новых к
+// старым. Следующая страница запрашивается с before = ready_at и before_id = id
+// последней.
+func (h *Handling) ListKitchenCookItemsHistory(w http.ResponseWriter, r *http.Request) {
+	kitchenID, err := uuid.Parse(mux.Vars(r)["kitchen_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, limit, err := parseHistoryParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cookItems, err := h.storage.ListKitchenCookItemsHistory(r.Context(), kitchenID, before, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]*KitchenCookItemHistoryResponse, 0)
+
+	for _, item := range cookItems {
+		res = append(res, (*KitchenCookItemHistoryResponse)(item))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+type CacheOrderHistoryResponse struct {
+	ID           uuid.UUID `json:"id"`
+	OrderID      uuid.UUID `json:"order_id"`
+	UserName     string    `json:"user_name"`
+	CheckList    string    `json:"check_list"`
+	CreatedAt    time.Time `json:"created_at"`
+	HandedOverAt time.Time `json:"handed_over_at"`
+}
+
+// ListCacheOrdersHistory выданные на кассе заказы от новых к старым. Следующая
+// страница запрашивается с before = handed_over_at и before_id = id
+// последнего.
+func (h *Handling) ListCacheOrdersHistory(w http.ResponseWriter, r *http.Request) {
+	cacheID, err := uuid.Parse(mux.Vars(r)["cache_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, limit, err := parseHistoryParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cacheOrders, err := h.storage.ListCacheOrdersHistory(r.Context(), cacheID, before, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]*CacheOrderHistoryResponse, 0)
+
+	for _, order := range cacheOrders {
+		res = append(res, (*CacheOrderHistoryResponse)(order))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
 }
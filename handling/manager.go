@@ -0,0 +1,172 @@
+package handling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+)
+
+const (
+	defaultAnalyticsPeriod = 30 * 24 * time.Hour
+	maxDailyPeriod         = 366 * 24 * time.Hour
+	maxHourlyPeriod        = 31 * 24 * time.Hour
+	defaultTopItemsLimit   = 10
+	maxTopItemsLimit       = 100
+)
+
+// parseAnalyticsFilter разбирает from, to (не включительно) и point_id. По
+// умолчанию берутся последние 30 дней.
+func parseAnalyticsFilter(query url.Values) (elasticsearch.AnalyticsFilter, error) {
+	var (
+		filter elasticsearch.AnalyticsFilter
+		err    error
+	)
+
+	if filter.From, err = parseDateParam(query.Get("from")); err != nil {
+		return filter, fmt.Errorf("bad from: %v", err)
+	}
+	if filter.To, err = parseDateParam(query.Get("to")); err != nil {
+		return filter, fmt.Errorf("bad to: %v", err)
+	}
+	if filter.PointID, err = parseUUIDParam(query, "point_id"); err != nil {
+		return filter, err
+	}
+
+	if filter.To.IsZero() {
+		filter.To = time.Now()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.Add(-defaultAnalyticsPeriod)
+	}
+	if !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	return filter, nil
+}
+
+// Revenue выручка и количество заказов по точкам. Параметры: from, to,
+// point_id и interval (day по умолчанию или hour).
+func (h *Handling) Revenue(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseAnalyticsFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	interval := query.Get("interval")
+	maxPeriod := maxDailyPeriod
+	switch interval {
+	case "", "day":
+		interval = "day"
+	case "hour":
+		maxPeriod = maxHourlyPeriod
+	default:
+		http.Error(w, "interval must be day or hour", http.StatusBadRequest)
+		return
+	}
+	if filter.To.Sub(filter.From) > maxPeriod {
+		http.Error(w, fmt.Sprintf("period is too long for interval %s", interval), http.StatusBadRequest)
+		return
+	}
+
+	points, err := h.storage.ListPoints(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	params := elasticsearch.RevenueParams{
+		From:     filter.From,
+		To:       filter.To,
+		Interval: interval,
+		Points:   make([]elasticsearch.PointTimezone, 0),
+	}
+	for _, point := range points {
+		if filter.PointID != uuid.Nil && point.ID != filter.PointID {
+			continue
+		}
+		params.Points = append(params.Points, elasticsearch.PointTimezone{ID: point.ID, Timezone: point.Timezone})
+	}
+
+	if filter.PointID != uuid.Nil && len(params.Points) == 0 {
+		http.Error(w, fmt.Sprintf("point %s not found", filter.PointID), http.StatusNotFound)
+		return
+	}
+
+	res, err := h.search.Revenue(r.Context(), params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// TopItems самые продаваемые позиции. Параметры: from, to, point_id, by
+// (quantity по умолчанию или revenue) и limit.
+func (h *Handling) TopItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseAnalyticsFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var byRevenue bool
+	switch query.Get("by") {
+	case "", "quantity":
+	case "revenue":
+		byRevenue = true
+	default:
+		http.Error(w, "by must be quantity or revenue", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseIntParam(query, "limit", defaultTopItemsLimit)
+	if err != nil || limit == 0 || limit > maxTopItemsLimit {
+		http.Error(w, fmt.Sprintf("limit must be from 1 to %d", maxTopItemsLimit), http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.search.TopItems(r.Context(), filter, byRevenue, int(limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// SalesSummary средний чек и доля неоплаченных вовремя заказов. Параметры:
+// from, to и point_id.
+func (h *Handling) SalesSummary(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAnalyticsFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.search.SalesSummary(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
+}
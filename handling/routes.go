@@ -0,0 +1,39 @@
+package handling
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Router все ручки апи. Один и тот же для api_server и devstack.
+func (h *Handling) Router() *mux.Router {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/user-api/menu", h.GetMenu).Methods(http.MethodGet)
+	router.HandleFunc("/user-api/order", h.CreateOrder).Methods(http.MethodPost)
+	router.HandleFunc("/user-api/user/{user_id}/orders", h.ListUserOrders).Methods(http.MethodGet)
+	router.HandleFunc("/user-api/order/{order_id}/receipt", h.GetReceipt).Methods(http.MethodGet)
+	router.HandleFunc("/user-api/gift-card/{code}", h.GetGiftCardBalance).Methods(http.MethodGet)
+
+	router.HandleFunc("/payment-gateway-api/order/{order_id}/payment-event", h.PaymentEvent).Methods(http.MethodPost)
+	router.HandleFunc("/kitchen-api/order/{order_id}/item-cooked", h.OrderItemCooked).Methods(http.MethodPost)
+	router.HandleFunc("/kitchen-api/kitchen/{kitchen_id}/cook-items", h.ListKitchenCookItems).Methods(http.MethodGet)
+	router.HandleFunc("/kitchen-api/kitchen/{kitchen_id}/cook-items/history", h.ListKitchenCookItemsHistory).Methods(http.MethodGet)
+	router.HandleFunc("/cache-api/order/{order_id}/receive-order", h.ReceiveOrder).Methods(http.MethodPost)
+	router.HandleFunc("/cache-api/cache/{cache_id}/orders", h.ListCacheOrders).Methods(http.MethodGet)
+	router.HandleFunc("/cache-api/cache/{cache_id}/orders/history", h.ListCacheOrdersHistory).Methods(http.MethodGet)
+	router.HandleFunc("/cache-api/gift-card", h.IssueGiftCard).Methods(http.MethodPost)
+	router.HandleFunc("/cache-api/point/{point_id}/z-reports", h.ListZReports).Methods(http.MethodGet)
+	router.HandleFunc("/cache-api/point/{point_id}/z-report/{day}", h.GetZReport).Methods(http.MethodGet)
+
+	router.HandleFunc("/support-api/orders/search", h.SearchOrders).Methods(http.MethodGet)
+
+	router.HandleFunc("/manager-api/analytics/revenue", h.Revenue).Methods(http.MethodGet)
+	router.HandleFunc("/manager-api/analytics/top-items", h.TopItems).Methods(http.MethodGet)
+	router.HandleFunc("/manager-api/analytics/summary", h.SalesSummary).Methods(http.MethodGet)
+
+	router.HandleFunc("/admin-api/order-workflows", h.ListOrderWorkflows).Methods(http.MethodGet)
+
+	return router
+}
@@ -0,0 +1,110 @@
+package handling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// maxSearchWindow глубже этого эластик листать через from/size не даёт.
+	maxSearchWindow = 10000
+)
+
+func parseUUIDParam(query url.Values, name string) (uuid.UUID, error) {
+	value := query.Get(name)
+	if value == "" {
+		return uuid.Nil, nil
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("bad %s: %v", name, err)
+	}
+
+	return id, nil
+}
+
+func parseIntParam(query url.Values, name string, defaultValue int64) (int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad %s: must be a non-negative integer", name)
+	}
+
+	return n, nil
+}
+
+// SearchOrders поиск заказов для поддержки. Параметры: q (текст по имени
+// пользователя, позициям, адресу точки и логу заказа), order_id, status (можно
+// несколько через запятую), kitchen_id, cache_id, price_from и price_to в
+// копейках, offset и limit.
+func (h *Handling) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	params := elasticsearch.SearchOrdersParams{
+		Text:     strings.TrimSpace(query.Get("q")),
+		Statuses: parseListParam(query, "status"),
+	}
+
+	var err error
+	if params.OrderID, err = parseUUIDParam(query, "order_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.KitchenID, err = parseUUIDParam(query, "kitchen_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.CacheID, err = parseUUIDParam(query, "cache_id"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.PriceFrom, err = parseIntParam(query, "price_from", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.PriceTo, err = parseIntParam(query, "price_to", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := parseIntParam(query, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseIntParam(query, "limit", defaultSearchLimit)
+	if err != nil || limit == 0 || limit > maxSearchLimit {
+		http.Error(w, fmt.Sprintf("limit must be from 1 to %d", maxSearchLimit), http.StatusBadRequest)
+		return
+	}
+	if offset+limit > maxSearchWindow {
+		http.Error(w, fmt.Sprintf("offset+limit must not exceed %d, narrow the search", maxSearchWindow), http.StatusBadRequest)
+		return
+	}
+	params.Offset, params.Limit = int(offset), int(limit)
+
+	res, err := h.search.SearchOrders(r.Context(), params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(res)
+}
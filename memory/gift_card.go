@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+func giftCardResponse(card *postgres.GiftCard) *postgres.GiftCardResponse {
+	return &postgres.GiftCardResponse{
+		Code:           card.Code,
+		InitialBalance: card.InitialBalance,
+		Balance:        card.Balance,
+		Available:      card.Balance - card.Reserved,
+		Currency:       card.Currency,
+		ExpiresAt:      card.ExpiresAt,
+	}
+}
+
+func (s *Storage) IssueGiftCard(_ context.Context, params postgres.IssueGiftCardParams) (*postgres.GiftCardResponse, error) {
+	code, err := postgres.NewGiftCardCode()
+	if err != nil {
+		return nil, fmt.Errorf("make gift card code: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card := &postgres.GiftCard{
+		ID:             uuid.New(),
+		CreatedAt:      time.Now(),
+		Code:           code,
+		InitialBalance: params.InitialBalance,
+		Balance:        params.InitialBalance,
+		Currency:       params.Currency,
+		ExpiresAt:      params.ExpiresAt,
+	}
+	s.giftCards[card.Code] = card
+	s.addGiftCardMovement(card.ID, nil, postgres.GiftCardMovementIssue, card.InitialBalance)
+
+	return giftCardResponse(card), nil
+}
+
+func (s *Storage) GetGiftCardBalance(_ context.Context, code string) (*postgres.GiftCardResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.giftCards[code]
+	if !ok {
+		return nil, notFound("gift card", code)
+	}
+	return giftCardResponse(card), nil
+}
+
+// ReserveGiftCard как в постгресе: повтор для того же заказа вернёт прежний
+// резерв.
+func (s *Storage) ReserveGiftCard(_ context.Context, params postgres.ReserveGiftCardParams) (postgres.ReserveGiftCardResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result postgres.ReserveGiftCardResult
+
+	card, ok := s.giftCards[params.Code]
+	if !ok {
+		result.Reason = "подарочная карта не найдена"
+		return result, nil
+	}
+
+	if reserved := s.findGiftCardMovement(params.OrderID, postgres.GiftCardMovementReserve); reserved != nil {
+		result.Amount = reserved.Amount
+		return result, nil
+	}
+
+	available := card.Balance - card.Reserved
+
+	switch {
+	case !params.At.Before(card.ExpiresAt):
+		result.Reason = "срок действия подарочной карты истёк"
+		return result, nil
+	case card.Currency != params.Currency:
+		result.Reason = fmt.Sprintf("подарочная карта в валюте %s", card.Currency)
+		return result, nil
+	case available <= 0:
+		result.Reason = "на подарочной карте нет средств"
+		return result, nil
+	}
+
+	result.Amount = params.Amount
+	if available < result.Amount {
+		result.Amount = available
+	}
+
+	card.Reserved += result.Amount
+	if order, ok := s.orders[params.OrderID]; ok {
+		order.GiftCardAmount = result.Amount
+	}
+	orderID := params.OrderID
+	s.addGiftCardMovement(card.ID, &orderID, postgres.GiftCardMovementReserve, result.Amount)
+
+	return result, nil
+}
+
+func (s *Storage) FinalizeGiftCardRedemption(_ context.Context, orderID uuid.UUID) error {
+	return s.settleGiftCardReservation(orderID, postgres.GiftCardMovementRedeem)
+}
+
+func (s *Storage) ReleaseGiftCardReservation(_ context.Context, orderID uuid.UUID) error {
+	return s.settleGiftCardReservation(orderID, postgres.GiftCardMovementRelease)
+}
+
+func (s *Storage) settleGiftCardReservation(orderID uuid.UUID, kind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reserved := s.findGiftCardMovement(orderID, postgres.GiftCardMovementReserve)
+	if reserved == nil {
+		return nil
+	}
+
+	// Резерв закрывается только один раз: либо списанием, либо возвратом.
+	for _, settled := range []string{postgres.GiftCardMovementRedeem, postgres.GiftCardMovementRelease} {
+		if s.findGiftCardMovement(orderID, settled) != nil {
+			return nil
+		}
+	}
+
+	var card *postgres.GiftCard
+	for _, c := range s.giftCards {
+		if c.ID == reserved.GiftCardID {
+			card = c
+			break
+		}
+	}
+	if card == nil {
+		return fmt.Errorf("%s gift card reservation for order %s: %v", kind, orderID, notFound("gift card", reserved.GiftCardID))
+	}
+
+	card.Reserved -= reserved.Amount
+	if kind == postgres.GiftCardMovementRedeem {
+		card.Balance -= reserved.Amount
+	}
+	s.addGiftCardMovement(card.ID, &orderID, kind, reserved.Amount)
+
+	return nil
+}
+
+func (s *Storage) addGiftCardMovement(giftCardID uuid.UUID, orderID *uuid.UUID, kind string, amount int64) {
+	s.giftCardLedger = append(s.giftCardLedger, &postgres.GiftCardLedgerEntry{
+		ID:         uuid.New(),
+		CreatedAt:  time.Now(),
+		GiftCardID: giftCardID,
+		OrderID:    orderID,
+		Kind:       kind,
+		Amount:     amount,
+	})
+}
+
+// findGiftCardMovement движение по заказу. Карта у заказа одна, поэтому её не
+// проверяем.
+func (s *Storage) findGiftCardMovement(orderID uuid.UUID, kind string) *postgres.GiftCardLedgerEntry {
+	for _, entry := range s.giftCardLedger {
+		if entry.OrderID != nil && *entry.OrderID == orderID && entry.Kind == kind {
+			return entry
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// writeOutbox как в постгресе: документ для поиска с уже записанной версией
+// значит повтор, и ничего не пишется.
+func (s *Storage) writeOutbox(order *postgres.Order, outbox postgres.Outbox) error {
+	var version int64
+	if outbox.IndexOrder != nil {
+		version = outbox.IndexOrder.Version
+	}
+	if outbox.UpdateOrder != nil && outbox.UpdateOrder.Version > version {
+		version = outbox.UpdateOrder.Version
+	}
+
+	if version > 0 {
+		if order.PublishedVersion >= version {
+			return nil
+		}
+		order.PublishedVersion = version
+	}
+
+	add := func(kind string, payload interface{}) error {
+		bb, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("marshal %s outbox entry: %v", kind, err)
+		}
+
+		s.outboxLastID++
+		now := time.Now()
+		s.outbox = append(s.outbox, &postgres.OutboxEntry{
+			ID:            s.outboxLastID,
+			CreatedAt:     now,
+			OrderID:       order.ID,
+			Kind:          kind,
+			Payload:       string(bb),
+			NextAttemptAt: now,
+		})
+		return nil
+	}
+
+	if outbox.IndexOrder != nil {
+		if err := add(postgres.OutboxKindIndexOrder, outbox.IndexOrder); err != nil {
+			return err
+		}
+	}
+	if outbox.UpdateOrder != nil {
+		if err := add(postgres.OutboxKindUpdateOrder, outbox.UpdateOrder); err != nil {
+			return err
+		}
+	}
+	for _, event := range outbox.Notifications {
+		if err := add(postgres.OutboxKindNotification, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) PublishOrderChanges(_ context.Context, orderID uuid.UUID, outbox postgres.Outbox) error {
+	if outbox.UpdateOrder == nil {
+		return fmt.Errorf("publish changes of order %s: outbox has no search update", orderID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return notFound("order", orderID)
+	}
+	return s.writeOutbox(order, outbox)
+}
+
+// DeliverOutbox то же, что postgres.Postgres.DeliverOutbox: по одной самой
+// ранней записи каждого заказа, доставленные удаляются, остальные ждут
+// backoff, а после maxAttempts попыток откладываются насовсем.
+func (s *Storage) DeliverOutbox(ctx context.Context, limit, maxAttempts int, backoff func(attempts int) time.Duration, deliver func(ctx context.Context, entries []*postgres.OutboxEntry) []error) (int, error) {
+	if !s.deliverMu.TryLock() {
+		return 0, nil
+	}
+	defer s.deliverMu.Unlock()
+
+	entries := s.outboxHeads(limit)
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	// Пока идёт доставка, воркфлоу могут дописывать outbox, поэтому без
+	// блокировки. Сами записи меняет только этот метод.
+	errs := deliver(ctx, entries)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	delivered := make(map[int64]bool)
+	for i, entry := range entries {
+		if errs[i] != nil {
+			entry.Attempts++
+			entry.LastError = errs[i].Error()
+			entry.NextAttemptAt = now.Add(backoff(entry.Attempts))
+			if entry.Attempts >= maxAttempts {
+				entry.ParkedAt = &now
+			}
+			continue
+		}
+		delivered[entry.ID] = true
+	}
+
+	left := make([]*postgres.OutboxEntry, 0, len(s.outbox))
+	for _, entry := range s.outbox {
+		if !delivered[entry.ID] {
+			left = append(left, entry)
+		}
+	}
+	s.outbox = left
+
+	return len(delivered), nil
+}
+
+func (s *Storage) outboxHeads(limit int) []*postgres.OutboxEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	seen := make(map[uuid.UUID]bool)
+	heads := make([]*postgres.OutboxEntry, 0)
+
+	// Записи лежат по возрастанию ID, первая неотложенная запись заказа и есть
+	// его голова.
+	for _, entry := range s.outbox {
+		if entry.ParkedAt != nil || seen[entry.OrderID] {
+			continue
+		}
+		seen[entry.OrderID] = true
+
+		if !entry.NextAttemptAt.After(now) {
+			heads = append(heads, entry)
+		}
+	}
+
+	if len(heads) > limit {
+		heads = heads[:limit]
+	}
+
+	return heads
+}
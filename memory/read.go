@@ -0,0 +1,215 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+func (s *Storage) GetMenu(_ context.Context) (*postgres.MenuResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	menu := &postgres.MenuResponse{
+		Users:  make([]*postgres.UserResponse, 0),
+		Items:  make([]*postgres.ItemResponse, 0),
+		Points: make([]*postgres.PointResponse, 0),
+	}
+
+	for _, user := range s.users {
+		menu.Users = append(menu.Users, &postgres.UserResponse{
+			ID:   user.ID,
+			Name: user.Name,
+		})
+	}
+	for _, item := range s.items {
+		menu.Items = append(menu.Items, &postgres.ItemResponse{
+			ID:       item.ID,
+			Title:    item.Title,
+			Price:    item.Price,
+			Currency: item.Currency,
+		})
+	}
+	for _, point := range s.points {
+		menu.Points = append(menu.Points, &postgres.PointResponse{
+			ID:        point.ID,
+			Addr:      point.Addr,
+			KitchenID: point.KitchenID,
+			CacheID:   point.CacheID,
+		})
+	}
+
+	// Как в базе, по идентификатору, что бы порядок не прыгал.
+	sort.Slice(menu.Users, func(i, j int) bool { return menu.Users[i].ID.String() < menu.Users[j].ID.String() })
+	sort.Slice(menu.Items, func(i, j int) bool { return menu.Items[i].ID.String() < menu.Items[j].ID.String() })
+	sort.Slice(menu.Points, func(i, j int) bool { return menu.Points[i].ID.String() < menu.Points[j].ID.String() })
+
+	return menu, nil
+}
+
+func (s *Storage) ListKitchenCookItems(_ context.Context, kitchenID uuid.UUID) ([]*postgres.KitchenCookItemResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cookItems := make([]*postgres.CookItem, 0)
+	for _, cookItem := range s.cookItems {
+		if cookItem.KitchenID == kitchenID && cookItem.ReadyAt == nil && cookItem.RemovedAt == nil {
+			cookItems = append(cookItems, cookItem)
+		}
+	}
+	sort.Slice(cookItems, func(i, j int) bool { return cookItems[i].CreatedAt.Before(cookItems[j].CreatedAt) })
+
+	res := make([]*postgres.KitchenCookItemResponse, 0)
+	for _, cookItem := range cookItems {
+		res = append(res, &postgres.KitchenCookItemResponse{
+			ID:       cookItem.ID,
+			Title:    cookItem.Title,
+			Quantity: cookItem.Quantity,
+			OrderID:  cookItem.OrderID,
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Storage) ListCacheOrders(_ context.Context, cacheID uuid.UUID) ([]*postgres.CacheOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cacheOrders := make([]*postgres.CacheOrder, 0)
+	for _, order := range s.cacheOrders {
+		if order.CacheID == cacheID && order.HandedOverAt == nil && order.RemovedAt == nil {
+			cacheOrders = append(cacheOrders, order)
+		}
+	}
+	sort.Slice(cacheOrders, func(i, j int) bool { return cacheOrders[i].CreatedAt.Before(cacheOrders[j].CreatedAt) })
+
+	res := make([]*postgres.CacheOrderResponse, 0)
+	for _, order := range cacheOrders {
+		res = append(res, &postgres.CacheOrderResponse{
+			ID:               order.ID,
+			OrderID:          order.OrderID,
+			UserName:         order.UserName,
+			Status:           order.Status,
+			ReadinessPercent: order.ReadinessPercent,
+			CheckList:        order.CheckList,
+		})
+	}
+
+	return res, nil
+}
+
+// newerFirst от новых к старым по времени завершения, при равенстве по id, как
+// ORDER BY at DESC, id DESC в постгресе.
+func newerFirst(a, b time.Time, aID, bID uuid.UUID) bool {
+	if !a.Equal(b) {
+		return a.After(b)
+	}
+	return aID.String() > bID.String()
+}
+
+// afterCursor строка с временем at и id идёт после курсора, как historyBefore
+// в постгресе.
+func afterCursor(before postgres.HistoryCursor, at time.Time, id uuid.UUID) bool {
+	switch {
+	case before.At.IsZero():
+		return true
+	case before.ID == uuid.Nil:
+		return at.Before(before.At)
+	}
+	return newerFirst(before.At, at, before.ID, id)
+}
+
+func (s *Storage) ListKitchenCookItemsHistory(_ context.Context, kitchenID uuid.UUID, before postgres.HistoryCursor, limit int) ([]*postgres.KitchenCookItemHistoryResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cookItems := make([]*postgres.CookItem, 0)
+	for _, cookItem := range s.cookItems {
+		if cookItem.KitchenID != kitchenID || cookItem.ReadyAt == nil {
+			continue
+		}
+		if !afterCursor(before, *cookItem.ReadyAt, cookItem.ID) {
+			continue
+		}
+		cookItems = append(cookItems, cookItem)
+	}
+	sort.Slice(cookItems, func(i, j int) bool {
+		return newerFirst(*cookItems[i].ReadyAt, *cookItems[j].ReadyAt, cookItems[i].ID, cookItems[j].ID)
+	})
+	if len(cookItems) > limit {
+		cookItems = cookItems[:limit]
+	}
+
+	res := make([]*postgres.KitchenCookItemHistoryResponse, 0)
+	for _, cookItem := range cookItems {
+		res = append(res, &postgres.KitchenCookItemHistoryResponse{
+			ID:        cookItem.ID,
+			Title:     cookItem.Title,
+			Quantity:  cookItem.Quantity,
+			OrderID:   cookItem.OrderID,
+			CreatedAt: cookItem.CreatedAt,
+			ReadyAt:   *cookItem.ReadyAt,
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Storage) ListCacheOrdersHistory(_ context.Context, cacheID uuid.UUID, before postgres.HistoryCursor, limit int) ([]*postgres.CacheOrderHistoryResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cacheOrders := make([]*postgres.CacheOrder, 0)
+	for _, order := range s.cacheOrders {
+		if order.CacheID != cacheID || order.HandedOverAt == nil {
+			continue
+		}
+		if !afterCursor(before, *order.HandedOverAt, order.ID) {
+			continue
+		}
+		cacheOrders = append(cacheOrders, order)
+	}
+	sort.Slice(cacheOrders, func(i, j int) bool {
+		return newerFirst(*cacheOrders[i].HandedOverAt, *cacheOrders[j].HandedOverAt, cacheOrders[i].ID, cacheOrders[j].ID)
+	})
+	if len(cacheOrders) > limit {
+		cacheOrders = cacheOrders[:limit]
+	}
+
+	res := make([]*postgres.CacheOrderHistoryResponse, 0)
+	for _, order := range cacheOrders {
+		res = append(res, &postgres.CacheOrderHistoryResponse{
+			ID:           order.ID,
+			OrderID:      order.OrderID,
+			UserName:     order.UserName,
+			CheckList:    order.CheckList,
+			CreatedAt:    order.CreatedAt,
+			HandedOverAt: *order.HandedOverAt,
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Storage) GetReceipt(_ context.Context, orderID uuid.UUID) (*postgres.ReceiptResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	receipt, ok := s.receipts[orderID]
+	if !ok {
+		return nil, notFound("receipt", orderID)
+	}
+
+	return &postgres.ReceiptResponse{
+		OrderID:      receipt.ID,
+		IssuedAt:     receipt.IssuedAt,
+		FiscalNumber: receipt.FiscalNumber,
+		Document:     json.RawMessage(receipt.Document),
+	}, nil
+}
@@ -0,0 +1,465 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/handling"
+	"github.com/krocos/coffee-shop/outbox"
+)
+
+// SourceMemory откуда отдан список заказов пользователя.
+const SourceMemory = "memory"
+
+// paidStatuses как в elasticsearch, заказы, за которые получены деньги.
+var paidStatuses = []string{"paid", "cooking", "ready", "received"}
+
+// Search поиск заказов в памяти вместо эластика. Фильтры, порядок и курсоры
+// те же, полнотекстовый поиск упрощён до поиска подстрок без подсветки.
+type Search struct {
+	mu   sync.Mutex
+	docs map[string]*elasticsearch.Order
+}
+
+// Search подменяет elasticsearch.Elasticsearch, как и Storage постгрес.
+var (
+	_ handling.Search     = (*Search)(nil)
+	_ handling.UserOrders = (*Search)(nil)
+	_ outbox.Search       = (*Search)(nil)
+)
+
+func NewSearch() *Search {
+	return &Search{docs: make(map[string]*elasticsearch.Order)}
+}
+
+// copyDoc через json, что бы снаружи никто не менял хранимый документ.
+func copyDoc(doc *elasticsearch.Order) (*elasticsearch.Order, error) {
+	bb, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	res := new(elasticsearch.Order)
+	if err = json.Unmarshal(bb, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// IndexOrder создаёт документ, если его ещё нет, как op_type create.
+func (s *Search) IndexOrder(_ context.Context, id uuid.UUID, doc *elasticsearch.Order, _ bool) error {
+	stored, err := copyDoc(doc)
+	if err != nil {
+		return fmt.Errorf("index order %s: %v", id, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.docs[id.String()]; !ok {
+		s.docs[id.String()] = stored
+	}
+
+	return nil
+}
+
+// UpdateOrder применяет частичный документ, если version новее записанной.
+func (s *Search) UpdateOrder(_ context.Context, id uuid.UUID, version int64, partialDoc *elasticsearch.Order, _ bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.docs[id.String()]
+	if !ok {
+		return fmt.Errorf("order %s not found", id)
+	}
+	if current.Version >= version {
+		return nil
+	}
+
+	partialDoc.Version = version
+
+	updated, err := mergeDoc(current, partialDoc)
+	if err != nil {
+		return fmt.Errorf("update order %s: %v", id, err)
+	}
+	s.docs[id.String()] = updated
+
+	return nil
+}
+
+// mergeDoc накладывает частичный документ как partial update эластика:
+// объекты сливаются по полям, всё остальное, и массивы тоже, заменяется.
+func mergeDoc(current, partial *elasticsearch.Order) (*elasticsearch.Order, error) {
+	toMap := func(doc *elasticsearch.Order) (map[string]interface{}, error) {
+		bb, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{})
+		return m, json.Unmarshal(bb, &m)
+	}
+
+	dst, err := toMap(current)
+	if err != nil {
+		return nil, err
+	}
+	src, err := toMap(partial)
+	if err != nil {
+		return nil, err
+	}
+	mergeMaps(dst, src)
+
+	bb, err := json.Marshal(dst)
+	if err != nil {
+		return nil, err
+	}
+	res := new(elasticsearch.Order)
+	return res, json.Unmarshal(bb, res)
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if srcObject, ok := value.(map[string]interface{}); ok {
+			if dstObject, ok := dst[key].(map[string]interface{}); ok {
+				mergeMaps(dstObject, srcObject)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// indexedOrder документ вместе с разобранным временем создания.
+type indexedOrder struct {
+	doc       *elasticsearch.Order
+	createdAt time.Time
+}
+
+// find документы, подходящие под match, от новых к старым. Время в поиске с
+// точностью до секунды, при равенстве порядок по id, тоже с конца.
+func (s *Search) find(match func(doc *elasticsearch.Order, createdAt time.Time) bool) []indexedOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := make([]indexedOrder, 0)
+	for _, doc := range s.docs {
+		createdAt, err := time.Parse(time.RFC3339, doc.CreatedAt)
+		if err != nil {
+			continue
+		}
+		if match(doc, createdAt) {
+			found = append(found, indexedOrder{doc: doc, createdAt: createdAt})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].createdAt.Equal(found[j].createdAt) {
+			return found[i].createdAt.After(found[j].createdAt)
+		}
+		return found[i].doc.ID > found[j].doc.ID
+	})
+
+	return found
+}
+
+func hasStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func marshalDoc(doc *elasticsearch.Order) (json.RawMessage, error) {
+	bb, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal order %s: %v", doc.ID, err)
+	}
+	return bb, nil
+}
+
+func (s *Search) ListUserOrders(_ context.Context, params elasticsearch.ListUserOrdersParams) (*elasticsearch.UserOrdersPage, error) {
+	var (
+		after   bool
+		afterAt time.Time
+		afterID string
+	)
+	if params.Cursor != "" {
+		var err error
+		if afterAt, afterID, err = elasticsearch.DecodeOrderCursor(params.Cursor); err != nil {
+			return nil, err
+		}
+		after = true
+	}
+
+	found := s.find(func(doc *elasticsearch.Order, createdAt time.Time) bool {
+		switch {
+		case doc.User == nil || doc.User.ID != params.UserID.String():
+			return false
+		case len(params.Statuses) > 0 && !hasStatus(params.Statuses, doc.Status):
+			return false
+		case params.PointID != uuid.Nil && (doc.Point == nil || doc.Point.ID != params.PointID.String()):
+			return false
+		case !params.From.IsZero() && createdAt.Before(params.From):
+			return false
+		case !params.To.IsZero() && !createdAt.Before(params.To):
+			return false
+		case after && (createdAt.After(afterAt) || createdAt.Equal(afterAt) && doc.ID >= afterID):
+			return false
+		}
+		return true
+	})
+
+	page := &elasticsearch.UserOrdersPage{
+		Orders: make([]json.RawMessage, 0),
+		Source: SourceMemory,
+	}
+
+	if len(found) > params.Limit {
+		found = found[:params.Limit]
+		last := found[len(found)-1]
+
+		var err error
+		if page.NextCursor, err = elasticsearch.EncodeOrderCursor(last.createdAt, last.doc.ID); err != nil {
+			return nil, fmt.Errorf("encode cursor: %v", err)
+		}
+	}
+
+	for _, order := range found {
+		bb, err := marshalDoc(order.doc)
+		if err != nil {
+			return nil, err
+		}
+		page.Orders = append(page.Orders, bb)
+	}
+
+	return page, nil
+}
+
+// textFields то же, что ищет поддержка в эластике.
+func textFields(doc *elasticsearch.Order) []string {
+	fields := make([]string, 0)
+	if doc.User != nil {
+		fields = append(fields, doc.User.Name)
+	}
+	if doc.Point != nil {
+		fields = append(fields, doc.Point.Addr)
+	}
+	for _, item := range doc.Items {
+		fields = append(fields, item.Title)
+	}
+	for _, item := range doc.LogItems {
+		fields = append(fields, item.Text)
+	}
+	return fields
+}
+
+// matchText каждое слово запроса есть хотя бы в одном из полей.
+func matchText(doc *elasticsearch.Order, text string) bool {
+	fields := textFields(doc)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		found := false
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Search) SearchOrders(_ context.Context, params elasticsearch.SearchOrdersParams) (*elasticsearch.SearchOrdersResult, error) {
+	found := s.find(func(doc *elasticsearch.Order, _ time.Time) bool {
+		switch {
+		case params.Text != "" && !matchText(doc, params.Text):
+			return false
+		case params.OrderID != uuid.Nil && doc.ID != params.OrderID.String():
+			return false
+		case len(params.Statuses) > 0 && !hasStatus(params.Statuses, doc.Status):
+			return false
+		case params.KitchenID != uuid.Nil && (doc.Point == nil || doc.Point.KitchenID != params.KitchenID.String()):
+			return false
+		case params.CacheID != uuid.Nil && (doc.Point == nil || doc.Point.CacheID != params.CacheID.String()):
+			return false
+		case params.PriceFrom > 0 && doc.TotalPrice < params.PriceFrom:
+			return false
+		case params.PriceTo > 0 && doc.TotalPrice > params.PriceTo:
+			return false
+		}
+		return true
+	})
+
+	result := &elasticsearch.SearchOrdersResult{
+		Total:  int64(len(found)),
+		Orders: make([]*elasticsearch.FoundOrder, 0),
+	}
+
+	if params.Offset < len(found) {
+		found = found[params.Offset:]
+	} else {
+		found = nil
+	}
+	if len(found) > params.Limit {
+		found = found[:params.Limit]
+	}
+
+	for _, order := range found {
+		bb, err := marshalDoc(order.doc)
+		if err != nil {
+			return nil, err
+		}
+		result.Orders = append(result.Orders, &elasticsearch.FoundOrder{Order: bb})
+	}
+
+	return result, nil
+}
+
+// inPeriod заказы за [from, to) и, если задана, точки.
+func (s *Search) inPeriod(from, to time.Time, pointID uuid.UUID) []indexedOrder {
+	return s.find(func(doc *elasticsearch.Order, createdAt time.Time) bool {
+		if createdAt.Before(from) || !createdAt.Before(to) {
+			return false
+		}
+		return pointID == uuid.Nil || doc.Point != nil && doc.Point.ID == pointID.String()
+	})
+}
+
+// bucketStart начало дня или часа в часовом поясе точки.
+func bucketStart(t time.Time, interval string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	if interval == "hour" {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+func nextBucket(t time.Time, interval string) time.Time {
+	if interval == "hour" {
+		return t.Add(time.Hour)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func (s *Search) Revenue(_ context.Context, params elasticsearch.RevenueParams) ([]*elasticsearch.PointRevenue, error) {
+	list := make([]*elasticsearch.PointRevenue, 0)
+
+	for _, point := range params.Points {
+		loc, err := time.LoadLocation(point.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate revenue of point %s: %v", point.ID, err)
+		}
+
+		pointRevenue := &elasticsearch.PointRevenue{
+			PointID:  point.ID,
+			Timezone: point.Timezone,
+			Buckets:  make([]*elasticsearch.RevenueBucket, 0),
+		}
+
+		// Пустые интервалы тоже нужны, как с min_doc_count 0 в эластике.
+		buckets := make(map[int64]*elasticsearch.RevenueBucket)
+		for start := bucketStart(params.From, params.Interval, loc); start.Before(params.To); start = nextBucket(start, params.Interval) {
+			bucket := &elasticsearch.RevenueBucket{Start: start.Format(time.RFC3339)}
+			buckets[start.Unix()] = bucket
+			pointRevenue.Buckets = append(pointRevenue.Buckets, bucket)
+		}
+
+		for _, order := range s.inPeriod(params.From, params.To, point.ID) {
+			paid := hasStatus(paidStatuses, order.doc.Status)
+
+			pointRevenue.OrdersCount++
+			if paid {
+				pointRevenue.PaidCount++
+				pointRevenue.Revenue += order.doc.TotalPrice
+			}
+
+			bucket, ok := buckets[bucketStart(order.createdAt, params.Interval, loc).Unix()]
+			if !ok {
+				continue
+			}
+			bucket.OrdersCount++
+			if paid {
+				bucket.PaidCount++
+				bucket.Revenue += order.doc.TotalPrice
+			}
+		}
+
+		list = append(list, pointRevenue)
+	}
+
+	return list, nil
+}
+
+func (s *Search) TopItems(_ context.Context, filter elasticsearch.AnalyticsFilter, byRevenue bool, limit int) ([]*elasticsearch.TopItem, error) {
+	items := make(map[string]*elasticsearch.TopItem)
+	list := make([]*elasticsearch.TopItem, 0)
+
+	for _, order := range s.inPeriod(filter.From, filter.To, filter.PointID) {
+		if !hasStatus(paidStatuses, order.doc.Status) {
+			continue
+		}
+
+		counted := make(map[string]bool)
+		for _, orderItem := range order.doc.Items {
+			item, ok := items[orderItem.ItemID]
+			if !ok {
+				item = &elasticsearch.TopItem{ItemID: orderItem.ItemID, Title: orderItem.Title}
+				items[orderItem.ItemID] = item
+				list = append(list, item)
+			}
+			item.Quantity += orderItem.Quantity
+			item.Revenue += orderItem.TotalPrice
+			if !counted[orderItem.ItemID] {
+				counted[orderItem.ItemID] = true
+				item.Orders++
+			}
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if byRevenue {
+			return list[i].Revenue > list[j].Revenue
+		}
+		return list[i].Quantity > list[j].Quantity
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	return list, nil
+}
+
+func (s *Search) SalesSummary(_ context.Context, filter elasticsearch.AnalyticsFilter) (*elasticsearch.SalesSummary, error) {
+	summary := new(elasticsearch.SalesSummary)
+
+	for _, order := range s.inPeriod(filter.From, filter.To, filter.PointID) {
+		summary.OrdersCount++
+		if hasStatus(paidStatuses, order.doc.Status) {
+			summary.PaidCount++
+			summary.Revenue += order.doc.TotalPrice
+		}
+		if order.doc.Status == "payment_timeout" {
+			summary.PaymentTimeouts++
+		}
+	}
+
+	if summary.PaidCount > 0 {
+		summary.AverageOrderValue = int64(float64(summary.Revenue)/float64(summary.PaidCount) + 0.5)
+	}
+	if summary.OrdersCount > 0 {
+		summary.PaymentTimeoutRate = float64(summary.PaymentTimeouts) / float64(summary.OrdersCount)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,109 @@
+package memory_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/memory"
+)
+
+func getOrder(t *testing.T, search *memory.Search, id uuid.UUID) *elasticsearch.Order {
+	result, err := search.SearchOrders(context.Background(), elasticsearch.SearchOrdersParams{OrderID: id, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, result.Orders, 1)
+
+	doc := new(elasticsearch.Order)
+	require.NoError(t, json.Unmarshal(result.Orders[0].Order, doc))
+	return doc
+}
+
+func TestSearchUpdateOrder(t *testing.T) {
+	ctx := context.Background()
+	search := memory.NewSearch()
+	id := uuid.New()
+
+	require.NoError(t, search.IndexOrder(ctx, id, &elasticsearch.Order{
+		ID:        id.String(),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Status:    "waiting_for_payment",
+		Point:     &elasticsearch.Point{ID: uuid.NewString(), Addr: "Тестовая, 1"},
+		Version:   1,
+	}, false))
+	// Повтор создания не затирает документ, как op_type create.
+	require.NoError(t, search.IndexOrder(ctx, id, &elasticsearch.Order{ID: id.String(), Status: "other", Version: 1}, false))
+	require.Equal(t, "waiting_for_payment", getOrder(t, search, id).Status)
+
+	require.NoError(t, search.UpdateOrder(ctx, id, 3, &elasticsearch.Order{
+		Status: "cooking",
+		Point:  &elasticsearch.Point{KitchenID: "kitchen"},
+	}, false))
+	// Запоздавшее обновление старой версии отбрасывается.
+	require.NoError(t, search.UpdateOrder(ctx, id, 2, &elasticsearch.Order{Status: "paid"}, false))
+
+	doc := getOrder(t, search, id)
+	require.Equal(t, "cooking", doc.Status)
+	require.Equal(t, int64(3), doc.Version)
+	// Вложенные объекты сливаются по полям, как partial update.
+	require.Equal(t, "Тестовая, 1", doc.Point.Addr)
+	require.Equal(t, "kitchen", doc.Point.KitchenID)
+
+	require.Error(t, search.UpdateOrder(ctx, uuid.New(), 1, &elasticsearch.Order{Status: "paid"}, false))
+}
+
+func TestSearchListUserOrders(t *testing.T) {
+	ctx := context.Background()
+	search := memory.NewSearch()
+	userID := uuid.New()
+
+	// Время в поиске до секунды, поэтому у заказов одно время и страницы
+	// различает только id.
+	createdAt := time.Now().Format(time.RFC3339)
+	for i := 0; i < 5; i++ {
+		id := uuid.New()
+		require.NoError(t, search.IndexOrder(ctx, id, &elasticsearch.Order{
+			ID:        id.String(),
+			CreatedAt: createdAt,
+			Status:    "paid",
+			User:      &elasticsearch.User{ID: userID.String()},
+		}, false))
+	}
+	other := uuid.New()
+	require.NoError(t, search.IndexOrder(ctx, other, &elasticsearch.Order{
+		ID:        other.String(),
+		CreatedAt: createdAt,
+		User:      &elasticsearch.User{ID: uuid.NewString()},
+	}, false))
+
+	seen := make(map[string]bool)
+	params := elasticsearch.ListUserOrdersParams{UserID: userID, Limit: 2}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5, "cursor does not move")
+
+		page, err := search.ListUserOrders(ctx, params)
+		require.NoError(t, err)
+		require.Equal(t, memory.SourceMemory, page.Source)
+
+		for _, bb := range page.Orders {
+			doc := new(elasticsearch.Order)
+			require.NoError(t, json.Unmarshal(bb, doc))
+			require.False(t, seen[doc.ID], "order %s is on two pages", doc.ID)
+			seen[doc.ID] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+	require.Len(t, seen, 5)
+	require.False(t, seen[other.String()])
+
+	_, err := search.ListUserOrders(ctx, elasticsearch.ListUserOrdersParams{UserID: userID, Limit: 2, Cursor: "bad"})
+	require.Error(t, err)
+}
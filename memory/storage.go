@@ -0,0 +1,408 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/handling"
+	"github.com/krocos/coffee-shop/money"
+	"github.com/krocos/coffee-shop/outbox"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+const defaultTimezone = "Asia/Yekaterinburg"
+
+// Storage хранилище в памяти, которое ведёт себя как postgres.Postgres:
+// активити воркфлоу, чтения для апи и outbox для релея. Повторы активити так
+// же ничего не задваивают. Данные живут, пока жив процесс, поэтому годится
+// только для разработки, см. cmd/devstack.
+type Storage struct {
+	mu sync.Mutex
+
+	users  map[uuid.UUID]*postgres.User
+	items  map[uuid.UUID]*postgres.Item
+	points map[uuid.UUID]*postgres.Point
+
+	orders      map[uuid.UUID]*postgres.Order
+	cookItems   map[uuid.UUID]*postgres.CookItem
+	cacheOrders map[uuid.UUID]*postgres.CacheOrder
+	receipts    map[uuid.UUID]*postgres.Receipt
+
+	giftCards      map[string]*postgres.GiftCard
+	giftCardLedger []*postgres.GiftCardLedgerEntry
+
+	zReports map[zReportKey]*postgres.ZReport
+
+	outbox       []*postgres.OutboxEntry
+	outboxLastID int64
+	// deliverMu вместо блокировки и аренды записей в постгресе: outbox
+	// разбирает один релей за раз.
+	deliverMu sync.Mutex
+}
+
+// Storage подменяет postgres.Postgres везде, где его берёт cmd/devstack, и
+// пропущенный метод должен ломать сборку, а не воркер на первом вызове.
+var (
+	_ backend.Storage  = (*Storage)(nil)
+	_ handling.Storage = (*Storage)(nil)
+	_ outbox.Storage   = (*Storage)(nil)
+)
+
+// NewStorage хранилище с данными из seed. Пустые идентификаторы и значения по
+// умолчанию заполняются так же, как это делает база, прямо в seed.
+func NewStorage(seed postgres.Seed) *Storage {
+	s := &Storage{
+		users:       make(map[uuid.UUID]*postgres.User),
+		items:       make(map[uuid.UUID]*postgres.Item),
+		points:      make(map[uuid.UUID]*postgres.Point),
+		orders:      make(map[uuid.UUID]*postgres.Order),
+		cookItems:   make(map[uuid.UUID]*postgres.CookItem),
+		cacheOrders: make(map[uuid.UUID]*postgres.CacheOrder),
+		receipts:    make(map[uuid.UUID]*postgres.Receipt),
+		giftCards:   make(map[string]*postgres.GiftCard),
+		zReports:    make(map[zReportKey]*postgres.ZReport),
+	}
+
+	for _, user := range seed.Users {
+		if user.ID == uuid.Nil {
+			user.ID = uuid.New()
+		}
+		s.users[user.ID] = user
+	}
+
+	for _, item := range seed.Items {
+		if item.ID == uuid.Nil {
+			item.ID = uuid.New()
+		}
+		if item.Currency == "" {
+			item.Currency = money.DefaultCurrency
+		}
+		s.items[item.ID] = item
+	}
+
+	for _, point := range seed.Points {
+		if point.ID == uuid.Nil {
+			point.ID = uuid.New()
+		}
+		if point.KitchenID == uuid.Nil {
+			point.KitchenID = uuid.New()
+		}
+		if point.CacheID == uuid.Nil {
+			point.CacheID = uuid.New()
+		}
+		if point.Timezone == "" {
+			point.Timezone = defaultTimezone
+		}
+		s.points[point.ID] = point
+	}
+
+	return s
+}
+
+func notFound(what string, id interface{}) error {
+	return fmt.Errorf("%s %v: %w", what, id, gorm.ErrRecordNotFound)
+}
+
+func (s *Storage) GetUserData(_ context.Context, userID uuid.UUID) (postgres.UserData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return postgres.UserData{}, notFound("user", userID)
+	}
+	return postgres.UserData{
+		ID:   user.ID,
+		Name: user.Name,
+	}, nil
+}
+
+func (s *Storage) GetItemsData(_ context.Context, itemIDs []uuid.UUID) ([]postgres.ItemData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]postgres.ItemData, 0)
+	for _, id := range itemIDs {
+		item, ok := s.items[id]
+		if !ok {
+			continue
+		}
+		list = append(list, postgres.ItemData{
+			ID:       item.ID,
+			Title:    item.Title,
+			Price:    item.Price,
+			Currency: item.Currency,
+			VATRate:  item.VATRate,
+		})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Title < list[j].Title })
+
+	return list, nil
+}
+
+func pointData(point *postgres.Point) postgres.PointData {
+	return postgres.PointData{
+		ID:        point.ID,
+		Addr:      point.Addr,
+		KitchenID: point.KitchenID,
+		CacheID:   point.CacheID,
+		Timezone:  point.Timezone,
+	}
+}
+
+func (s *Storage) GetPointData(_ context.Context, pointID uuid.UUID) (postgres.PointData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	point, ok := s.points[pointID]
+	if !ok {
+		return postgres.PointData{}, notFound("point", pointID)
+	}
+	return pointData(point), nil
+}
+
+func (s *Storage) ListPoints(_ context.Context) ([]postgres.PointData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]postgres.PointData, 0)
+	for _, point := range s.points {
+		list = append(list, pointData(point))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID.String() < list[j].ID.String() })
+
+	return list, nil
+}
+
+func (s *Storage) CreateOrder(_ context.Context, params postgres.OrderParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Если заказ уже есть, то это повтор активити.
+	if _, ok := s.orders[params.ID]; ok {
+		return nil
+	}
+
+	order := &postgres.Order{
+		ID:         params.ID,
+		CreatedAt:  params.CreatedAt,
+		Status:     params.Status,
+		TotalPrice: params.TotalPrice,
+		Currency:   params.Currency,
+		VATAmount:  params.VATAmount,
+		PINCode:    params.PINCode,
+		UserID:     params.UserID,
+		User:       s.users[params.UserID],
+		PointID:    params.PointID,
+		Point:      s.points[params.PointID],
+		Items:      make([]*postgres.OrderItem, 0),
+		LogItems:   make([]*postgres.LogItem, 0),
+		Events:     []*postgres.OrderEvent{newOrderEvent(params.ID, params.Event)},
+	}
+	for _, itemParams := range params.Items {
+		order.Items = append(order.Items, &postgres.OrderItem{
+			ID:         itemParams.ID,
+			Title:      itemParams.Title,
+			Price:      itemParams.Price,
+			ItemID:     itemParams.ItemID,
+			Quantity:   itemParams.Quantity,
+			TotalPrice: itemParams.TotalPrice,
+			VATRate:    itemParams.VATRate,
+			VATAmount:  itemParams.VATAmount,
+			OrderID:    params.ID,
+		})
+	}
+	s.orders[order.ID] = order
+
+	return s.writeOutbox(order, params.Outbox)
+}
+
+func newOrderEvent(orderID uuid.UUID, e postgres.OrderEventParams) *postgres.OrderEvent {
+	payload := "{}"
+	if len(e.Payload) > 0 {
+		payload = string(e.Payload)
+	}
+
+	return &postgres.OrderEvent{
+		ID:      uuid.New(),
+		OrderID: orderID,
+		Status:  e.Status,
+		At:      e.At,
+		Actor:   e.Actor,
+		Payload: payload,
+	}
+}
+
+// changeOrderStatus как в постгресе: если смена уже записана, то это повтор и
+// возвращается false.
+func (s *Storage) changeOrderStatus(orderID uuid.UUID, event postgres.OrderEventParams) (*postgres.Order, bool, error) {
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, false, notFound("order", orderID)
+	}
+
+	for _, e := range order.Events {
+		if e.Status == event.Status {
+			return order, false, nil
+		}
+	}
+
+	order.Events = append(order.Events, newOrderEvent(orderID, event))
+	order.Status = event.Status
+
+	return order, true, nil
+}
+
+func (s *Storage) ChangeOrderStatus(_ context.Context, params postgres.ChangeOrderStatusParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, changed, err := s.changeOrderStatus(params.OrderID, params.Event)
+	if err != nil || !changed {
+		return err
+	}
+	return s.writeOutbox(order, params.Outbox)
+}
+
+func (s *Storage) LogUnsuccessfulPayment(_ context.Context, pp postgres.LogUnsuccessfulPaymentParams) error {
+	return s.createLogItem(pp.ID, pp.OrderID, pp.Reason, pp.Outbox)
+}
+
+func (s *Storage) LogAttemptToEnterWrongPINCode(_ context.Context, pp postgres.LogAttemptToEnterWrongPINCodeParams) error {
+	return s.createLogItem(pp.ID, pp.OrderID, pp.Reason, pp.Outbox)
+}
+
+func (s *Storage) createLogItem(id, orderID uuid.UUID, text string, outbox postgres.Outbox) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return notFound("order", orderID)
+	}
+
+	for _, item := range order.LogItems {
+		if item.ID == id {
+			return nil
+		}
+	}
+
+	order.LogItems = append(order.LogItems, &postgres.LogItem{
+		ID:        id,
+		CreatedAt: time.Now(),
+		Text:      text,
+		OrderID:   orderID,
+	})
+
+	return s.writeOutbox(order, outbox)
+}
+
+func (s *Storage) StartCooking(_ context.Context, params postgres.StartCookingParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, changed, err := s.changeOrderStatus(params.OrderID, params.Event)
+	if err != nil || !changed {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, item := range params.Kitchen.Items {
+		s.cookItems[item.ID] = &postgres.CookItem{
+			ID:        item.ID,
+			CreatedAt: now,
+			Title:     item.Title,
+			Quantity:  item.Quantity,
+			KitchenID: params.Kitchen.KitchenID,
+			OrderID:   params.Kitchen.OrderID,
+		}
+	}
+
+	s.cacheOrders[params.Cache.ID] = &postgres.CacheOrder{
+		ID:               params.Cache.ID,
+		CreatedAt:        now,
+		CacheID:          params.Cache.CacheID,
+		OrderID:          params.Cache.OrderID,
+		UserName:         params.Cache.UserName,
+		Status:           params.Cache.Status,
+		ReadinessPercent: params.Cache.ReadinessPercent,
+		CheckList:        params.Cache.CheckList,
+	}
+
+	return s.writeOutbox(order, params.Outbox)
+}
+
+func (s *Storage) MarkItemCooked(_ context.Context, params postgres.MarkItemCookedParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cookItem, ok := s.cookItems[params.OrderItemID]
+	if !ok || cookItem.ReadyAt != nil {
+		return nil
+	}
+	at := params.At
+	cookItem.ReadyAt = &at
+
+	if cacheOrder, ok := s.cacheOrders[params.OrderID]; ok {
+		cacheOrder.ReadinessPercent = params.ReadinessPercent
+		cacheOrder.Status = params.CacheOrderStatus
+	}
+
+	if params.Event != nil {
+		if _, _, err := s.changeOrderStatus(params.OrderID, *params.Event); err != nil {
+			return err
+		}
+	}
+
+	order, ok := s.orders[params.OrderID]
+	if !ok {
+		return notFound("order", params.OrderID)
+	}
+	return s.writeOutbox(order, params.Outbox)
+}
+
+func (s *Storage) HandOverOrder(_ context.Context, params postgres.HandOverOrderParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cacheOrder, ok := s.cacheOrders[params.OrderID]
+	if !ok || cacheOrder.HandedOverAt != nil {
+		return nil
+	}
+	at := params.Event.At
+	cacheOrder.HandedOverAt = &at
+
+	order, _, err := s.changeOrderStatus(params.OrderID, params.Event)
+	if err != nil {
+		return err
+	}
+	return s.writeOutbox(order, params.Outbox)
+}
+
+func (s *Storage) SaveReceipt(_ context.Context, params postgres.SaveReceiptParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.receipts[params.OrderID]; ok {
+		return nil
+	}
+
+	s.receipts[params.OrderID] = &postgres.Receipt{
+		ID:           params.OrderID,
+		CreatedAt:    time.Now(),
+		IssuedAt:     params.IssuedAt,
+		FiscalNumber: params.FiscalNumber,
+		Document:     string(params.Document),
+	}
+
+	return nil
+}
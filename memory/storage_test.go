@@ -0,0 +1,427 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/handling"
+	"github.com/krocos/coffee-shop/memory"
+	"github.com/krocos/coffee-shop/outbox"
+	"github.com/krocos/coffee-shop/postgres"
+	"github.com/krocos/coffee-shop/postgres/pgtest"
+	"github.com/krocos/coffee-shop/sse"
+)
+
+// Сценарии ниже идут и на memory.Storage, и на postgres.Postgres, чтобы
+// хранилище в памяти не разошлось с базой, которую оно изображает.
+
+// storage то, что memory.Storage повторяет за postgres.Postgres.
+type storage interface {
+	backend.Storage
+	handling.Storage
+	outbox.Storage
+}
+
+type fixture struct {
+	ctx     context.Context
+	storage storage
+
+	user  *postgres.User
+	item  *postgres.Item
+	point *postgres.Point
+
+	// versions последняя версия документа заказа, записанная в outbox.
+	versions map[uuid.UUID]int64
+}
+
+func newSeed() postgres.Seed {
+	return postgres.Seed{
+		Users:  []*postgres.User{{Name: "Тест"}},
+		Items:  []*postgres.Item{{Title: "Капучино", Price: 25000, Currency: "RUB", VATRate: 20}},
+		Points: []*postgres.Point{{Addr: "Тестовая, 1"}},
+	}
+}
+
+// forEachStorage запускает сценарий на хранилище в памяти и на базе.
+func forEachStorage(t *testing.T, scenario func(t *testing.T, f *fixture)) {
+	t.Run("memory", func(t *testing.T) {
+		seed := newSeed()
+		scenario(t, newFixture(memory.NewStorage(seed), seed))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		seed := newSeed()
+		scenario(t, newFixture(newPostgres(t, seed), seed))
+	})
+}
+
+func newFixture(s storage, seed postgres.Seed) *fixture {
+	return &fixture{
+		ctx:      context.Background(),
+		storage:  s,
+		user:     seed.Users[0],
+		item:     seed.Items[0],
+		point:    seed.Points[0],
+		versions: make(map[uuid.UUID]int64),
+	}
+}
+
+// newPostgres база из pgtest с записанным seed.
+func newPostgres(t *testing.T, seed postgres.Seed) *postgres.Postgres {
+	db := pgtest.NewDB(t)
+
+	require.NoError(t, db.Create(seed.Users).Error)
+	require.NoError(t, db.Create(seed.Items).Error)
+	require.NoError(t, db.Create(seed.Points).Error)
+
+	return postgres.NewPostgres(db)
+}
+
+// at время с точностью базы, чтобы прочитанное из неё совпадало с записанным.
+func at(t time.Time) time.Time {
+	return t.Truncate(time.Microsecond)
+}
+
+// statusOutbox outbox смены статуса со следующей версией документа заказа.
+func (f *fixture) statusOutbox(orderID uuid.UUID, status string) postgres.Outbox {
+	f.versions[orderID]++
+	return postgres.Outbox{
+		UpdateOrder: &postgres.OutboxOrderUpdate{
+			Version: f.versions[orderID],
+			Doc:     &elasticsearch.Order{Status: status},
+		},
+		Notifications: []sse.Event{sse.NewOrderListUpdatedEvent().ForUser().WithID(f.user.ID)},
+	}
+}
+
+func (f *fixture) createOrder(t *testing.T, createdAt time.Time) postgres.OrderParams {
+	orderID := uuid.New()
+	f.versions[orderID] = 1
+
+	params := postgres.OrderParams{
+		ID:         orderID,
+		CreatedAt:  createdAt,
+		Status:     postgres.OrderStatusWaitingForPayment,
+		TotalPrice: 2 * f.item.Price,
+		Currency:   "RUB",
+		VATAmount:  8333,
+		PINCode:    "1234",
+		UserID:     f.user.ID,
+		PointID:    f.point.ID,
+		Items: []postgres.OrderItemParams{{
+			ID:         uuid.New(),
+			Title:      f.item.Title,
+			Price:      f.item.Price,
+			ItemID:     f.item.ID,
+			Quantity:   2,
+			TotalPrice: 2 * f.item.Price,
+			VATRate:    20,
+			VATAmount:  8333,
+		}},
+		Event: postgres.OrderEventParams{Status: postgres.OrderStatusWaitingForPayment, At: createdAt, Actor: postgres.OrderActorUser},
+		Outbox: postgres.Outbox{
+			IndexOrder:    &elasticsearch.Order{ID: orderID.String(), Version: 1, Status: postgres.OrderStatusWaitingForPayment},
+			Notifications: []sse.Event{sse.NewOrderListUpdatedEvent().ForUser().WithID(f.user.ID)},
+		},
+	}
+	require.NoError(t, f.storage.CreateOrder(f.ctx, params))
+
+	return params
+}
+
+func (f *fixture) changeStatus(t *testing.T, order postgres.OrderParams, status, actor string, at time.Time) {
+	require.NoError(t, f.storage.ChangeOrderStatus(f.ctx, postgres.ChangeOrderStatusParams{
+		OrderID: order.ID,
+		Event:   postgres.OrderEventParams{Status: status, At: at, Actor: actor},
+		Outbox:  f.statusOutbox(order.ID, status),
+	}))
+}
+
+func (f *fixture) startCooking(t *testing.T, order postgres.OrderParams, at time.Time) {
+	items := make([]postgres.ItemForCooking, 0)
+	for _, item := range order.Items {
+		items = append(items, postgres.ItemForCooking{ID: item.ID, Title: item.Title, Quantity: item.Quantity})
+	}
+
+	require.NoError(t, f.storage.StartCooking(f.ctx, postgres.StartCookingParams{
+		OrderID: order.ID,
+		Kitchen: postgres.AddItemsForCookingParams{KitchenID: f.point.KitchenID, OrderID: order.ID, Items: items},
+		Cache: postgres.AddNewOrderForCacheParams{
+			ID:        order.ID,
+			CacheID:   f.point.CacheID,
+			OrderID:   order.ID,
+			UserName:  f.user.Name,
+			Status:    postgres.CacheOrderStatusCooking,
+			CheckList: "Капучино x2",
+		},
+		Event:  postgres.OrderEventParams{Status: postgres.OrderStatusCooking, At: at, Actor: postgres.OrderActorSystem},
+		Outbox: f.statusOutbox(order.ID, postgres.OrderStatusCooking),
+	}))
+}
+
+// cook отмечает приготовленной единственную позицию заказа, и заказ готов.
+func (f *fixture) cook(t *testing.T, order postgres.OrderParams, at time.Time) {
+	event := postgres.OrderEventParams{Status: postgres.OrderStatusReady, At: at, Actor: postgres.OrderActorKitchen}
+	require.NoError(t, f.storage.MarkItemCooked(f.ctx, postgres.MarkItemCookedParams{
+		OrderID:          order.ID,
+		OrderItemID:      order.Items[0].ID,
+		ReadinessPercent: 100,
+		CacheOrderStatus: postgres.CacheOrderStatusReady,
+		At:               at,
+		Event:            &event,
+		Outbox:           f.statusOutbox(order.ID, postgres.OrderStatusReady),
+	}))
+}
+
+func (f *fixture) handOver(t *testing.T, order postgres.OrderParams, at time.Time) {
+	require.NoError(t, f.storage.HandOverOrder(f.ctx, postgres.HandOverOrderParams{
+		OrderID: order.ID,
+		Event:   postgres.OrderEventParams{Status: postgres.OrderStatusReceived, At: at, Actor: postgres.OrderActorCache},
+		Outbox:  f.statusOutbox(order.ID, postgres.OrderStatusReceived),
+	}))
+}
+
+func TestOrderScreens(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, f *fixture) {
+		now := at(time.Now())
+
+		order := f.createOrder(t, now)
+		f.changeStatus(t, order, postgres.OrderStatusPaid, postgres.OrderActorPaymentGateway, now)
+		f.startCooking(t, order, now)
+
+		cookItems, err := f.storage.ListKitchenCookItems(f.ctx, f.point.KitchenID)
+		require.NoError(t, err)
+		require.Len(t, cookItems, 1)
+		require.Equal(t, order.Items[0].ID, cookItems[0].ID)
+		require.Equal(t, order.ID, cookItems[0].OrderID)
+		require.Equal(t, float64(2), cookItems[0].Quantity)
+
+		cacheOrders, err := f.storage.ListCacheOrders(f.ctx, f.point.CacheID)
+		require.NoError(t, err)
+		require.Len(t, cacheOrders, 1)
+		require.Equal(t, postgres.CacheOrderStatusCooking, cacheOrders[0].Status)
+
+		readyAt := now.Add(time.Minute)
+		f.cook(t, order, readyAt)
+		// Повтор активити ничего не меняет.
+		f.cook(t, order, readyAt.Add(time.Minute))
+
+		cookItems, err = f.storage.ListKitchenCookItems(f.ctx, f.point.KitchenID)
+		require.NoError(t, err)
+		require.Empty(t, cookItems)
+
+		cacheOrders, err = f.storage.ListCacheOrders(f.ctx, f.point.CacheID)
+		require.NoError(t, err)
+		require.Len(t, cacheOrders, 1)
+		require.Equal(t, postgres.CacheOrderStatusReady, cacheOrders[0].Status)
+		require.Equal(t, 100, cacheOrders[0].ReadinessPercent)
+
+		handedOverAt := readyAt.Add(time.Minute)
+		f.handOver(t, order, handedOverAt)
+
+		cacheOrders, err = f.storage.ListCacheOrders(f.ctx, f.point.CacheID)
+		require.NoError(t, err)
+		require.Empty(t, cacheOrders)
+
+		cookHistory, err := f.storage.ListKitchenCookItemsHistory(f.ctx, f.point.KitchenID, postgres.HistoryCursor{}, 10)
+		require.NoError(t, err)
+		require.Len(t, cookHistory, 1)
+		require.True(t, readyAt.Equal(cookHistory[0].ReadyAt), "ready at %s, want %s", cookHistory[0].ReadyAt, readyAt)
+
+		cacheHistory, err := f.storage.ListCacheOrdersHistory(f.ctx, f.point.CacheID, postgres.HistoryCursor{}, 10)
+		require.NoError(t, err)
+		require.Len(t, cacheHistory, 1)
+		require.Equal(t, order.ID, cacheHistory[0].OrderID)
+		require.True(t, handedOverAt.Equal(cacheHistory[0].HandedOverAt), "handed over at %s, want %s", cacheHistory[0].HandedOverAt, handedOverAt)
+	})
+}
+
+func TestHistoryCursor(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, f *fixture) {
+		now := at(time.Now())
+		// Все позиции готовы в одно время, страницы различает только id.
+		readyAt := now.Add(time.Minute)
+
+		for i := 0; i < 5; i++ {
+			order := f.createOrder(t, now)
+			f.changeStatus(t, order, postgres.OrderStatusPaid, postgres.OrderActorPaymentGateway, now)
+			f.startCooking(t, order, now)
+			f.cook(t, order, readyAt)
+		}
+		// И одна позже всех, она должна быть первой.
+		last := f.createOrder(t, now)
+		f.changeStatus(t, last, postgres.OrderStatusPaid, postgres.OrderActorPaymentGateway, now)
+		f.startCooking(t, last, now)
+		f.cook(t, last, readyAt.Add(time.Second))
+
+		pages := make([][]*postgres.KitchenCookItemHistoryResponse, 0)
+		cursor := postgres.HistoryCursor{}
+		for {
+			page, err := f.storage.ListKitchenCookItemsHistory(f.ctx, f.point.KitchenID, cursor, 2)
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			require.Less(t, len(pages), 10, "cursor does not move")
+
+			pages = append(pages, page)
+			cursor = postgres.HistoryCursor{At: page[len(page)-1].ReadyAt, ID: page[len(page)-1].ID}
+		}
+		require.Len(t, pages, 3)
+
+		seen := make(map[uuid.UUID]bool)
+		var prev *postgres.KitchenCookItemHistoryResponse
+		for _, page := range pages {
+			for _, item := range page {
+				require.False(t, seen[item.ID], "item %s is on two pages", item.ID)
+				seen[item.ID] = true
+
+				if prev != nil {
+					newer := prev.ReadyAt.After(item.ReadyAt) ||
+						prev.ReadyAt.Equal(item.ReadyAt) && prev.ID.String() > item.ID.String()
+					require.True(t, newer, "item %s goes before %s", item.ID, prev.ID)
+				}
+				prev = item
+			}
+		}
+		require.Len(t, seen, 6)
+		require.Equal(t, last.Items[0].ID, pages[0][0].ID)
+
+		// Курсор без id старых клиентов пропускает всё время целиком.
+		page, err := f.storage.ListKitchenCookItemsHistory(f.ctx, f.point.KitchenID, postgres.HistoryCursor{At: readyAt}, 10)
+		require.NoError(t, err)
+		require.Empty(t, page)
+	})
+}
+
+func TestOutboxParking(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, f *fixture) {
+		now := at(time.Now())
+
+		// Записи заказа broken в поиск не доходят, у ok доставляются.
+		broken := f.createOrder(t, now)
+		ok := f.createOrder(t, now)
+
+		calls := make([][]*postgres.OutboxEntry, 0)
+		deliver := func(_ context.Context, entries []*postgres.OutboxEntry) []error {
+			calls = append(calls, entries)
+
+			errs := make([]error, len(entries))
+			for i, entry := range entries {
+				if entry.OrderID == broken.ID && entry.Kind == postgres.OutboxKindIndexOrder {
+					errs[i] = errors.New("search is down")
+				}
+			}
+			return errs
+		}
+		noBackoff := func(int) time.Duration { return 0 }
+		const maxAttempts = 2
+
+		deliverOnce := func() int {
+			delivered, err := f.storage.DeliverOutbox(f.ctx, 10, maxAttempts, noBackoff, deliver)
+			require.NoError(t, err)
+			return delivered
+		}
+
+		// Первая попытка: головы обоих заказов, документ broken не доставлен.
+		require.Equal(t, 1, deliverOnce())
+		// Вторая попытка последняя для broken, запись откладывается насовсем,
+		// а у ok доходит уведомление.
+		require.Equal(t, 1, deliverOnce())
+		// Отложенная запись больше не держит уведомление broken.
+		require.Equal(t, 1, deliverOnce())
+		require.Equal(t, 0, deliverOnce())
+
+		require.Len(t, calls, 3)
+		for _, entries := range calls {
+			orders := make(map[uuid.UUID]bool)
+			for _, entry := range entries {
+				require.False(t, orders[entry.OrderID], "two entries of order %s in one batch", entry.OrderID)
+				orders[entry.OrderID] = true
+			}
+		}
+
+		kinds := func(call int, orderID uuid.UUID) []string {
+			res := make([]string, 0)
+			for _, entry := range calls[call] {
+				if entry.OrderID == orderID {
+					res = append(res, entry.Kind)
+				}
+			}
+			return res
+		}
+		require.Equal(t, []string{postgres.OutboxKindIndexOrder}, kinds(0, broken.ID))
+		require.Equal(t, []string{postgres.OutboxKindIndexOrder}, kinds(0, ok.ID))
+		require.Equal(t, []string{postgres.OutboxKindIndexOrder}, kinds(1, broken.ID))
+		require.Equal(t, []string{postgres.OutboxKindNotification}, kinds(1, ok.ID))
+		require.Equal(t, []string{postgres.OutboxKindNotification}, kinds(2, broken.ID))
+		require.Empty(t, kinds(2, ok.ID))
+	})
+}
+
+func TestZReport(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, f *fixture) {
+		now := at(time.Now())
+		paid := func(order postgres.OrderParams) {
+			f.changeStatus(t, order, postgres.OrderStatusPaid, postgres.OrderActorPaymentGateway, now)
+		}
+
+		received := f.createOrder(t, now)
+		paid(received)
+		f.startCooking(t, received, now)
+		f.cook(t, received, now.Add(time.Minute))
+		f.handOver(t, received, now.Add(2*time.Minute))
+
+		abandoned := f.createOrder(t, now)
+		paid(abandoned)
+		f.startCooking(t, abandoned, now)
+		f.cook(t, abandoned, now.Add(3*time.Minute))
+
+		canceled := f.createOrder(t, now)
+		f.changeStatus(t, canceled, postgres.OrderStatusPaymentCanceled, postgres.OrderActorPaymentGateway, now)
+
+		timedOut := f.createOrder(t, now)
+		f.changeStatus(t, timedOut, postgres.OrderStatusPaymentTimeout, postgres.OrderActorSystem, now)
+
+		// Заказ за пределами дня в отчёт не попадает.
+		f.createOrder(t, now.Add(-48*time.Hour))
+
+		params := postgres.CreateZReportParams{
+			PointID:  f.point.ID,
+			Day:      now.Format("2006-01-02"),
+			StartsAt: now.Add(-time.Hour),
+			EndsAt:   now.Add(time.Hour),
+		}
+		report, err := f.storage.CreateZReport(f.ctx, params)
+		require.NoError(t, err)
+
+		require.Equal(t, int64(4), report.OrdersCount)
+		require.Equal(t, received.TotalPrice+abandoned.TotalPrice, report.Revenue)
+		require.Equal(t, int64(1), report.CanceledOrders)
+		require.Equal(t, int64(1), report.PaymentTimeouts)
+		require.Equal(t, int64(1), report.AbandonedOrders)
+		// Одна готовка минуту, другая три.
+		require.Equal(t, int64(120), report.AvgCookSeconds)
+		require.Equal(t, "RUB", report.Currency)
+
+		// Пересчёт того же дня заменяет отчёт, а не добавляет второй.
+		f.handOver(t, abandoned, now.Add(4*time.Minute))
+		_, err = f.storage.CreateZReport(f.ctx, params)
+		require.NoError(t, err)
+
+		reports, err := f.storage.ListZReports(f.ctx, f.point.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		require.Equal(t, int64(0), reports[0].AbandonedOrders)
+
+		stored, err := f.storage.GetZReport(f.ctx, f.point.ID, params.Day)
+		require.NoError(t, err)
+		require.Equal(t, reports[0].Revenue, stored.Revenue)
+	})
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/money"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+type zReportKey struct {
+	pointID uuid.UUID
+	day     string
+}
+
+func zReportResponse(report *postgres.ZReport) *postgres.ZReportResponse {
+	return &postgres.ZReportResponse{
+		PointID:         report.PointID,
+		Day:             report.Day,
+		StartsAt:        report.StartsAt,
+		EndsAt:          report.EndsAt,
+		Currency:        report.Currency,
+		OrdersCount:     report.OrdersCount,
+		Revenue:         report.Revenue,
+		CanceledOrders:  report.CanceledOrders,
+		PaymentTimeouts: report.PaymentTimeouts,
+		AbandonedOrders: report.AbandonedOrders,
+		AvgCookSeconds:  report.AvgCookSeconds,
+		CreatedAt:       report.CreatedAt,
+	}
+}
+
+// CreateZReport считает то же, что запрос в postgres.Postgres.CreateZReport.
+func (s *Storage) CreateZReport(_ context.Context, params postgres.CreateZReportParams) (*postgres.ZReportResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := zReportKey{pointID: params.PointID, day: params.Day}
+
+	report, ok := s.zReports[key]
+	if !ok {
+		report = &postgres.ZReport{
+			ID:        uuid.New(),
+			CreatedAt: time.Now(),
+			PointID:   params.PointID,
+			Day:       params.Day,
+		}
+		s.zReports[key] = report
+	}
+
+	*report = postgres.ZReport{
+		ID:        report.ID,
+		CreatedAt: report.CreatedAt,
+		PointID:   params.PointID,
+		Day:       params.Day,
+		StartsAt:  params.StartsAt,
+		EndsAt:    params.EndsAt,
+		Currency:  money.DefaultCurrency,
+	}
+
+	var cookSeconds float64
+	var cooked int64
+
+	for _, order := range s.orders {
+		if order.PointID != params.PointID ||
+			order.CreatedAt.Before(params.StartsAt) || !order.CreatedAt.Before(params.EndsAt) {
+			continue
+		}
+
+		report.Currency = order.Currency
+		report.OrdersCount++
+
+		switch order.Status {
+		case "paid", "cooking", "ready", "received":
+			report.Revenue += order.TotalPrice
+		case "payment_canceled":
+			report.CanceledOrders++
+		case "payment_timeout":
+			report.PaymentTimeouts++
+		}
+		if order.Status == "ready" {
+			report.AbandonedOrders++
+		}
+
+		var cooking, ready *postgres.OrderEvent
+		for _, event := range order.Events {
+			switch event.Status {
+			case "cooking":
+				cooking = event
+			case "ready":
+				ready = event
+			}
+		}
+		if cooking != nil && ready != nil {
+			cookSeconds += ready.At.Sub(cooking.At).Seconds()
+			cooked++
+		}
+	}
+
+	if cooked > 0 {
+		report.AvgCookSeconds = int64(cookSeconds / float64(cooked))
+	}
+
+	return zReportResponse(report), nil
+}
+
+func (s *Storage) GetZReport(_ context.Context, pointID uuid.UUID, day string) (*postgres.ZReportResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, ok := s.zReports[zReportKey{pointID: pointID, day: day}]
+	if !ok {
+		return nil, notFound("z-report", day)
+	}
+	return zReportResponse(report), nil
+}
+
+func (s *Storage) ListZReports(_ context.Context, pointID uuid.UUID, limit int) ([]*postgres.ZReportResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]*postgres.ZReport, 0)
+	for _, report := range s.zReports {
+		if report.PointID == pointID {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Day > reports[j].Day })
+	if len(reports) > limit {
+		reports = reports[:limit]
+	}
+
+	res := make([]*postgres.ZReportResponse, 0)
+	for _, report := range reports {
+		res = append(res, zReportResponse(report))
+	}
+
+	return res, nil
+}
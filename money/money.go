@@ -0,0 +1,52 @@
+package money
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultCurrency валюта, в которой заведено меню по умолчанию.
+const DefaultCurrency = "RUB"
+
+// Все суммы в системе хранятся в минимальных единицах валюты (для рубля это
+// копейки) как int64, что бы не накапливать ошибку округления float64.
+
+// LineTotal считает стоимость позиции заказа, округляя до целой копейки.
+func LineTotal(price int64, quantity float64) int64 {
+	return int64(math.Round(float64(price) * quantity))
+}
+
+// FromMajor переводит сумму в основных единицах (рублях) в минимальные.
+// Нужен только для миграции старых данных и сидов.
+func FromMajor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// Format форматирует сумму в минимальных единицах для отображения на клиентах.
+func Format(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d%s", sign, amount/100, amount%100, symbol(currency))
+}
+
+func symbol(currency string) string {
+	switch currency {
+	case "", DefaultCurrency:
+		return "₽"
+	default:
+		return " " + currency
+	}
+}
+
+// IncludedVAT выделяет НДС, который уже входит в сумму (цены в меню указаны с
+// НДС), по ставке в процентах. Округляется до целой копейки.
+func IncludedVAT(amount int64, ratePercent int) int64 {
+	if ratePercent <= 0 {
+		return 0
+	}
+	return int64(math.Round(float64(amount) * float64(ratePercent) / float64(100+ratePercent)))
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/postgres"
+	"github.com/krocos/coffee-shop/sse"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = 200 * time.Millisecond
+	defaultMaxBackoff   = time.Minute
+	defaultMaxAttempts  = 100
+)
+
+type Config struct {
+	// BatchSize сколько заказов разбирать за раз.
+	BatchSize int
+	// PollInterval как часто заглядывать в outbox, когда он пуст.
+	PollInterval time.Duration
+	// MaxBackoff предел паузы между попытками доставить одну запись.
+	MaxBackoff time.Duration
+	// MaxAttempts после стольких неудачных попыток запись откладывается
+	// насовсем, что бы не держать остальные записи заказа.
+	MaxAttempts int
+}
+
+// Storage откуда релей берёт записи: postgres.Postgres или memory.Storage.
+type Storage interface {
+	DeliverOutbox(ctx context.Context, limit, maxAttempts int, backoff func(attempts int) time.Duration, deliver func(ctx context.Context, entries []*postgres.OutboxEntry) []error) (int, error)
+}
+
+// Search куда релей пишет документы заказов.
+type Search interface {
+	IndexOrder(ctx context.Context, id uuid.UUID, doc *elasticsearch.Order, refresh bool) error
+	UpdateOrder(ctx context.Context, id uuid.UUID, version int64, partialDoc *elasticsearch.Order, refresh bool) error
+}
+
+// Notifier куда релей шлёт уведомления клиентам: sse.SSE ходит в сервер
+// уведомлений по http, sse.Server публикует сам.
+type Notifier interface {
+	SendNotification(ctx context.Context, event sse.Event) error
+}
+
+// Relay доставляет записи outbox в поиск и сервер уведомлений. Записи одного
+// заказа уходят строго по очереди, разные заказы доставляются параллельно.
+type Relay struct {
+	storage  Storage
+	search   Search
+	notifier Notifier
+	config   Config
+}
+
+func NewRelay(storage Storage, search Search, notifier Notifier, config Config) *Relay {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+
+	return &Relay{
+		storage:  storage,
+		search:   search,
+		notifier: notifier,
+		config:   config,
+	}
+}
+
+// Run разбирает outbox, пока не отменят ctx.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		delivered, err := r.storage.DeliverOutbox(ctx, r.config.BatchSize, r.config.MaxAttempts, r.backoff, r.deliverAll)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("outbox: %v", err)
+		}
+
+		// Пока есть что доставлять, не ждём.
+		if err == nil && delivered > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.config.PollInterval):
+		}
+	}
+}
+
+// backoff 1с, 2с, 4с и так далее до MaxBackoff.
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < r.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.config.MaxBackoff {
+		d = r.config.MaxBackoff
+	}
+	return d
+}
+
+// deliverAll доставляет записи разных заказов параллельно. Записи поиска при
+// этом собираются в общие bulk-запросы.
+func (r *Relay) deliverAll(ctx context.Context, entries []*postgres.OutboxEntry) []error {
+	errs := make([]error, len(entries))
+
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry *postgres.OutboxEntry) {
+			defer wg.Done()
+
+			if errs[i] = r.deliver(ctx, entry); errs[i] != nil {
+				log.Printf("outbox: deliver %s entry %d of order %s, attempt %d: %v",
+					entry.Kind, entry.ID, entry.OrderID, entry.Attempts+1, errs[i])
+				if entry.Attempts+1 >= r.config.MaxAttempts {
+					log.Printf("outbox: park %s entry %d of order %s after %d attempts",
+						entry.Kind, entry.ID, entry.OrderID, entry.Attempts+1)
+				}
+			}
+		}(i, entry)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func (r *Relay) deliver(ctx context.Context, entry *postgres.OutboxEntry) error {
+	switch entry.Kind {
+	case postgres.OutboxKindIndexOrder:
+		doc := new(elasticsearch.Order)
+		if err := json.Unmarshal([]byte(entry.Payload), doc); err != nil {
+			return fmt.Errorf("unmarshal payload: %v", err)
+		}
+		return r.search.IndexOrder(ctx, entry.OrderID, doc, true)
+
+	case postgres.OutboxKindUpdateOrder:
+		update := new(postgres.OutboxOrderUpdate)
+		if err := json.Unmarshal([]byte(entry.Payload), update); err != nil {
+			return fmt.Errorf("unmarshal payload: %v", err)
+		}
+		return r.search.UpdateOrder(ctx, entry.OrderID, update.Version, update.Doc, true)
+
+	case postgres.OutboxKindNotification:
+		var event sse.Event
+		if err := json.Unmarshal([]byte(entry.Payload), &event); err != nil {
+			return fmt.Errorf("unmarshal payload: %v", err)
+		}
+		return r.notifier.SendNotification(ctx, event)
+	}
+
+	return fmt.Errorf("unknown outbox entry kind %q", entry.Kind)
+}
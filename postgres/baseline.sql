@@ -0,0 +1,115 @@
+-- Доводит базу, созданную через AutoMigrate, до схемы 0001_init. Годится для
+-- базы любой версии до миграций: от самой первой, где деньги в рублях во float
+-- и нет половины таблиц, до последней перед миграциями. Всё, что уже есть,
+-- пропускается, поэтому скрипт можно запускать повторно.
+
+-- Деньги из рублей во float в копейки.
+DO $$
+DECLARE
+    c record;
+BEGIN
+    FOR c IN
+        SELECT table_name, column_name FROM information_schema.columns
+        WHERE table_schema = current_schema()
+            AND (table_name, column_name) IN (
+                ('items', 'price'),
+                ('orders', 'total_price'),
+                ('order_items', 'price'),
+                ('order_items', 'total_price')
+            )
+            AND data_type IN ('double precision', 'real', 'numeric')
+    LOOP
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE bigint USING round(%I * 100)::bigint',
+            c.table_name, c.column_name, c.column_name);
+    END LOOP;
+END $$;
+
+ALTER TABLE items ADD COLUMN IF NOT EXISTS currency varchar(3) DEFAULT 'RUB';
+ALTER TABLE items ADD COLUMN IF NOT EXISTS vat_rate bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE points ADD COLUMN IF NOT EXISTS timezone varchar(64) DEFAULT 'Asia/Yekaterinburg';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency varchar(3) DEFAULT 'RUB';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS vat_amount bigint NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_card_amount bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS vat_rate bigint NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS vat_amount bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE log_items ADD COLUMN IF NOT EXISTS created_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS order_events (
+    id       uuid PRIMARY KEY,
+    order_id uuid,
+    status   varchar(255),
+    at       timestamptz,
+    actor    varchar(255),
+    payload  jsonb NOT NULL DEFAULT '{}',
+    CONSTRAINT fk_orders_events FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS order_event_status_uniq_idx ON order_events (order_id, status);
+
+CREATE TABLE IF NOT EXISTS receipts (
+    id            uuid PRIMARY KEY,
+    created_at    timestamptz,
+    issued_at     timestamptz,
+    fiscal_number varchar(255),
+    document      jsonb
+);
+
+CREATE TABLE IF NOT EXISTS gift_cards (
+    id              uuid PRIMARY KEY,
+    created_at      timestamptz,
+    code            varchar(32),
+    initial_balance bigint,
+    balance         bigint,
+    reserved        bigint,
+    currency        varchar(3) DEFAULT 'RUB',
+    expires_at      timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS gift_card_code_uniq_idx ON gift_cards (code);
+
+CREATE TABLE IF NOT EXISTS gift_card_ledger_entries (
+    id           uuid PRIMARY KEY,
+    created_at   timestamptz,
+    gift_card_id uuid,
+    order_id     uuid,
+    kind         varchar(255),
+    amount       bigint
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS gift_card_movement_uniq_idx ON gift_card_ledger_entries (gift_card_id, order_id, kind);
+
+CREATE TABLE IF NOT EXISTS z_reports (
+    id               uuid PRIMARY KEY,
+    created_at       timestamptz,
+    point_id         uuid,
+    day              varchar(10),
+    starts_at        timestamptz,
+    ends_at          timestamptz,
+    currency         varchar(3) DEFAULT 'RUB',
+    orders_count     bigint,
+    revenue          bigint,
+    refunds_count    bigint,
+    refunds_amount   bigint,
+    payment_timeouts bigint,
+    abandoned_orders bigint,
+    avg_cook_seconds bigint
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS z_report_point_day_uniq_idx ON z_reports (point_id, day);
+
+CREATE TABLE IF NOT EXISTS outbox_entries (
+    id              bigserial PRIMARY KEY,
+    created_at      timestamptz,
+    order_id        uuid,
+    kind            varchar(255),
+    payload         jsonb,
+    attempts        bigint NOT NULL DEFAULT 0,
+    last_error      text,
+    next_attempt_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS outbox_entry_order_idx ON outbox_entries (order_id);
@@ -0,0 +1,257 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const giftCardCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewGiftCardCode случайный код карты без похожих друг на друга символов.
+func NewGiftCardCode() (string, error) {
+	code := make([]byte, 16)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = giftCardCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+type IssueGiftCardParams struct {
+	InitialBalance int64
+	Currency       string
+	ExpiresAt      time.Time
+}
+
+type GiftCardResponse struct {
+	Code           string
+	InitialBalance int64
+	Balance        int64
+	Available      int64
+	Currency       string
+	ExpiresAt      time.Time
+}
+
+func newGiftCardResponse(card *GiftCard) *GiftCardResponse {
+	return &GiftCardResponse{
+		Code:           card.Code,
+		InitialBalance: card.InitialBalance,
+		Balance:        card.Balance,
+		Available:      card.Balance - card.Reserved,
+		Currency:       card.Currency,
+		ExpiresAt:      card.ExpiresAt,
+	}
+}
+
+func (p *Postgres) IssueGiftCard(ctx context.Context, params IssueGiftCardParams) (*GiftCardResponse, error) {
+	code, err := NewGiftCardCode()
+	if err != nil {
+		return nil, fmt.Errorf("make gift card code: %v", err)
+	}
+
+	card := &GiftCard{
+		Code:           code,
+		InitialBalance: params.InitialBalance,
+		Balance:        params.InitialBalance,
+		Currency:       params.Currency,
+		ExpiresAt:      params.ExpiresAt,
+	}
+
+	if err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(card).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&GiftCardLedgerEntry{
+			GiftCardID: card.ID,
+			Kind:       GiftCardMovementIssue,
+			Amount:     card.InitialBalance,
+		}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	return newGiftCardResponse(card), nil
+}
+
+func (p *Postgres) GetGiftCardBalance(ctx context.Context, code string) (*GiftCardResponse, error) {
+	card := new(GiftCard)
+	if err := p.db.WithContext(ctx).Where("code = ?", code).Take(card).Error; err != nil {
+		return nil, err
+	}
+
+	return newGiftCardResponse(card), nil
+}
+
+type ReserveGiftCardParams struct {
+	Code     string
+	OrderID  uuid.UUID
+	Amount   int64
+	Currency string
+	At       time.Time
+}
+
+// ReserveGiftCardResult сколько удалось зарезервировать на карте. Если карта не
+// подходит для оплаты, то Amount нулевой, а в Reason причина для пользователя.
+type ReserveGiftCardResult struct {
+	Amount int64
+	Reason string
+}
+
+// ReserveGiftCard резервирует на карте сумму заказа или сколько есть, если на
+// карте меньше. Повторный вызов для того же заказа вернёт прежний резерв.
+func (p *Postgres) ReserveGiftCard(ctx context.Context, params ReserveGiftCardParams) (ReserveGiftCardResult, error) {
+	var result ReserveGiftCardResult
+
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		card := new(GiftCard)
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code = ?", params.Code).
+			Take(card).Error; err != nil {
+
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				result.Reason = "подарочная карта не найдена"
+				return nil
+			}
+			return err
+		}
+
+		reserved, err := findGiftCardMovement(tx, card.ID, params.OrderID, GiftCardMovementReserve)
+		if err != nil {
+			return err
+		}
+		if reserved != nil {
+			result.Amount = reserved.Amount
+			return nil
+		}
+
+		available := card.Balance - card.Reserved
+
+		switch {
+		case !params.At.Before(card.ExpiresAt):
+			result.Reason = "срок действия подарочной карты истёк"
+			return nil
+		case card.Currency != params.Currency:
+			result.Reason = fmt.Sprintf("подарочная карта в валюте %s", card.Currency)
+			return nil
+		case available <= 0:
+			result.Reason = "на подарочной карте нет средств"
+			return nil
+		}
+
+		result.Amount = params.Amount
+		if available < result.Amount {
+			result.Amount = available
+		}
+
+		if err = tx.Model(card).Update("reserved", gorm.Expr("reserved + ?", result.Amount)).Error; err != nil {
+			return err
+		}
+
+		if err = tx.Model(Order{}).
+			Where("id = ?", params.OrderID).
+			Update("gift_card_amount", result.Amount).Error; err != nil {
+
+			return err
+		}
+
+		return tx.Create(&GiftCardLedgerEntry{
+			GiftCardID: card.ID,
+			OrderID:    &params.OrderID,
+			Kind:       GiftCardMovementReserve,
+			Amount:     result.Amount,
+		}).Error
+	})
+	if err != nil {
+		return ReserveGiftCardResult{}, fmt.Errorf("reserve gift card for order %s: %v", params.OrderID, err)
+	}
+
+	return result, nil
+}
+
+// FinalizeGiftCardRedemption списывает зарезервированную под заказ сумму с карты.
+func (p *Postgres) FinalizeGiftCardRedemption(ctx context.Context, orderID uuid.UUID) error {
+	return p.settleGiftCardReservation(ctx, orderID, GiftCardMovementRedeem)
+}
+
+// ReleaseGiftCardReservation возвращает зарезервированную под заказ сумму на карту.
+func (p *Postgres) ReleaseGiftCardReservation(ctx context.Context, orderID uuid.UUID) error {
+	return p.settleGiftCardReservation(ctx, orderID, GiftCardMovementRelease)
+}
+
+func (p *Postgres) settleGiftCardReservation(ctx context.Context, orderID uuid.UUID, kind string) error {
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		reserved := new(GiftCardLedgerEntry)
+		if err := tx.Where("order_id = ? AND kind = ?", orderID, GiftCardMovementReserve).
+			Take(reserved).Error; err != nil {
+
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		card := new(GiftCard)
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(card, reserved.GiftCardID).Error; err != nil {
+			return err
+		}
+
+		// Резерв закрывается только один раз: либо списанием, либо возвратом.
+		for _, settled := range []string{GiftCardMovementRedeem, GiftCardMovementRelease} {
+			entry, err := findGiftCardMovement(tx, card.ID, orderID, settled)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				return nil
+			}
+		}
+
+		updates := map[string]interface{}{
+			"reserved": gorm.Expr("reserved - ?", reserved.Amount),
+		}
+		if kind == GiftCardMovementRedeem {
+			updates["balance"] = gorm.Expr("balance - ?", reserved.Amount)
+		}
+
+		if err := tx.Model(card).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&GiftCardLedgerEntry{
+			GiftCardID: card.ID,
+			OrderID:    &orderID,
+			Kind:       kind,
+			Amount:     reserved.Amount,
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("%s gift card reservation for order %s: %v", kind, orderID, err)
+	}
+
+	return nil
+}
+
+func findGiftCardMovement(tx *gorm.DB, giftCardID, orderID uuid.UUID, kind string) (*GiftCardLedgerEntry, error) {
+	entry := new(GiftCardLedgerEntry)
+	if err := tx.Where("gift_card_id = ? AND order_id = ? AND kind = ?", giftCardID, orderID, kind).
+		Take(entry).Error; err != nil {
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return entry, nil
+}
@@ -2,7 +2,15 @@ package postgres
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	gormpostgres "gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -13,13 +21,89 @@ type GormConfig struct {
 	Database string
 	Username string
 	Password string
+	// SSLMode как в libpq: disable, allow, prefer, require, verify-ca,
+	// verify-full. Пустой значит prefer.
+	SSLMode string
+	// ApplicationName видно в pg_stat_activity, пустой значит имя бинарника.
+	ApplicationName string
+	// StatementTimeout предел времени одного запроса, 0 без предела.
+	StatementTimeout time.Duration
+	// Настройки пула, 0 значит как в database/sql по умолчанию.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ReplicaDSNs строки подключения к репликам для чтения. Адрес, логин,
+	// пароль и sslmode берутся из самой строки, остальное из этого конфига.
+	ReplicaDSNs []string
 }
 
+// NewGorm подключение к основной базе, все записи идут через него.
 func NewGorm(config GormConfig) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s",
-		config.Host, config.Username, config.Password, config.Database, config.Port)
-	db, err := gorm.Open(gormpostgres.Open(dsn))
+	query := url.Values{}
+	if config.SSLMode != "" {
+		query.Set("sslmode", config.SSLMode)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.Username, config.Password),
+		Host:     net.JoinHostPort(config.Host, config.Port),
+		Path:     "/" + config.Database,
+		RawQuery: query.Encode(),
+	}
+
+	db, err := openGorm(dsn.String(), config)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %v", dsn.Redacted(), err)
+	}
+	return db, nil
+}
+
+// NewGormReplicas подключения к репликам из ReplicaDSNs, пустой список, если
+// реплик нет.
+func NewGormReplicas(config GormConfig) ([]*gorm.DB, error) {
+	replicas := make([]*gorm.DB, 0, len(config.ReplicaDSNs))
+	for i, dsn := range config.ReplicaDSNs {
+		db, err := openGorm(dsn, config)
+		if err != nil {
+			return nil, fmt.Errorf("connect to replica %d: %v", i+1, err)
+		}
+		replicas = append(replicas, db)
+	}
+	return replicas, nil
+}
+
+func openGorm(dsn string, config GormConfig) (*gorm.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Что задано в самой строке подключения, то главнее.
+	if _, ok := connConfig.RuntimeParams["application_name"]; !ok {
+		name := config.ApplicationName
+		if name == "" {
+			name = filepath.Base(os.Args[0])
+		}
+		connConfig.RuntimeParams["application_name"] = name
+	}
+	if _, ok := connConfig.RuntimeParams["statement_timeout"]; !ok && config.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(config.StatementTimeout.Milliseconds(), 10)
+	}
+
+	sqlDB := stdlib.OpenDB(*connConfig)
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		// 0 у database/sql значит совсем без простаивающих соединений.
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	db, err := gorm.Open(gormpostgres.New(gormpostgres.Config{Conn: sqlDB}))
 	if err != nil {
+		_ = sqlDB.Close()
 		return nil, err
 	}
 	return db, nil
@@ -0,0 +1,348 @@
+package postgres_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/postgres"
+	"github.com/krocos/coffee-shop/postgres/pgtest"
+	"github.com/krocos/coffee-shop/sse"
+)
+
+// IdempotencyTestSuite вызывает каждую активити хранилища дважды с теми же
+// параметрами, как это сделает Temporal, если ответ первого вызова потерялся.
+// Второй вызов не должен падать и не должен ничего менять.
+type IdempotencyTestSuite struct {
+	suite.Suite
+
+	ctx     context.Context
+	db      *gorm.DB
+	storage *postgres.Postgres
+
+	user  *postgres.User
+	item  *postgres.Item
+	point *postgres.Point
+}
+
+func TestIdempotency(t *testing.T) {
+	pgtest.DSN(t)
+	suite.Run(t, new(IdempotencyTestSuite))
+}
+
+func (s *IdempotencyTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+	s.db = pgtest.NewDB(s.T())
+	s.storage = postgres.NewPostgres(s.db)
+
+	s.user = &postgres.User{Name: "Тест"}
+	s.item = &postgres.Item{Title: "Капучино", Price: 25000, Currency: "RUB", VATRate: 20}
+	s.point = &postgres.Point{Addr: "Тестовая, 1"}
+	s.Require().NoError(s.db.Create(s.user).Error)
+	s.Require().NoError(s.db.Create(s.item).Error)
+	s.Require().NoError(s.db.Create(s.point).Error)
+}
+
+// twice вызывает активити два раза и проверяет, что оба вызова прошли.
+func (s *IdempotencyTestSuite) twice(call func() error) {
+	s.Require().NoError(call(), "first call")
+	s.Require().NoError(call(), "second call")
+}
+
+func (s *IdempotencyTestSuite) count(model interface{}, query string, args ...interface{}) int64 {
+	var n int64
+	s.Require().NoError(s.db.Model(model).Where(query, args...).Count(&n).Error)
+	return n
+}
+
+func (s *IdempotencyTestSuite) outboxCount(orderID uuid.UUID) int64 {
+	return s.count(&postgres.OutboxEntry{}, "order_id = ?", orderID)
+}
+
+func (s *IdempotencyTestSuite) statusOutbox(version int64, status string) postgres.Outbox {
+	return postgres.Outbox{
+		UpdateOrder: &postgres.OutboxOrderUpdate{
+			Version: version,
+			Doc:     &elasticsearch.Order{Status: status},
+		},
+		Notifications: []sse.Event{sse.NewOrderListUpdatedEvent().ForUser().WithID(s.user.ID)},
+	}
+}
+
+func (s *IdempotencyTestSuite) orderParams() postgres.OrderParams {
+	orderID := uuid.New()
+	return postgres.OrderParams{
+		ID:         orderID,
+		CreatedAt:  time.Now(),
+		Status:     postgres.OrderStatusWaitingForPayment,
+		TotalPrice: 50000,
+		Currency:   "RUB",
+		VATAmount:  8333,
+		PINCode:    "1234",
+		UserID:     s.user.ID,
+		PointID:    s.point.ID,
+		Items: []postgres.OrderItemParams{{
+			ID:         uuid.New(),
+			Title:      s.item.Title,
+			Price:      s.item.Price,
+			ItemID:     s.item.ID,
+			Quantity:   2,
+			TotalPrice: 50000,
+			VATRate:    20,
+			VATAmount:  8333,
+		}},
+		Event: postgres.OrderEventParams{Status: postgres.OrderStatusWaitingForPayment, At: time.Now(), Actor: postgres.OrderActorUser},
+		Outbox: postgres.Outbox{
+			IndexOrder:    &elasticsearch.Order{ID: orderID.String(), Version: 1, Status: postgres.OrderStatusWaitingForPayment},
+			Notifications: []sse.Event{sse.NewOrderListUpdatedEvent().ForUser().WithID(s.user.ID)},
+		},
+	}
+}
+
+// createOrder создаёт заказ и доводит его до статуса paid.
+func (s *IdempotencyTestSuite) createOrder() postgres.OrderParams {
+	params := s.orderParams()
+	s.Require().NoError(s.storage.CreateOrder(s.ctx, params))
+	s.Require().NoError(s.storage.ChangeOrderStatus(s.ctx, postgres.ChangeOrderStatusParams{
+		OrderID: params.ID,
+		Event:   postgres.OrderEventParams{Status: postgres.OrderStatusPaid, At: time.Now(), Actor: postgres.OrderActorPaymentGateway},
+		Outbox:  s.statusOutbox(2, postgres.OrderStatusPaid),
+	}))
+	return params
+}
+
+func (s *IdempotencyTestSuite) startCookingParams(order postgres.OrderParams) postgres.StartCookingParams {
+	items := make([]postgres.ItemForCooking, 0)
+	for _, item := range order.Items {
+		items = append(items, postgres.ItemForCooking{ID: item.ID, Title: item.Title, Quantity: item.Quantity})
+	}
+
+	return postgres.StartCookingParams{
+		OrderID: order.ID,
+		Kitchen: postgres.AddItemsForCookingParams{KitchenID: s.point.KitchenID, OrderID: order.ID, Items: items},
+		Cache: postgres.AddNewOrderForCacheParams{
+			ID:        order.ID,
+			CacheID:   s.point.CacheID,
+			OrderID:   order.ID,
+			UserName:  s.user.Name,
+			Status:    postgres.CacheOrderStatusCooking,
+			CheckList: "Капучино x2",
+		},
+		Event:  postgres.OrderEventParams{Status: postgres.OrderStatusCooking, At: time.Now(), Actor: postgres.OrderActorSystem},
+		Outbox: s.statusOutbox(3, postgres.OrderStatusCooking),
+	}
+}
+
+func (s *IdempotencyTestSuite) TestCreateOrder() {
+	params := s.orderParams()
+
+	s.twice(func() error { return s.storage.CreateOrder(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.Order{}, "id = ?", params.ID))
+	s.Equal(int64(1), s.count(&postgres.OrderItem{}, "order_id = ?", params.ID))
+	s.Equal(int64(1), s.count(&postgres.OrderEvent{}, "order_id = ?", params.ID))
+	s.Equal(int64(2), s.outboxCount(params.ID))
+}
+
+func (s *IdempotencyTestSuite) TestChangeOrderStatus() {
+	order := s.createOrder()
+	before := s.outboxCount(order.ID)
+
+	params := postgres.ChangeOrderStatusParams{
+		OrderID: order.ID,
+		Event:   postgres.OrderEventParams{Status: postgres.OrderStatusPaymentCanceled, At: time.Now(), Actor: postgres.OrderActorPaymentGateway},
+		Outbox:  s.statusOutbox(3, postgres.OrderStatusPaymentCanceled),
+	}
+	s.twice(func() error { return s.storage.ChangeOrderStatus(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.OrderEvent{}, "order_id = ? AND status = ?", order.ID, postgres.OrderStatusPaymentCanceled))
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestUpdateOrderStatus() {
+	order := s.createOrder()
+
+	s.twice(func() error { return s.storage.UpdateOrderStatus(s.ctx, order.ID, postgres.OrderStatusPaymentTimeout) })
+
+	s.Equal(int64(1), s.count(&postgres.Order{}, "id = ? AND status = ?", order.ID, postgres.OrderStatusPaymentTimeout))
+}
+
+func (s *IdempotencyTestSuite) TestLogUnsuccessfulPayment() {
+	order := s.createOrder()
+	before := s.outboxCount(order.ID)
+
+	params := postgres.LogUnsuccessfulPaymentParams{
+		ID:      uuid.New(),
+		OrderID: order.ID,
+		Reason:  "недостаточно средств",
+		Outbox:  s.statusOutbox(3, postgres.OrderStatusPaid),
+	}
+	s.twice(func() error { return s.storage.LogUnsuccessfulPayment(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.LogItem{}, "order_id = ?", order.ID))
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestLogAttemptToEnterWrongPINCode() {
+	order := s.createOrder()
+	before := s.outboxCount(order.ID)
+
+	params := postgres.LogAttemptToEnterWrongPINCodeParams{
+		ID:      uuid.New(),
+		Reason:  "неверный пин-код",
+		OrderID: order.ID,
+		Outbox:  s.statusOutbox(3, postgres.OrderStatusPaid),
+	}
+	s.twice(func() error { return s.storage.LogAttemptToEnterWrongPINCode(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.LogItem{}, "order_id = ?", order.ID))
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestPublishOrderChanges() {
+	order := s.createOrder()
+	before := s.outboxCount(order.ID)
+
+	outbox := s.statusOutbox(3, postgres.OrderStatusPaid)
+	s.twice(func() error { return s.storage.PublishOrderChanges(s.ctx, order.ID, outbox) })
+
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestStartCooking() {
+	order := s.createOrder()
+	before := s.outboxCount(order.ID)
+
+	params := s.startCookingParams(order)
+	s.twice(func() error { return s.storage.StartCooking(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.CookItem{}, "order_id = ?", order.ID))
+	s.Equal(int64(1), s.count(&postgres.CacheOrder{}, "order_id = ?", order.ID))
+	s.Equal(int64(1), s.count(&postgres.OrderEvent{}, "order_id = ? AND status = ?", order.ID, postgres.OrderStatusCooking))
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestMarkItemCooked() {
+	order := s.createOrder()
+	s.Require().NoError(s.storage.StartCooking(s.ctx, s.startCookingParams(order)))
+	before := s.outboxCount(order.ID)
+
+	event := postgres.OrderEventParams{Status: postgres.OrderStatusReady, At: time.Now(), Actor: postgres.OrderActorKitchen}
+	params := postgres.MarkItemCookedParams{
+		OrderID:          order.ID,
+		OrderItemID:      order.Items[0].ID,
+		ReadinessPercent: 100,
+		CacheOrderStatus: postgres.CacheOrderStatusReady,
+		At:               time.Now(),
+		Event:            &event,
+		Outbox:           s.statusOutbox(4, postgres.OrderStatusReady),
+	}
+	s.twice(func() error { return s.storage.MarkItemCooked(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.CookItem{}, "order_id = ? AND ready_at IS NOT NULL", order.ID))
+	s.Equal(int64(1), s.count(&postgres.OrderEvent{}, "order_id = ? AND status = ?", order.ID, postgres.OrderStatusReady))
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestHandOverOrder() {
+	order := s.createOrder()
+	s.Require().NoError(s.storage.StartCooking(s.ctx, s.startCookingParams(order)))
+	before := s.outboxCount(order.ID)
+
+	params := postgres.HandOverOrderParams{
+		OrderID: order.ID,
+		Event:   postgres.OrderEventParams{Status: postgres.OrderStatusReceived, At: time.Now(), Actor: postgres.OrderActorCache},
+		Outbox:  s.statusOutbox(4, postgres.OrderStatusReceived),
+	}
+	s.twice(func() error { return s.storage.HandOverOrder(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.CacheOrder{}, "order_id = ? AND handed_over_at IS NOT NULL", order.ID))
+	s.Equal(int64(1), s.count(&postgres.OrderEvent{}, "order_id = ? AND status = ?", order.ID, postgres.OrderStatusReceived))
+	s.Equal(before+2, s.outboxCount(order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestRemoveOrderLeftovers() {
+	order := s.createOrder()
+	s.Require().NoError(s.storage.StartCooking(s.ctx, s.startCookingParams(order)))
+
+	s.twice(func() error { return s.storage.RemoveOrderLeftovers(s.ctx, order.ID) })
+
+	s.Equal(int64(0), s.count(&postgres.CookItem{}, "order_id = ? AND ready_at IS NULL", order.ID))
+	s.Equal(int64(0), s.count(&postgres.CacheOrder{}, "order_id = ? AND handed_over_at IS NULL", order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestSaveReceipt() {
+	order := s.createOrder()
+
+	params := postgres.SaveReceiptParams{
+		OrderID:      order.ID,
+		IssuedAt:     time.Now(),
+		FiscalNumber: "0001",
+		Document:     json.RawMessage(`{"total":50000}`),
+	}
+	s.twice(func() error { return s.storage.SaveReceipt(s.ctx, params) })
+
+	s.Equal(int64(1), s.count(&postgres.Receipt{}, "id = ?", order.ID))
+}
+
+func (s *IdempotencyTestSuite) TestGiftCard() {
+	order := s.createOrder()
+
+	card, err := s.storage.IssueGiftCard(s.ctx, postgres.IssueGiftCardParams{
+		InitialBalance: 30000,
+		Currency:       "RUB",
+		ExpiresAt:      time.Now().Add(24 * time.Hour),
+	})
+	s.Require().NoError(err)
+
+	params := postgres.ReserveGiftCardParams{
+		Code:     card.Code,
+		OrderID:  order.ID,
+		Amount:   order.TotalPrice,
+		Currency: "RUB",
+		At:       time.Now(),
+	}
+	for i := 0; i < 2; i++ {
+		result, err := s.storage.ReserveGiftCard(s.ctx, params)
+		s.Require().NoError(err)
+		s.Equal(int64(30000), result.Amount)
+		s.Empty(result.Reason)
+	}
+
+	balance, err := s.storage.GetGiftCardBalance(s.ctx, card.Code)
+	s.Require().NoError(err)
+	s.Equal(int64(0), balance.Available)
+
+	s.twice(func() error { return s.storage.FinalizeGiftCardRedemption(s.ctx, order.ID) })
+	// Резерв уже закрыт списанием, возврат ничего не должен менять.
+	s.twice(func() error { return s.storage.ReleaseGiftCardReservation(s.ctx, order.ID) })
+
+	balance, err = s.storage.GetGiftCardBalance(s.ctx, card.Code)
+	s.Require().NoError(err)
+	s.Equal(int64(0), balance.Balance)
+	s.Equal(int64(0), balance.Available)
+}
+
+func (s *IdempotencyTestSuite) TestCreateZReport() {
+	s.createOrder()
+
+	now := time.Now()
+	params := postgres.CreateZReportParams{
+		PointID:  s.point.ID,
+		Day:      now.Format("2006-01-02"),
+		StartsAt: now.Add(-time.Hour),
+		EndsAt:   now.Add(time.Hour),
+	}
+	for i := 0; i < 2; i++ {
+		_, err := s.storage.CreateZReport(s.ctx, params)
+		s.Require().NoError(err)
+	}
+
+	s.Equal(int64(1), s.count(&postgres.ZReport{}, "point_id = ? AND day = ?", s.point.ID, params.Day))
+}
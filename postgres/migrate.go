@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+//go:embed baseline.sql
+var baselineScript string
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationsLockKey ключ advisory lock, что бы две миграции не шли разом.
+const migrationsLockKey = 7400
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    bigint PRIMARY KEY,
+	name       varchar(255) NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// Migration одна миграция из migrations/<версия>_<имя>.(up|down).sql.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus миграция и когда она применена, AppliedAt пустой, если ещё нет.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []*Migration
+}
+
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// loadMigrations читает миграции и проверяет, что у каждой есть up и down, а
+// версии идут подряд с единицы.
+func loadMigrations(files fs.FS) ([]*Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, path := range names {
+		name := path[len("migrations/"):]
+
+		match := migrationFileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("bad migration file name %s", name)
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %s: %v", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		bb, err := fs.ReadFile(files, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			m.up = string(bb)
+		} else {
+			m.down = string(bb)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both up and down files", m.Version, m.Name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// withLock держит advisory lock на одном соединении, пока работает fn. Другой
+// запуск миграций ждёт, пока этот закончит.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationsLockKey).Error; err != nil {
+			return fmt.Errorf("lock migrations: %v", err)
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationsLockKey)
+
+		if err := conn.Exec(createSchemaMigrations).Error; err != nil {
+			return fmt.Errorf("create schema_migrations: %v", err)
+		}
+
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) applied(conn *gorm.DB) (map[int]appliedMigration, error) {
+	rows := make([]appliedMigration, 0)
+	if err := conn.Raw("SELECT version, name, applied_at FROM schema_migrations").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %v", err)
+	}
+
+	applied := make(map[int]appliedMigration)
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Up применяет по порядку все неприменённые миграции до версии to
+// включительно, 0 значит до последней. Каждая миграция идёт в своей транзакции.
+func (m *Migrator) Up(ctx context.Context, to int, logf func(format string, args ...interface{})) error {
+	return m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if to > 0 && migration.Version > to {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			if err = conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.up).Error; err != nil {
+					return err
+				}
+				return tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+					migration.Version, migration.Name).Error
+			}); err != nil {
+				return fmt.Errorf("apply migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+
+			logf("applied %d_%s", migration.Version, migration.Name)
+		}
+
+		return nil
+	})
+}
+
+// Down откатывает steps последних применённых миграций.
+func (m *Migrator) Down(ctx context.Context, steps int, logf func(format string, args ...interface{})) error {
+	return m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err = conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.down).Error; err != nil {
+					return err
+				}
+				return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+			}); err != nil {
+				return fmt.Errorf("revert migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+
+			logf("reverted %d_%s", migration.Version, migration.Name)
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Baseline переводит базу, созданную ещё через AutoMigrate, на миграции:
+// доводит её схему скриптом baseline.sql до 0001_init, переводя деньги в
+// копейки и создавая недостающие таблицы, и в той же транзакции отмечает
+// первую миграцию применённой. Остальные миграции после этого ставит Up.
+func (m *Migrator) Baseline(ctx context.Context, logf func(format string, args ...interface{})) error {
+	first := m.migrations[0]
+
+	return m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			return fmt.Errorf("database is already under migrations, %d applied", len(applied))
+		}
+
+		if err = conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(baselineScript).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+				first.Version, first.Name).Error
+		}); err != nil {
+			return fmt.Errorf("baseline to %d_%s: %v", first.Version, first.Name, err)
+		}
+
+		logf("upgraded schema to %d_%s", first.Version, first.Name)
+
+		return nil
+	})
+}
+
+// Status все миграции по порядку и применены ли они.
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	list := make([]*MigrationStatus, 0)
+
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := &MigrationStatus{
+				Version: migration.Version,
+				Name:    migration.Name,
+			}
+			if row, ok := applied[migration.Version]; ok {
+				appliedAt := row.AppliedAt
+				status.AppliedAt = &appliedAt
+			}
+			list = append(list, status)
+		}
+
+		return nil
+	})
+
+	return list, err
+}
@@ -0,0 +1,14 @@
+DROP TABLE outbox_entries;
+DROP TABLE z_reports;
+DROP TABLE gift_card_ledger_entries;
+DROP TABLE gift_cards;
+DROP TABLE receipts;
+DROP TABLE cache_orders;
+DROP TABLE cook_items;
+DROP TABLE order_events;
+DROP TABLE log_items;
+DROP TABLE order_items;
+DROP TABLE orders;
+DROP TABLE points;
+DROP TABLE items;
+DROP TABLE users;
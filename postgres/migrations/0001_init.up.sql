@@ -0,0 +1,158 @@
+-- Схема, которую раньше создавал AutoMigrate в create_schema.
+
+CREATE TABLE users (
+    id   uuid PRIMARY KEY,
+    name varchar(255)
+);
+
+CREATE TABLE items (
+    id       uuid PRIMARY KEY,
+    title    varchar(255),
+    price    bigint,
+    currency varchar(3) DEFAULT 'RUB',
+    vat_rate bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE points (
+    id         uuid PRIMARY KEY,
+    addr       varchar(255),
+    kitchen_id uuid,
+    cache_id   uuid,
+    timezone   varchar(64) DEFAULT 'Asia/Yekaterinburg'
+);
+
+CREATE UNIQUE INDEX kitchen_uniq_idx ON points (kitchen_id);
+CREATE UNIQUE INDEX cache_uniq_idx ON points (cache_id);
+
+CREATE TABLE orders (
+    id               uuid PRIMARY KEY,
+    created_at       timestamptz,
+    status           varchar(255),
+    total_price      bigint,
+    currency         varchar(3) DEFAULT 'RUB',
+    vat_amount       bigint NOT NULL DEFAULT 0,
+    gift_card_amount bigint NOT NULL DEFAULT 0,
+    pin_code         varchar(255),
+    user_id          uuid,
+    point_id         uuid,
+    CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users (id),
+    CONSTRAINT fk_orders_point FOREIGN KEY (point_id) REFERENCES points (id)
+);
+
+CREATE TABLE order_items (
+    id          uuid PRIMARY KEY,
+    title       varchar(255),
+    price       bigint,
+    item_id     uuid,
+    quantity    decimal,
+    total_price bigint,
+    vat_rate    bigint NOT NULL DEFAULT 0,
+    vat_amount  bigint NOT NULL DEFAULT 0,
+    order_id    uuid,
+    CONSTRAINT fk_order_items_item FOREIGN KEY (item_id) REFERENCES items (id),
+    CONSTRAINT fk_orders_items FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE TABLE log_items (
+    id         uuid PRIMARY KEY,
+    created_at timestamptz,
+    text       text,
+    order_id   uuid,
+    CONSTRAINT fk_orders_log_items FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE TABLE order_events (
+    id       uuid PRIMARY KEY,
+    order_id uuid,
+    status   varchar(255),
+    at       timestamptz,
+    actor    varchar(255),
+    payload  jsonb NOT NULL DEFAULT '{}',
+    CONSTRAINT fk_orders_events FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE UNIQUE INDEX order_event_status_uniq_idx ON order_events (order_id, status);
+
+CREATE TABLE cook_items (
+    id         uuid PRIMARY KEY,
+    created_at timestamptz,
+    title      varchar(255),
+    quantity   decimal,
+    kitchen_id uuid,
+    order_id   uuid
+);
+
+CREATE TABLE cache_orders (
+    id                uuid PRIMARY KEY,
+    created_at        timestamptz,
+    cache_id          uuid,
+    order_id          uuid,
+    user_name         varchar(255),
+    status            varchar(255),
+    readiness_percent bigint,
+    check_list        varchar(1023)
+);
+
+CREATE TABLE receipts (
+    id            uuid PRIMARY KEY,
+    created_at    timestamptz,
+    issued_at     timestamptz,
+    fiscal_number varchar(255),
+    document      jsonb
+);
+
+CREATE TABLE gift_cards (
+    id              uuid PRIMARY KEY,
+    created_at      timestamptz,
+    code            varchar(32),
+    initial_balance bigint,
+    balance         bigint,
+    reserved        bigint,
+    currency        varchar(3) DEFAULT 'RUB',
+    expires_at      timestamptz
+);
+
+CREATE UNIQUE INDEX gift_card_code_uniq_idx ON gift_cards (code);
+
+CREATE TABLE gift_card_ledger_entries (
+    id           uuid PRIMARY KEY,
+    created_at   timestamptz,
+    gift_card_id uuid,
+    order_id     uuid,
+    kind         varchar(255),
+    amount       bigint
+);
+
+CREATE UNIQUE INDEX gift_card_movement_uniq_idx ON gift_card_ledger_entries (gift_card_id, order_id, kind);
+
+CREATE TABLE z_reports (
+    id               uuid PRIMARY KEY,
+    created_at       timestamptz,
+    point_id         uuid,
+    day              varchar(10),
+    starts_at        timestamptz,
+    ends_at          timestamptz,
+    currency         varchar(3) DEFAULT 'RUB',
+    orders_count     bigint,
+    revenue          bigint,
+    refunds_count    bigint,
+    refunds_amount   bigint,
+    payment_timeouts bigint,
+    abandoned_orders bigint,
+    avg_cook_seconds bigint
+);
+
+CREATE UNIQUE INDEX z_report_point_day_uniq_idx ON z_reports (point_id, day);
+
+CREATE TABLE outbox_entries (
+    id              bigserial PRIMARY KEY,
+    created_at      timestamptz,
+    order_id        uuid,
+    kind            varchar(255),
+    payload         jsonb,
+    attempts        bigint NOT NULL DEFAULT 0,
+    last_error      text,
+    next_attempt_at timestamptz
+);
+
+CREATE INDEX outbox_entry_order_idx ON outbox_entries (order_id);
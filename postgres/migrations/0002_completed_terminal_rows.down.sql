@@ -0,0 +1,11 @@
+DROP INDEX cache_orders_history_idx;
+DROP INDEX cache_orders_active_idx;
+DROP INDEX cook_items_history_idx;
+DROP INDEX cook_items_active_idx;
+
+-- Завершённые строки раньше удалялись, без колонок их не отличить от текущих.
+DELETE FROM cook_items WHERE ready_at IS NOT NULL;
+DELETE FROM cache_orders WHERE handed_over_at IS NOT NULL;
+
+ALTER TABLE cache_orders DROP COLUMN handed_over_at;
+ALTER TABLE cook_items DROP COLUMN ready_at;
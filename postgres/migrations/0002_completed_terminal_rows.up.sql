@@ -0,0 +1,11 @@
+-- Приготовленные позиции и выданные заказы больше не удаляются, а помечаются
+-- временем, что бы по ним можно было разбирать споры.
+
+ALTER TABLE cook_items ADD COLUMN ready_at timestamptz;
+ALTER TABLE cache_orders ADD COLUMN handed_over_at timestamptz;
+
+CREATE INDEX cook_items_active_idx ON cook_items (kitchen_id, created_at) WHERE ready_at IS NULL;
+CREATE INDEX cook_items_history_idx ON cook_items (kitchen_id, ready_at) WHERE ready_at IS NOT NULL;
+
+CREATE INDEX cache_orders_active_idx ON cache_orders (cache_id, created_at) WHERE handed_over_at IS NULL;
+CREATE INDEX cache_orders_history_idx ON cache_orders (cache_id, handed_over_at) WHERE handed_over_at IS NOT NULL;
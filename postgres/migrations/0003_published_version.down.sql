@@ -0,0 +1 @@
+ALTER TABLE orders DROP COLUMN published_version;
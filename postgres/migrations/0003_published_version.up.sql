@@ -0,0 +1,3 @@
+-- Версия документа заказа в поиске, последней записанная в outbox. По ней
+-- повтор активити понимает, что изменения уже опубликованы.
+ALTER TABLE orders ADD COLUMN published_version bigint NOT NULL DEFAULT 0;
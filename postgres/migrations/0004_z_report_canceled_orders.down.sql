@@ -0,0 +1,2 @@
+ALTER TABLE z_reports ADD COLUMN refunds_amount bigint NOT NULL DEFAULT 0;
+ALTER TABLE z_reports RENAME COLUMN canceled_orders TO refunds_count;
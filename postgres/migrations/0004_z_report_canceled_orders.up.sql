@@ -0,0 +1,4 @@
+-- Отменённые до оплаты заказы денег не приносили, возвратом они не были.
+-- Колонка refunds_count всегда считала именно их, сумма по ним не нужна.
+ALTER TABLE z_reports RENAME COLUMN refunds_count TO canceled_orders;
+ALTER TABLE z_reports DROP COLUMN refunds_amount;
@@ -0,0 +1,3 @@
+DROP INDEX outbox_entries_pending_idx;
+
+ALTER TABLE outbox_entries DROP COLUMN parked_at;
@@ -0,0 +1,5 @@
+-- Запись, которую так и не удалось доставить, откладывается насовсем и больше
+-- не держит следующие записи своего заказа.
+ALTER TABLE outbox_entries ADD COLUMN parked_at timestamptz;
+
+CREATE INDEX outbox_entries_pending_idx ON outbox_entries (next_attempt_at) WHERE parked_at IS NULL;
@@ -0,0 +1,3 @@
+-- Колонки не возвращаются: в схеме 0001_init их нет, а собранная история
+-- статусов остаётся верной.
+SELECT 1;
@@ -0,0 +1,34 @@
+-- В базах, созданных через AutoMigrate, у заказов остались колонки cooking_at и
+-- ready_at, а история статусов есть только у заказов, сделанных после её
+-- появления. Заказам без истории она собирается из этих колонок и текущего
+-- статуса, после чего колонки удаляются. Точное время известно только для
+-- создания, начала готовки и готовности, у текущего статуса берётся последнее
+-- известное время. Такие события помечены в payload как backfilled. В базах,
+-- созданных миграциями, колонок нет, и миграция ничего не делает.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'cooking_at'
+    ) THEN
+        RETURN;
+    END IF;
+
+    -- Если текущий статус один из известных, то берётся известное время.
+    INSERT INTO order_events (id, order_id, status, at, actor, payload)
+    SELECT DISTINCT ON (o.id, e.status)
+        md5(o.id::text || e.status)::uuid, o.id, e.status, e.at, e.actor, '{"backfilled": true}'::jsonb
+    FROM orders o
+    CROSS JOIN LATERAL (VALUES
+        (1, 'waiting_for_payment', o.created_at, 'user'),
+        (1, 'cooking', o.cooking_at, 'system'),
+        (1, 'ready', o.ready_at, 'kitchen'),
+        (2, o.status, coalesce(o.ready_at, o.cooking_at, o.created_at), 'system')
+    ) AS e (priority, status, at, actor)
+    WHERE e.at IS NOT NULL AND e.status IS NOT NULL
+        AND NOT EXISTS (SELECT 1 FROM order_events existing WHERE existing.order_id = o.id)
+    ORDER BY o.id, e.status, e.priority;
+
+    ALTER TABLE orders DROP COLUMN cooking_at;
+    ALTER TABLE orders DROP COLUMN IF EXISTS ready_at;
+END $$;
@@ -0,0 +1,11 @@
+DROP INDEX cook_items_active_idx;
+DROP INDEX cache_orders_active_idx;
+
+CREATE INDEX cook_items_active_idx ON cook_items (kitchen_id, created_at) WHERE ready_at IS NULL;
+CREATE INDEX cache_orders_active_idx ON cache_orders (cache_id, created_at) WHERE handed_over_at IS NULL;
+
+UPDATE cook_items SET ready_at = removed_at WHERE removed_at IS NOT NULL AND ready_at IS NULL;
+UPDATE cache_orders SET handed_over_at = removed_at WHERE removed_at IS NOT NULL AND handed_over_at IS NULL;
+
+ALTER TABLE cache_orders DROP COLUMN removed_at;
+ALTER TABLE cook_items DROP COLUMN removed_at;
@@ -0,0 +1,11 @@
+-- Сверка убирает с экранов позиции и заказы, которые так и не приготовили или
+-- не выдали. Раньше она ставила им ready_at и handed_over_at, и они попадали в
+-- историю как приготовленные и выданные. Теперь отмечает отдельно.
+ALTER TABLE cook_items ADD COLUMN removed_at timestamptz;
+ALTER TABLE cache_orders ADD COLUMN removed_at timestamptz;
+
+DROP INDEX cook_items_active_idx;
+DROP INDEX cache_orders_active_idx;
+
+CREATE INDEX cook_items_active_idx ON cook_items (kitchen_id, created_at) WHERE ready_at IS NULL AND removed_at IS NULL;
+CREATE INDEX cache_orders_active_idx ON cache_orders (cache_id, created_at) WHERE handed_over_at IS NULL AND removed_at IS NULL;
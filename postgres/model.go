@@ -20,9 +20,11 @@ func (u *User) BeforeCreate(_ *gorm.DB) error {
 }
 
 type Item struct {
-	ID    uuid.UUID `gorm:"primaryKey;type:uuid"`
-	Title string    `gorm:"type:varchar(255)"`
-	Price float64
+	ID       uuid.UUID `gorm:"primaryKey;type:uuid"`
+	Title    string    `gorm:"type:varchar(255)"`
+	Price    int64     // в копейках
+	Currency string    `gorm:"type:varchar(3);default:RUB"`
+	VATRate  int       `gorm:"not null;default:0"` // ставка НДС в процентах, НДС входит в цену
 }
 
 func (i *Item) BeforeCreate(_ *gorm.DB) error {
@@ -37,6 +39,7 @@ type Point struct {
 	Addr      string    `gorm:"type:varchar(255)"`
 	KitchenID uuid.UUID `gorm:"uniqueIndex:kitchen_uniq_idx;type:uuid"`
 	CacheID   uuid.UUID `gorm:"uniqueIndex:cache_uniq_idx;type:uuid"`
+	Timezone  string    `gorm:"type:varchar(64);default:Asia/Yekaterinburg"`
 }
 
 func (p *Point) BeforeCreate(_ *gorm.DB) error {
@@ -53,36 +56,63 @@ func (p *Point) BeforeCreate(_ *gorm.DB) error {
 }
 
 type Order struct {
-	ID         uuid.UUID `gorm:"primaryKey;type:uuid"`
-	CreatedAt  time.Time
-	Status     string `gorm:"type:varchar(255)"`
-	TotalPrice float64
-	PINCode    string    `gorm:"type:varchar(255)"`
-	UserID     uuid.UUID `gorm:"type:uuid"`
-	User       *User
-	PointID    uuid.UUID `gorm:"type:uuid"`
-	Point      *Point
-	Items      []*OrderItem
-	LogItems   []*LogItem
+	ID               uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt        time.Time
+	Status           string    `gorm:"type:varchar(255)"`
+	TotalPrice       int64     // в копейках
+	Currency         string    `gorm:"type:varchar(3);default:RUB"`
+	VATAmount        int64     `gorm:"not null;default:0"` // в копейках
+	GiftCardAmount   int64     `gorm:"not null;default:0"` // в копейках, оплачено подарочной картой
+	PublishedVersion int64     `gorm:"not null;default:0"` // версия документа в поиске, последней записанная в outbox
+	PINCode          string    `gorm:"type:varchar(255)"`
+	UserID           uuid.UUID `gorm:"type:uuid"`
+	User             *User
+	PointID          uuid.UUID `gorm:"type:uuid"`
+	Point            *Point
+	Items            []*OrderItem
+	LogItems         []*LogItem
+	Events           []*OrderEvent
+}
+
+// OrderEvent смена статуса заказа: когда, кто её вызвал и подробности.
+// Каждый статус заказ проходит не больше одного раза.
+type OrderEvent struct {
+	ID      uuid.UUID `gorm:"primaryKey;type:uuid"`
+	OrderID uuid.UUID `gorm:"uniqueIndex:order_event_status_uniq_idx;type:uuid"`
+	Status  string    `gorm:"uniqueIndex:order_event_status_uniq_idx;type:varchar(255)"`
+	At      time.Time
+	Actor   string `gorm:"type:varchar(255)"`
+	Payload string `gorm:"type:jsonb;not null;default:'{}'"`
+	Order   *Order
+}
+
+func (e *OrderEvent) BeforeCreate(_ *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
 }
 
 type OrderItem struct {
 	ID         uuid.UUID `gorm:"primaryKey;type:uuid"`
 	Title      string    `gorm:"type:varchar(255)"`
-	Price      float64
+	Price      int64     // в копейках
 	ItemID     uuid.UUID
 	Item       *Item
 	Quantity   float64
-	TotalPrice float64
+	TotalPrice int64 // в копейках
+	VATRate    int   `gorm:"not null;default:0"`
+	VATAmount  int64 `gorm:"not null;default:0"` // в копейках
 	OrderID    uuid.UUID
 	Order      *Order
 }
 
 type LogItem struct {
-	ID      uuid.UUID `gorm:"primaryKey;type:uuid"`
-	Text    string
-	OrderID uuid.UUID `gorm:"type:uuid"`
-	Order   *Order
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+	Text      string
+	OrderID   uuid.UUID `gorm:"type:uuid"`
+	Order     *Order
 }
 
 func (p *LogItem) BeforeCreate(_ *gorm.DB) error {
@@ -97,8 +127,10 @@ type CookItem struct {
 	CreatedAt time.Time
 	Title     string `gorm:"type:varchar(255)"`
 	Quantity  float64
-	KitchenID uuid.UUID `gorm:"type:uuid"`
-	OrderID   uuid.UUID `gorm:"type:uuid"`
+	KitchenID uuid.UUID  `gorm:"type:uuid"`
+	OrderID   uuid.UUID  `gorm:"type:uuid"`
+	ReadyAt   *time.Time // когда приготовили, до этого позиция на экране кухни
+	RemovedAt *time.Time // когда сверка убрала с экрана так и не приготовленную
 }
 
 type CacheOrder struct {
@@ -109,5 +141,82 @@ type CacheOrder struct {
 	UserName         string    `gorm:"type:varchar(255)"`
 	Status           string    `gorm:"type:varchar(255)"`
 	ReadinessPercent int
-	CheckList        string `gorm:"type:varchar(1023)"`
+	CheckList        string     `gorm:"type:varchar(1023)"`
+	HandedOverAt     *time.Time // когда выдали, до этого заказ на экране кассы
+	RemovedAt        *time.Time // когда сверка убрала с экрана так и не выданный
+}
+
+type Receipt struct {
+	ID           uuid.UUID `gorm:"primaryKey;type:uuid"` // the same as Order.ID
+	CreatedAt    time.Time
+	IssuedAt     time.Time
+	FiscalNumber string `gorm:"type:varchar(255)"`
+	Document     string `gorm:"type:jsonb"`
+}
+
+type GiftCard struct {
+	ID             uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt      time.Time
+	Code           string `gorm:"uniqueIndex:gift_card_code_uniq_idx;type:varchar(32)"`
+	InitialBalance int64  // в копейках
+	Balance        int64  // в копейках, без учёта резервов
+	Reserved       int64  // в копейках, зарезервировано под неоплаченные заказы
+	Currency       string `gorm:"type:varchar(3);default:RUB"`
+	ExpiresAt      time.Time
+}
+
+func (c *GiftCard) BeforeCreate(_ *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+const (
+	GiftCardMovementIssue   = "issue"
+	GiftCardMovementReserve = "reserve"
+	GiftCardMovementRedeem  = "redeem"
+	GiftCardMovementRelease = "release"
+)
+
+// GiftCardLedgerEntry движение по подарочной карте. Каждое движение по заказу
+// пишется один раз, поэтому повтор активити не задвоит списание.
+type GiftCardLedgerEntry struct {
+	ID         uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt  time.Time
+	GiftCardID uuid.UUID  `gorm:"uniqueIndex:gift_card_movement_uniq_idx;type:uuid"`
+	OrderID    *uuid.UUID `gorm:"uniqueIndex:gift_card_movement_uniq_idx;type:uuid"`
+	Kind       string     `gorm:"uniqueIndex:gift_card_movement_uniq_idx;type:varchar(255)"`
+	Amount     int64      // в копейках
+}
+
+func (e *GiftCardLedgerEntry) BeforeCreate(_ *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// ZReport итоги дня по точке.
+type ZReport struct {
+	ID              uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt       time.Time
+	PointID         uuid.UUID `gorm:"uniqueIndex:z_report_point_day_uniq_idx;type:uuid"`
+	Day             string    `gorm:"uniqueIndex:z_report_point_day_uniq_idx;type:varchar(10)"` // YYYY-MM-DD
+	StartsAt        time.Time
+	EndsAt          time.Time
+	Currency        string `gorm:"type:varchar(3);default:RUB"`
+	OrdersCount     int64
+	Revenue         int64 // в копейках
+	CanceledOrders  int64
+	PaymentTimeouts int64
+	AbandonedOrders int64
+	AvgCookSeconds  int64
+}
+
+func (r *ZReport) BeforeCreate(_ *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
 }
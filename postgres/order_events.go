@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы заказа, которые проходит OrderWorkflow.
+const (
+	OrderStatusWaitingForPayment = "waiting_for_payment"
+	OrderStatusPaymentTimeout    = "payment_timeout"
+	OrderStatusPaid              = "paid"
+	OrderStatusPaymentCanceled   = "payment_canceled"
+	OrderStatusCooking           = "cooking"
+	OrderStatusReady             = "ready"
+	OrderStatusReceived          = "received"
+)
+
+// Статусы заказа на экране кассы.
+const (
+	CacheOrderStatusCooking = "cooking"
+	CacheOrderStatusReady   = "ready"
+)
+
+// Кто вызвал смену статуса заказа.
+const (
+	OrderActorUser           = "user"
+	OrderActorPaymentGateway = "payment_gateway"
+	OrderActorKitchen        = "kitchen"
+	OrderActorCache          = "cache"
+	OrderActorSystem         = "system"
+)
+
+// OrderEventParams смена статуса заказа. Payload произвольный json с
+// подробностями, например причиной отмены.
+type OrderEventParams struct {
+	Status  string
+	At      time.Time
+	Actor   string
+	Payload json.RawMessage
+}
+
+func (e OrderEventParams) model(orderID uuid.UUID) *OrderEvent {
+	payload := "{}"
+	if len(e.Payload) > 0 {
+		payload = string(e.Payload)
+	}
+
+	return &OrderEvent{
+		OrderID: orderID,
+		Status:  e.Status,
+		At:      e.At,
+		Actor:   e.Actor,
+		Payload: payload,
+	}
+}
+
+// changeOrderStatus записывает смену статуса в историю и меняет статус заказа.
+// Если такая смена уже записана, то есть это повтор активити, то ничего не
+// делает и возвращает false.
+func changeOrderStatus(tx *gorm.DB, orderID uuid.UUID, event OrderEventParams) (bool, error) {
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(event.model(orderID))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+
+	return true, updateOrderStatus(tx, orderID, event.Status)
+}
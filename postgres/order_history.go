@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrderCursor позиция в истории заказов, заказы упорядочены по времени
+// создания и идентификатору.
+type OrderCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// ListOrdersBatch отдаёт следующую после курсора пачку заказов вместе с
+// позициями, логами, пользователем и точкой.
+func (p *Postgres) ListOrdersBatch(ctx context.Context, after OrderCursor, limit int) ([]*Order, error) {
+	orders := make([]*Order, 0)
+	if err := p.db.WithContext(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("title, id") }).
+		Preload("LogItems", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") }).
+		Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("at, id") }).
+		Preload("User").
+		Preload("Point").
+		Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&orders).Error; err != nil {
+
+		return nil, err
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,235 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/sse"
+)
+
+const (
+	OutboxKindIndexOrder   = "index_order"
+	OutboxKindUpdateOrder  = "update_order"
+	OutboxKindNotification = "notification"
+)
+
+// OutboxEntry запись, которую релей должен доставить в поиск или сервер
+// уведомлений. Пишется в той же транзакции, что и изменение заказа, поэтому
+// если заказ изменился, то и поиск с клиентами об этом узнают.
+type OutboxEntry struct {
+	ID            int64 `gorm:"primaryKey;autoIncrement"`
+	CreatedAt     time.Time
+	OrderID       uuid.UUID `gorm:"index:outbox_entry_order_idx;type:uuid"`
+	Kind          string    `gorm:"type:varchar(255)"`
+	Payload       string    `gorm:"type:jsonb"`
+	Attempts      int       `gorm:"not null;default:0"`
+	LastError     string
+	NextAttemptAt time.Time
+	// ParkedAt когда запись отложили насовсем после последней неудачной
+	// попытки. Такая запись не доставляется и не держит следующие записи
+	// заказа. Вернуть её в очередь можно руками:
+	// UPDATE outbox_entries SET parked_at = NULL, attempts = 0 WHERE id = ...
+	ParkedAt *time.Time
+}
+
+type (
+	// Outbox что надо доставить после изменения заказа. Записи доставляются в
+	// порядке полей: сначала поиск, потом уведомления, что бы клиент,
+	// получив уведомление, уже увидел изменения в поиске.
+	Outbox struct {
+		IndexOrder    *elasticsearch.Order
+		UpdateOrder   *OutboxOrderUpdate
+		Notifications []sse.Event
+	}
+	OutboxOrderUpdate struct {
+		Version int64
+		Doc     *elasticsearch.Order
+	}
+)
+
+// writeOutbox пишет записи outbox заказа. Если в outbox есть документ для
+// поиска, а его версия уже записана раньше, то это повтор и ничего не пишется.
+func writeOutbox(tx *gorm.DB, orderID uuid.UUID, outbox Outbox) error {
+	var version int64
+	if outbox.IndexOrder != nil {
+		version = outbox.IndexOrder.Version
+	}
+	if outbox.UpdateOrder != nil && outbox.UpdateOrder.Version > version {
+		version = outbox.UpdateOrder.Version
+	}
+
+	if version > 0 {
+		res := tx.Model(Order{}).
+			Where("id = ? AND published_version < ?", orderID, version).
+			Update("published_version", version)
+		if res.Error != nil {
+			return fmt.Errorf("bump published version: %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+	}
+
+	entries := make([]*OutboxEntry, 0)
+
+	add := func(kind string, payload interface{}) error {
+		bb, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("marshal %s outbox entry: %v", kind, err)
+		}
+		entries = append(entries, &OutboxEntry{
+			OrderID:       orderID,
+			Kind:          kind,
+			Payload:       string(bb),
+			NextAttemptAt: time.Now(),
+		})
+		return nil
+	}
+
+	if outbox.IndexOrder != nil {
+		if err := add(OutboxKindIndexOrder, outbox.IndexOrder); err != nil {
+			return err
+		}
+	}
+	if outbox.UpdateOrder != nil {
+		if err := add(OutboxKindUpdateOrder, outbox.UpdateOrder); err != nil {
+			return err
+		}
+	}
+	for _, event := range outbox.Notifications {
+		if err := add(OutboxKindNotification, event); err != nil {
+			return err
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return tx.Create(entries).Error
+}
+
+// PublishOrderChanges только пишет в outbox, когда заказ в базе уже изменён
+// другой активити, например резервом подарочной карты. Повтор отсекается по
+// версии документа, поэтому в outbox должен быть UpdateOrder.
+func (p *Postgres) PublishOrderChanges(ctx context.Context, orderID uuid.UUID, outbox Outbox) error {
+	if outbox.UpdateOrder == nil {
+		return fmt.Errorf("publish changes of order %s: outbox has no search update", orderID)
+	}
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return writeOutbox(tx, orderID, outbox)
+	})
+}
+
+// outboxLease на сколько взятая релеем запись пропадает для других релеев,
+// пока её доставляют. Если релей упал, то запись возьмут снова после этого
+// срока. С запасом больше таймаута bulk-запроса в поиск.
+const outboxLease = 2 * time.Minute
+
+// DeliverOutbox берёт по одной самой ранней записи каждого заказа, у которой
+// подошло время, и отдаёт их deliver разом. deliver возвращает ошибку по
+// каждой записи. Доставленные записи удаляются, у недоставленных
+// откладывается следующая попытка, а более поздние записи того же заказа
+// ждут, пока не пройдёт эта. После maxAttempts неудачных попыток запись
+// откладывается насовсем (ParkedAt), и очередь заказа идёт дальше. Возвращает
+// сколько записей доставлено.
+//
+// Транзакция держится только пока записи берутся и пока пишется результат,
+// доставка идёт без неё. Несколько релеев не мешают друг другу: взятые записи
+// до конца транзакции заблокированы, а после скрыты арендой outboxLease.
+func (p *Postgres) DeliverOutbox(ctx context.Context, limit, maxAttempts int, backoff func(attempts int) time.Duration, deliver func(ctx context.Context, entries []*OutboxEntry) []error) (int, error) {
+	entries, err := p.claimOutbox(ctx, limit)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	errs := deliver(ctx, entries)
+
+	var delivered int
+
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		for i, entry := range entries {
+			if errs[i] != nil {
+				entry.Attempts++
+				updates := map[string]interface{}{
+					"attempts":        entry.Attempts,
+					"last_error":      errs[i].Error(),
+					"next_attempt_at": now.Add(backoff(entry.Attempts)),
+				}
+				if entry.Attempts >= maxAttempts {
+					updates["parked_at"] = now
+				}
+				if err := tx.Model(entry).Updates(updates).Error; err != nil {
+					return fmt.Errorf("postpone outbox entry %d: %v", entry.ID, err)
+				}
+				continue
+			}
+
+			if err := tx.Delete(entry).Error; err != nil {
+				return fmt.Errorf("delete outbox entry %d: %v", entry.ID, err)
+			}
+			delivered++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return delivered, nil
+}
+
+// claimOutbox берёт головы очередей заказов и сдвигает им следующую попытку на
+// outboxLease. Головой считается самая ранняя неотложенная запись заказа.
+func (p *Postgres) claimOutbox(ctx context.Context, limit int) ([]*OutboxEntry, error) {
+	entries := make([]*OutboxEntry, 0)
+
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if err := tx.Raw(`SELECT * FROM outbox_entries e
+			WHERE e.parked_at IS NULL AND e.next_attempt_at <= ?
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox_entries prev
+					WHERE prev.order_id = e.order_id AND prev.id < e.id AND prev.parked_at IS NULL
+				)
+			ORDER BY e.id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED`, now, limit).
+			Scan(&entries).Error; err != nil {
+
+			return fmt.Errorf("select outbox entries: %v", err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+
+		if err := tx.Model(&OutboxEntry{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(outboxLease)).Error; err != nil {
+
+			return fmt.Errorf("lease outbox entries: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
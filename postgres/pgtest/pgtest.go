@@ -0,0 +1,76 @@
+// Package pgtest база для тестов, которые ходят в настоящий постгрес.
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	gormpostgres "gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+// DSNEnv строка подключения к базе для тестов в формате key=value. С базой из
+// docker-compose это "host=localhost port=5442 user=postgres
+// password=postgres dbname=postgres". В CI база поднимается сервисом в
+// .github/workflows/test.yml.
+const DSNEnv = "COFFEE_SHOP_TEST_POSTGRES"
+
+// DSN строка подключения из DSNEnv. Без неё тест пропускается, но только не в
+// CI, там пропуск значил бы, что тесты на базе молча не идут.
+func DSN(t testing.TB) string {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%s is not set in CI", DSNEnv)
+		}
+		t.Skipf("%s is not set", DSNEnv)
+	}
+	return dsn
+}
+
+// NewDB база в отдельной схеме со всеми миграциями. Схема удаляется, когда
+// тест закончится, поэтому тесты не мешают друг другу.
+func NewDB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	dsn := DSN(t)
+	schema := fmt.Sprintf("test_%s", strings.ReplaceAll(uuid.NewString(), "-", ""))
+
+	admin, err := gorm.Open(gormpostgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err = admin.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema)).Error; err != nil {
+		t.Fatalf("create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		if err := admin.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)).Error; err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+	})
+
+	db, err := gorm.Open(gormpostgres.Open(fmt.Sprintf("%s search_path=%s", dsn, schema)),
+		&gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("connect to schema %s: %v", schema, err)
+	}
+
+	migrator, err := postgres.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("migrator: %v", err)
+	}
+	if err = migrator.Up(context.Background(), 0, t.Logf); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
@@ -2,18 +2,34 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Postgres struct {
-	db *gorm.DB
+	db       *gorm.DB
+	replicas []*gorm.DB
+	next     atomic.Uint64
 }
 
-func NewPostgres(db *gorm.DB) *Postgres {
-	return &Postgres{db: db}
+// NewPostgres хранилище поверх основной базы db. Если переданы реплики, то
+// чтения, которым не страшно небольшое отставание, идут по очереди в них.
+func NewPostgres(db *gorm.DB, replicas ...*gorm.DB) *Postgres {
+	return &Postgres{db: db, replicas: replicas}
+}
+
+// reader база для чтений, которые можно отдать реплике. Запись и чтение
+// внутри активити воркфлоу всегда идут в основную базу.
+func (p *Postgres) reader() *gorm.DB {
+	if len(p.replicas) == 0 {
+		return p.db
+	}
+	return p.replicas[(p.next.Add(1)-1)%uint64(len(p.replicas))]
 }
 
 type UserData struct {
@@ -33,9 +49,11 @@ func (p *Postgres) GetUserData(ctx context.Context, userID uuid.UUID) (UserData,
 }
 
 type ItemData struct {
-	ID    uuid.UUID
-	Title string
-	Price float64
+	ID       uuid.UUID
+	Title    string
+	Price    int64
+	Currency string
+	VATRate  int
 }
 
 func (p *Postgres) GetItemsData(ctx context.Context, itemIDs []uuid.UUID) ([]ItemData, error) {
@@ -51,9 +69,11 @@ func (p *Postgres) GetItemsData(ctx context.Context, itemIDs []uuid.UUID) ([]Ite
 
 	for _, item := range items {
 		list = append(list, ItemData{
-			ID:    item.ID,
-			Title: item.Title,
-			Price: item.Price,
+			ID:       item.ID,
+			Title:    item.Title,
+			Price:    item.Price,
+			Currency: item.Currency,
+			VATRate:  item.VATRate,
 		})
 	}
 
@@ -65,6 +85,7 @@ type PointData struct {
 	Addr      string
 	KitchenID uuid.UUID
 	CacheID   uuid.UUID
+	Timezone  string
 }
 
 func (p *Postgres) GetPointData(ctx context.Context, pointID uuid.UUID) (PointData, error) {
@@ -79,6 +100,7 @@ func (p *Postgres) GetPointData(ctx context.Context, pointID uuid.UUID) (PointDa
 		Addr:      point.Addr,
 		KitchenID: point.KitchenID,
 		CacheID:   point.CacheID,
+		Timezone:  point.Timezone,
 	}, nil
 }
 
@@ -87,19 +109,25 @@ type (
 		ID         uuid.UUID
 		CreatedAt  time.Time
 		Status     string
-		TotalPrice float64
+		TotalPrice int64
+		Currency   string
+		VATAmount  int64
 		PINCode    string
 		UserID     uuid.UUID
 		PointID    uuid.UUID
 		Items      []OrderItemParams
+		Event      OrderEventParams
+		Outbox     Outbox
 	}
 	OrderItemParams struct {
 		ID         uuid.UUID
 		Title      string
-		Price      float64
+		Price      int64
 		ItemID     uuid.UUID
 		Quantity   float64
-		TotalPrice float64
+		TotalPrice int64
+		VATRate    int
+		VATAmount  int64
 	}
 )
 
@@ -109,37 +137,83 @@ func (p *Postgres) CreateOrder(ctx context.Context, params OrderParams) error {
 		CreatedAt:  params.CreatedAt,
 		Status:     params.Status,
 		TotalPrice: params.TotalPrice,
+		Currency:   params.Currency,
+		VATAmount:  params.VATAmount,
 		PINCode:    params.PINCode,
 		UserID:     params.UserID,
 		PointID:    params.PointID,
-		Items:      make([]*OrderItem, 0),
 	}
 
+	items := make([]*OrderItem, 0)
 	for _, itemParams := range params.Items {
-		order.Items = append(order.Items, &OrderItem{
+		items = append(items, &OrderItem{
 			ID:         itemParams.ID,
 			Title:      itemParams.Title,
 			Price:      itemParams.Price,
 			ItemID:     itemParams.ItemID,
 			Quantity:   itemParams.Quantity,
 			TotalPrice: itemParams.TotalPrice,
+			VATRate:    itemParams.VATRate,
+			VATAmount:  itemParams.VATAmount,
+			OrderID:    params.ID,
 		})
 	}
 
-	return p.db.WithContext(ctx).Create(order).Error
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Если заказ уже есть, то это повтор активити после коммита.
+		res := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(order)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+
+		if len(items) > 0 {
+			if err := tx.Omit(clause.Associations).Create(items).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(params.Event.model(params.ID)).Error; err != nil {
+			return err
+		}
+		return writeOutbox(tx, order.ID, params.Outbox)
+	})
 }
 
 func (p *Postgres) UpdateOrderStatus(ctx context.Context, orderID uuid.UUID, status string) error {
-	return p.db.WithContext(ctx).
-		Model(Order{}).
+	return updateOrderStatus(p.db.WithContext(ctx), orderID, status)
+}
+
+func updateOrderStatus(tx *gorm.DB, orderID uuid.UUID, status string) error {
+	return tx.Model(Order{}).
 		Where("id = ?", orderID).
 		Update("status", status).Error
 }
 
+type ChangeOrderStatusParams struct {
+	OrderID uuid.UUID
+	Event   OrderEventParams
+	Outbox  Outbox
+}
+
+// ChangeOrderStatus меняет статус заказа, записывает смену в историю и пишет
+// в outbox, что надо обновить поиск и клиентов.
+func (p *Postgres) ChangeOrderStatus(ctx context.Context, params ChangeOrderStatusParams) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		changed, err := changeOrderStatus(tx, params.OrderID, params.Event)
+		if err != nil || !changed {
+			return err
+		}
+		return writeOutbox(tx, params.OrderID, params.Outbox)
+	})
+}
+
 type LogUnsuccessfulPaymentParams struct {
 	ID      uuid.UUID
 	OrderID uuid.UUID
 	Reason  string
+	Outbox  Outbox
 }
 
 func (p *Postgres) LogUnsuccessfulPayment(ctx context.Context, pp LogUnsuccessfulPaymentParams) error {
@@ -148,7 +222,18 @@ func (p *Postgres) LogUnsuccessfulPayment(ctx context.Context, pp LogUnsuccessfu
 		Text:    pp.Reason,
 		OrderID: pp.OrderID,
 	}
-	return p.db.WithContext(ctx).Create(item).Error
+	return p.createLogItem(ctx, item, pp.Outbox)
+}
+
+// createLogItem пишет запись лога заказа. Повтор с тем же ID ничего не делает.
+func (p *Postgres) createLogItem(ctx context.Context, item *LogItem, outbox Outbox) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(item)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return writeOutbox(tx, item.OrderID, outbox)
+	})
 }
 
 type (
@@ -164,71 +249,156 @@ type (
 	}
 )
 
-func (p *Postgres) AddItemsForKitchen(ctx context.Context, params AddItemsForCookingParams) error {
+type AddNewOrderForCacheParams struct {
+	ID               uuid.UUID
+	CacheID          uuid.UUID
+	OrderID          uuid.UUID
+	UserName         string
+	Status           string
+	ReadinessPercent int
+	CheckList        string
+}
+
+type StartCookingParams struct {
+	OrderID uuid.UUID
+	Kitchen AddItemsForCookingParams
+	Cache   AddNewOrderForCacheParams
+	Event   OrderEventParams
+	Outbox  Outbox
+}
+
+// StartCooking отдаёт позиции заказа на кухню, заказ на кассу и меняет статус
+// заказа одной транзакцией. Если статус уже сменён, то это повтор и ничего не
+// делается.
+func (p *Postgres) StartCooking(ctx context.Context, params StartCookingParams) error {
 	items := make([]*CookItem, 0)
-	for _, item := range params.Items {
+	for _, item := range params.Kitchen.Items {
 		items = append(items, &CookItem{
 			ID:        item.ID,
 			Title:     item.Title,
 			Quantity:  item.Quantity,
-			KitchenID: params.KitchenID,
-			OrderID:   params.OrderID,
+			KitchenID: params.Kitchen.KitchenID,
+			OrderID:   params.Kitchen.OrderID,
 		})
 	}
 
-	return p.db.WithContext(ctx).Create(items).Error
+	cacheOrder := &CacheOrder{
+		ID:               params.Cache.ID,
+		CacheID:          params.Cache.CacheID,
+		OrderID:          params.Cache.OrderID,
+		UserName:         params.Cache.UserName,
+		Status:           params.Cache.Status,
+		ReadinessPercent: params.Cache.ReadinessPercent,
+		CheckList:        params.Cache.CheckList,
+	}
+
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		changed, err := changeOrderStatus(tx, params.OrderID, params.Event)
+		if err != nil || !changed {
+			return err
+		}
+
+		if len(items) > 0 {
+			if err := tx.Create(items).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(cacheOrder).Error; err != nil {
+			return err
+		}
+		return writeOutbox(tx, params.OrderID, params.Outbox)
+	})
 }
 
-type AddNewOrderForCacheParams struct {
-	ID               uuid.UUID
-	CacheID          uuid.UUID
+type MarkItemCookedParams struct {
 	OrderID          uuid.UUID
-	UserName         string
-	Status           string
+	OrderItemID      uuid.UUID
 	ReadinessPercent int
-	CheckList        string
+	CacheOrderStatus string
+	At               time.Time
+	// Event смена статуса заказа, если он изменился.
+	Event  *OrderEventParams
+	Outbox Outbox
 }
 
-func (p *Postgres) AddNewOrderForCache(ctx context.Context, params AddNewOrderForCacheParams) error {
-	order := &CacheOrder{
-		ID:               params.ID,
-		CacheID:          params.CacheID,
-		OrderID:          params.OrderID,
-		UserName:         params.UserName,
-		Status:           params.Status,
-		ReadinessPercent: params.ReadinessPercent,
-		CheckList:        params.CheckList,
-	}
-
-	return p.db.WithContext(ctx).Create(order).Error
+// MarkItemCooked отмечает позицию приготовленной, с кухни она пропадает, но
+// остаётся в истории. Обновляет заказ на кассе. Если позиция уже отмечена, то
+// это повтор и ничего не делается.
+func (p *Postgres) MarkItemCooked(ctx context.Context, params MarkItemCookedParams) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(CookItem{}).
+			Where("id = ? AND ready_at IS NULL", params.OrderItemID).
+			Update("ready_at", params.At)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		if err := tx.Model(CacheOrder{}).
+			Where("id = ?", params.OrderID).
+			Updates(map[string]interface{}{
+				"readiness_percent": params.ReadinessPercent,
+				"status":            params.CacheOrderStatus,
+			}).Error; err != nil {
+
+			return err
+		}
+
+		if params.Event != nil {
+			if _, err := changeOrderStatus(tx, params.OrderID, *params.Event); err != nil {
+				return err
+			}
+		}
+
+		return writeOutbox(tx, params.OrderID, params.Outbox)
+	})
 }
 
-func (p *Postgres) RemoveKitchenCookItemAsReady(ctx context.Context, orderItemID uuid.UUID) error {
-	return p.db.WithContext(ctx).Unscoped().Where("id = ?", orderItemID).Delete(&CookItem{}).Error
+type HandOverOrderParams struct {
+	OrderID uuid.UUID
+	Event   OrderEventParams
+	Outbox  Outbox
 }
 
-func (p *Postgres) UpdateCacheOrderReadinessPercent(ctx context.Context, orderID uuid.UUID, readyPercent int) error {
-	return p.db.WithContext(ctx).
-		Model(CacheOrder{}).
-		Where("id = ?", orderID).
-		Update("readiness_percent", readyPercent).Error
+// HandOverOrder отмечает заказ на кассе выданным во время Event.At и меняет
+// его статус. Если заказ уже выдан, то это повтор и ничего не делается.
+func (p *Postgres) HandOverOrder(ctx context.Context, params HandOverOrderParams) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(CacheOrder{}).
+			Where("id = ? AND handed_over_at IS NULL", params.OrderID).
+			Update("handed_over_at", params.Event.At)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		if _, err := changeOrderStatus(tx, params.OrderID, params.Event); err != nil {
+			return err
+		}
+		return writeOutbox(tx, params.OrderID, params.Outbox)
+	})
 }
 
-func (p *Postgres) UpdateCacheOrderStatus(ctx context.Context, orderID uuid.UUID, status string) error {
-	return p.db.WithContext(ctx).
-		Model(CacheOrder{}).
-		Where("id = ?", orderID).
-		Update("status", status).Error
+type SaveReceiptParams struct {
+	OrderID      uuid.UUID
+	IssuedAt     time.Time
+	FiscalNumber string
+	Document     json.RawMessage
 }
 
-func (p *Postgres) RemoveCacheOrderAsReady(ctx context.Context, cacheOrderID uuid.UUID) error {
-	return p.db.WithContext(ctx).Unscoped().Where("id = ?", cacheOrderID).Delete(&CacheOrder{}).Error
+func (p *Postgres) SaveReceipt(ctx context.Context, params SaveReceiptParams) error {
+	receipt := &Receipt{
+		ID:           params.OrderID,
+		IssuedAt:     params.IssuedAt,
+		FiscalNumber: params.FiscalNumber,
+		Document:     string(params.Document),
+	}
+
+	return p.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(receipt).Error
 }
 
 type LogAttemptToEnterWrongPINCodeParams struct {
 	ID      uuid.UUID
 	Reason  string
 	OrderID uuid.UUID
+	Outbox  Outbox
 }
 
 func (p *Postgres) LogAttemptToEnterWrongPINCode(ctx context.Context, pp LogAttemptToEnterWrongPINCodeParams) error {
@@ -237,7 +407,7 @@ func (p *Postgres) LogAttemptToEnterWrongPINCode(ctx context.Context, pp LogAtte
 		Text:    pp.Reason,
 		OrderID: pp.OrderID,
 	}
-	return p.db.WithContext(ctx).Create(item).Error
+	return p.createLogItem(ctx, item, pp.Outbox)
 }
 
 type UserResponse struct {
@@ -246,9 +416,10 @@ type UserResponse struct {
 }
 
 type ItemResponse struct {
-	ID    uuid.UUID
-	Title string
-	Price float64
+	ID       uuid.UUID
+	Title    string
+	Price    int64
+	Currency string
 }
 
 type PointResponse struct {
@@ -265,16 +436,18 @@ type MenuResponse struct {
 }
 
 func (p *Postgres) GetMenu(ctx context.Context) (*MenuResponse, error) {
+	db := p.reader().WithContext(ctx)
+
 	users := make([]*User, 0)
-	if err := p.db.WithContext(ctx).Order("id").Find(&users).Error; err != nil {
+	if err := db.Order("id").Find(&users).Error; err != nil {
 		return nil, err
 	}
 	items := make([]*Item, 0)
-	if err := p.db.WithContext(ctx).Order("id").Find(&items).Error; err != nil {
+	if err := db.Order("id").Find(&items).Error; err != nil {
 		return nil, err
 	}
 	points := make([]*Point, 0)
-	if err := p.db.WithContext(ctx).Order("id").Find(&points).Error; err != nil {
+	if err := db.Order("id").Find(&points).Error; err != nil {
 		return nil, err
 	}
 
@@ -293,9 +466,10 @@ func (p *Postgres) GetMenu(ctx context.Context) (*MenuResponse, error) {
 
 	for _, item := range items {
 		menu.Items = append(menu.Items, &ItemResponse{
-			ID:    item.ID,
-			Title: item.Title,
-			Price: item.Price,
+			ID:       item.ID,
+			Title:    item.Title,
+			Price:    item.Price,
+			Currency: item.Currency,
 		})
 	}
 
@@ -318,10 +492,14 @@ type KitchenCookItemResponse struct {
 	OrderID  uuid.UUID
 }
 
+// ListKitchenCookItems читает с основной базы, а не с реплики. Экран кухни
+// перечитывает список только по уведомлению, и если уведомление обгонит
+// реплику, то экран останется старым до следующего, которого для последнего
+// заказа может и не быть.
 func (p *Postgres) ListKitchenCookItems(ctx context.Context, kitchenID uuid.UUID) ([]*KitchenCookItemResponse, error) {
 	cookItems := make([]*CookItem, 0)
 	if err := p.db.WithContext(ctx).Order("created_at").
-		Where("kitchen_id = ?", kitchenID).
+		Where("kitchen_id = ? AND ready_at IS NULL AND removed_at IS NULL", kitchenID).
 		Find(&cookItems).Error; err != nil {
 
 		return nil, err
@@ -349,10 +527,11 @@ type CacheOrderResponse struct {
 	CheckList        string
 }
 
+// ListCacheOrders читает с основной базы, как и ListKitchenCookItems.
 func (p *Postgres) ListCacheOrders(ctx context.Context, cacheID uuid.UUID) ([]*CacheOrderResponse, error) {
 	cacheOrders := make([]*CacheOrder, 0)
 	if err := p.db.WithContext(ctx).Order("created_at").
-		Where("cache_id = ?", cacheID).
+		Where("cache_id = ? AND handed_over_at IS NULL AND removed_at IS NULL", cacheID).
 		Find(&cacheOrders).Error; err != nil {
 
 		return nil, err
@@ -372,3 +551,114 @@ func (p *Postgres) ListCacheOrders(ctx context.Context, cacheID uuid.UUID) ([]*C
 
 	return res, nil
 }
+
+// HistoryCursor с какого места читать историю терминала: время завершения и
+// id последней строки прошлой страницы. Строки с тем же временем различаются
+// по id, поэтому на границе страниц ничего не теряется. Без ID берутся строки
+// строго раньше At, пустой курсор это первая страница.
+type HistoryCursor struct {
+	At time.Time
+	ID uuid.UUID
+}
+
+// historyBefore оставляет строки после курсора в порядке column DESC, id DESC.
+func historyBefore(query *gorm.DB, column string, before HistoryCursor) *gorm.DB {
+	switch {
+	case before.At.IsZero():
+		return query
+	case before.ID == uuid.Nil:
+		return query.Where(column+" < ?", before.At)
+	}
+	return query.Where("("+column+", id) < (?, ?)", before.At, before.ID)
+}
+
+type KitchenCookItemHistoryResponse struct {
+	ID        uuid.UUID
+	Title     string
+	Quantity  float64
+	OrderID   uuid.UUID
+	CreatedAt time.Time
+	ReadyAt   time.Time
+}
+
+// ListKitchenCookItemsHistory приготовленные на кухне позиции от новых к
+// старым, после курсора before.
+func (p *Postgres) ListKitchenCookItemsHistory(ctx context.Context, kitchenID uuid.UUID, before HistoryCursor, limit int) ([]*KitchenCookItemHistoryResponse, error) {
+	query := historyBefore(p.reader().WithContext(ctx).
+		Where("kitchen_id = ? AND ready_at IS NOT NULL", kitchenID), "ready_at", before)
+
+	cookItems := make([]*CookItem, 0)
+	if err := query.Order("ready_at DESC, id DESC").Limit(limit).Find(&cookItems).Error; err != nil {
+		return nil, err
+	}
+
+	res := make([]*KitchenCookItemHistoryResponse, 0)
+	for _, cookItem := range cookItems {
+		res = append(res, &KitchenCookItemHistoryResponse{
+			ID:        cookItem.ID,
+			Title:     cookItem.Title,
+			Quantity:  cookItem.Quantity,
+			OrderID:   cookItem.OrderID,
+			CreatedAt: cookItem.CreatedAt,
+			ReadyAt:   *cookItem.ReadyAt,
+		})
+	}
+
+	return res, nil
+}
+
+type CacheOrderHistoryResponse struct {
+	ID           uuid.UUID
+	OrderID      uuid.UUID
+	UserName     string
+	CheckList    string
+	CreatedAt    time.Time
+	HandedOverAt time.Time
+}
+
+// ListCacheOrdersHistory выданные на кассе заказы от новых к старым, после
+// курсора before.
+func (p *Postgres) ListCacheOrdersHistory(ctx context.Context, cacheID uuid.UUID, before HistoryCursor, limit int) ([]*CacheOrderHistoryResponse, error) {
+	query := historyBefore(p.reader().WithContext(ctx).
+		Where("cache_id = ? AND handed_over_at IS NOT NULL", cacheID), "handed_over_at", before)
+
+	cacheOrders := make([]*CacheOrder, 0)
+	if err := query.Order("handed_over_at DESC, id DESC").Limit(limit).Find(&cacheOrders).Error; err != nil {
+		return nil, err
+	}
+
+	res := make([]*CacheOrderHistoryResponse, 0)
+	for _, order := range cacheOrders {
+		res = append(res, &CacheOrderHistoryResponse{
+			ID:           order.ID,
+			OrderID:      order.OrderID,
+			UserName:     order.UserName,
+			CheckList:    order.CheckList,
+			CreatedAt:    order.CreatedAt,
+			HandedOverAt: *order.HandedOverAt,
+		})
+	}
+
+	return res, nil
+}
+
+type ReceiptResponse struct {
+	OrderID      uuid.UUID
+	IssuedAt     time.Time
+	FiscalNumber string
+	Document     json.RawMessage
+}
+
+func (p *Postgres) GetReceipt(ctx context.Context, orderID uuid.UUID) (*ReceiptResponse, error) {
+	receipt := new(Receipt)
+	if err := p.db.WithContext(ctx).Take(receipt, orderID).Error; err != nil {
+		return nil, err
+	}
+
+	return &ReceiptResponse{
+		OrderID:      receipt.ID,
+		IssuedAt:     receipt.IssuedAt,
+		FiscalNumber: receipt.FiscalNumber,
+		Document:     json.RawMessage(receipt.Document),
+	}, nil
+}
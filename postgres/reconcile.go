@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetOrders заказы по идентификаторам вместе с позициями, логами,
+// пользователем и точкой.
+func (p *Postgres) GetOrders(ctx context.Context, ids []uuid.UUID) ([]*Order, error) {
+	orders := make([]*Order, 0)
+	if len(ids) == 0 {
+		return orders, nil
+	}
+
+	if err := p.db.WithContext(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("title, id") }).
+		Preload("LogItems", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") }).
+		Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("at, id") }).
+		Preload("User").
+		Preload("Point").
+		Where("id IN ?", ids).
+		Order("created_at, id").
+		Find(&orders).Error; err != nil {
+
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// LeftoverOrderIDs заказы, которые ещё висят на экранах кухни или кассы.
+type LeftoverOrderIDs struct {
+	CookItemOrders []uuid.UUID
+	CacheOrders    []uuid.UUID
+}
+
+func (p *Postgres) ListLeftoverOrderIDs(ctx context.Context) (*LeftoverOrderIDs, error) {
+	leftovers := new(LeftoverOrderIDs)
+
+	if err := p.db.WithContext(ctx).
+		Model(&CookItem{}).
+		Where("ready_at IS NULL AND removed_at IS NULL").
+		Distinct("order_id").
+		Order("order_id").
+		Pluck("order_id", &leftovers.CookItemOrders).Error; err != nil {
+
+		return nil, err
+	}
+
+	if err := p.db.WithContext(ctx).
+		Model(&CacheOrder{}).
+		Where("handed_over_at IS NULL AND removed_at IS NULL").
+		Order("order_id").
+		Pluck("order_id", &leftovers.CacheOrders).Error; err != nil {
+
+		return nil, err
+	}
+
+	return leftovers, nil
+}
+
+// RemoveOrderLeftovers убирает заказ с экранов кухни и кассы. Заказ не
+// приготовили и не выдали, поэтому строки отмечаются removed_at и в историю
+// приготовленного и выданного не попадают.
+func (p *Postgres) RemoveOrderLeftovers(ctx context.Context, orderID uuid.UUID) error {
+	now := time.Now()
+
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(CookItem{}).
+			Where("order_id = ? AND ready_at IS NULL AND removed_at IS NULL", orderID).
+			Update("removed_at", now).Error; err != nil {
+
+			return err
+		}
+
+		return tx.Model(CacheOrder{}).
+			Where("order_id = ? AND handed_over_at IS NULL AND removed_at IS NULL", orderID).
+			Update("removed_at", now).Error
+	})
+}
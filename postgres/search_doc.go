@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+)
+
+// SearchDoc документ для поиска по заказу из базы. Он должен совпадать с тем,
+// что индексирует OrderWorkflow. Позиции, логи, история статусов, пользователь
+// и точка должны быть загружены. Версия берётся последняя записанная в outbox,
+// иначе после переиндексации запоздавшие записи outbox затрут документ.
+func (order *Order) SearchDoc() *elasticsearch.Order {
+	doc := &elasticsearch.Order{
+		ID:             order.ID.String(),
+		CreatedAt:      order.CreatedAt.Format(time.RFC3339),
+		Status:         order.Status,
+		TotalPrice:     order.TotalPrice,
+		Currency:       order.Currency,
+		VATAmount:      order.VATAmount,
+		GiftCardAmount: order.GiftCardAmount,
+		PINCode:        order.PINCode,
+		Version:        order.PublishedVersion,
+	}
+
+	if order.User != nil {
+		doc.User = &elasticsearch.User{
+			ID:   order.User.ID.String(),
+			Name: order.User.Name,
+		}
+	}
+
+	if order.Point != nil {
+		doc.Point = &elasticsearch.Point{
+			ID:        order.Point.ID.String(),
+			Addr:      order.Point.Addr,
+			KitchenID: order.Point.KitchenID.String(),
+			CacheID:   order.Point.CacheID.String(),
+		}
+	}
+
+	for _, item := range order.Items {
+		doc.Items = append(doc.Items, &elasticsearch.OrderItem{
+			ID:         item.ID.String(),
+			Title:      item.Title,
+			Price:      item.Price,
+			ItemID:     item.ItemID.String(),
+			Quantity:   item.Quantity,
+			TotalPrice: item.TotalPrice,
+			VATRate:    item.VATRate,
+			VATAmount:  item.VATAmount,
+			OrderID:    order.ID.String(),
+		})
+	}
+
+	for _, logItem := range order.LogItems {
+		doc.LogItems = append(doc.LogItems, &elasticsearch.LogItem{
+			ID:      logItem.ID.String(),
+			Text:    logItem.Text,
+			OrderID: order.ID.String(),
+		})
+	}
+
+	for _, event := range order.Events {
+		doc.Timeline = append(doc.Timeline, &elasticsearch.TimelineEvent{
+			Status:  event.Status,
+			At:      event.At.Format(time.RFC3339),
+			Actor:   event.Actor,
+			Payload: json.RawMessage(event.Payload),
+		})
+	}
+
+	return doc
+}
@@ -0,0 +1,35 @@
+package postgres
+
+import "github.com/krocos/coffee-shop/money"
+
+// Seed пользователи, меню и точки для разработки.
+type Seed struct {
+	Users  []*User
+	Items  []*Item
+	Points []*Point
+}
+
+// DevSeed данные, которые cmd/seed пишет в базу, а cmd/devstack держит в
+// памяти. Идентификаторы пустые, их назначает хранилище.
+func DevSeed() Seed {
+	return Seed{
+		Users: []*User{
+			{Name: "Иван Иванович"},
+			{Name: "Ватева Ватевович"},
+			{Name: "Василий Петрович"},
+		},
+		Items: []*Item{
+			{Title: "Латте", Price: 9550, Currency: money.DefaultCurrency, VATRate: 20},
+			{Title: "Латте c сиропом", Price: 10550, Currency: money.DefaultCurrency, VATRate: 20},
+			{Title: "Еспрессо", Price: 8995, Currency: money.DefaultCurrency, VATRate: 20},
+			{Title: "Двойной еспрессо", Price: 11545, Currency: money.DefaultCurrency, VATRate: 20},
+			{Title: "Ристретто", Price: 7495, Currency: money.DefaultCurrency, VATRate: 20},
+			{Title: "Пончики", Price: 4995, Currency: money.DefaultCurrency, VATRate: 10},
+		},
+		Points: []*Point{
+			{Addr: "Татищева 49"},
+			{Addr: "Академика Бардина 32/1"},
+			{Addr: "Банковский переулок 10"},
+		},
+	}
+}
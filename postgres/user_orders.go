@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/krocos/coffee-shop/elasticsearch"
+)
+
+const SourcePostgres = "postgres"
+
+// ListUserOrders отдаёт заказы пользователя в том же виде и порядке, что и
+// поиск, когда эластик недоступен. Поиск хранит время создания с точностью до
+// секунды, поэтому и здесь сортируем по секундам, иначе курсоры источников
+// не совпадут.
+func (p *Postgres) ListUserOrders(ctx context.Context, params elasticsearch.ListUserOrdersParams) (*elasticsearch.UserOrdersPage, error) {
+	query := p.db.WithContext(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("title, id") }).
+		Preload("LogItems", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") }).
+		Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("at, id") }).
+		Preload("User").
+		Preload("Point").
+		Where("user_id = ?", params.UserID)
+
+	if len(params.Statuses) > 0 {
+		query = query.Where("status IN ?", params.Statuses)
+	}
+	if params.PointID != uuid.Nil {
+		query = query.Where("point_id = ?", params.PointID)
+	}
+	if !params.From.IsZero() {
+		query = query.Where("created_at >= ?", params.From)
+	}
+	if !params.To.IsZero() {
+		query = query.Where("created_at < ?", params.To)
+	}
+
+	if params.Cursor != "" {
+		createdAt, id, err := elasticsearch.DecodeOrderCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		orderID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, elasticsearch.ErrBadCursor
+		}
+		query = query.Where("(date_trunc('second', created_at), id) < (?, ?)", createdAt, orderID)
+	}
+
+	// Берём на один больше, что бы понять, есть ли следующая страница.
+	orders := make([]*Order, 0)
+	if err := query.
+		Order("date_trunc('second', created_at) DESC, id DESC").
+		Limit(params.Limit + 1).
+		Find(&orders).Error; err != nil {
+
+		return nil, err
+	}
+
+	page := &elasticsearch.UserOrdersPage{
+		Orders: make([]json.RawMessage, 0),
+		Source: SourcePostgres,
+	}
+
+	if len(orders) > params.Limit {
+		orders = orders[:params.Limit]
+		last := orders[len(orders)-1]
+
+		var err error
+		if page.NextCursor, err = elasticsearch.EncodeOrderCursor(last.CreatedAt.Truncate(time.Second), last.ID.String()); err != nil {
+			return nil, fmt.Errorf("encode cursor: %v", err)
+		}
+	}
+
+	for _, order := range orders {
+		bb, err := json.Marshal(order.SearchDoc())
+		if err != nil {
+			return nil, fmt.Errorf("marshal order %s: %v", order.ID, err)
+		}
+		page.Orders = append(page.Orders, bb)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+func (p *Postgres) ListPoints(ctx context.Context) ([]PointData, error) {
+	points := make([]*Point, 0)
+	if err := p.db.WithContext(ctx).Order("id").Find(&points).Error; err != nil {
+		return nil, err
+	}
+
+	list := make([]PointData, 0)
+	for _, point := range points {
+		list = append(list, PointData{
+			ID:        point.ID,
+			Addr:      point.Addr,
+			KitchenID: point.KitchenID,
+			CacheID:   point.CacheID,
+			Timezone:  point.Timezone,
+		})
+	}
+
+	return list, nil
+}
+
+type CreateZReportParams struct {
+	PointID  uuid.UUID
+	Day      string // YYYY-MM-DD в часовом поясе точки
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+type ZReportResponse struct {
+	PointID         uuid.UUID
+	Day             string
+	StartsAt        time.Time
+	EndsAt          time.Time
+	Currency        string
+	OrdersCount     int64
+	Revenue         int64
+	CanceledOrders  int64
+	PaymentTimeouts int64
+	AbandonedOrders int64
+	AvgCookSeconds  int64
+	CreatedAt       time.Time
+}
+
+func newZReportResponse(report *ZReport) *ZReportResponse {
+	return &ZReportResponse{
+		PointID:         report.PointID,
+		Day:             report.Day,
+		StartsAt:        report.StartsAt,
+		EndsAt:          report.EndsAt,
+		Currency:        report.Currency,
+		OrdersCount:     report.OrdersCount,
+		Revenue:         report.Revenue,
+		CanceledOrders:  report.CanceledOrders,
+		PaymentTimeouts: report.PaymentTimeouts,
+		AbandonedOrders: report.AbandonedOrders,
+		AvgCookSeconds:  report.AvgCookSeconds,
+		CreatedAt:       report.CreatedAt,
+	}
+}
+
+// CreateZReport считает итоги дня по заказам точки и сохраняет их. Если отчёт
+// за этот день уже есть, то он пересчитывается.
+//
+// Выручка это оплаченные заказы. Отменённые заказы отменили до оплаты, денег
+// по ним не брали, поэтому они считаются отдельно, а не возвратами. Возвратов в
+// отчёте нет, пока нет возврата денег в процессе. Брошенные заказы это те, что
+// приготовили, но так и не забрали.
+func (p *Postgres) CreateZReport(ctx context.Context, params CreateZReportParams) (*ZReportResponse, error) {
+	var totals struct {
+		Currency        string
+		OrdersCount     int64
+		Revenue         int64
+		CanceledOrders  int64
+		PaymentTimeouts int64
+		AbandonedOrders int64
+		AvgCookSeconds  float64
+	}
+
+	// Время готовки берём из истории статусов: от начала готовки до готовности.
+	if err := p.db.WithContext(ctx).Raw(`SELECT
+			coalesce(max(o.currency), 'RUB') AS currency,
+			count(*) AS orders_count,
+			coalesce(sum(o.total_price) FILTER (WHERE o.status IN ('paid', 'cooking', 'ready', 'received')), 0) AS revenue,
+			count(*) FILTER (WHERE o.status = 'payment_canceled') AS canceled_orders,
+			count(*) FILTER (WHERE o.status = 'payment_timeout') AS payment_timeouts,
+			count(*) FILTER (WHERE o.status = 'ready') AS abandoned_orders,
+			coalesce(avg(extract(epoch FROM ready.at - cooking.at)) FILTER (WHERE ready.at IS NOT NULL AND cooking.at IS NOT NULL), 0) AS avg_cook_seconds
+		FROM orders o
+		LEFT JOIN order_events cooking ON cooking.order_id = o.id AND cooking.status = 'cooking'
+		LEFT JOIN order_events ready ON ready.order_id = o.id AND ready.status = 'ready'
+		WHERE o.point_id = ? AND o.created_at >= ? AND o.created_at < ?`,
+		params.PointID, params.StartsAt, params.EndsAt).Scan(&totals).Error; err != nil {
+
+		return nil, fmt.Errorf("aggregate orders of point %s for %s: %v", params.PointID, params.Day, err)
+	}
+
+	report := &ZReport{
+		PointID:         params.PointID,
+		Day:             params.Day,
+		StartsAt:        params.StartsAt,
+		EndsAt:          params.EndsAt,
+		Currency:        totals.Currency,
+		OrdersCount:     totals.OrdersCount,
+		Revenue:         totals.Revenue,
+		CanceledOrders:  totals.CanceledOrders,
+		PaymentTimeouts: totals.PaymentTimeouts,
+		AbandonedOrders: totals.AbandonedOrders,
+		AvgCookSeconds:  int64(totals.AvgCookSeconds),
+	}
+
+	if err := p.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "point_id"}, {Name: "day"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"starts_at", "ends_at", "currency", "orders_count", "revenue", "canceled_orders",
+			"payment_timeouts", "abandoned_orders", "avg_cook_seconds",
+		}),
+	}).Create(report).Error; err != nil {
+		return nil, fmt.Errorf("save z-report of point %s for %s: %v", params.PointID, params.Day, err)
+	}
+
+	return newZReportResponse(report), nil
+}
+
+func (p *Postgres) GetZReport(ctx context.Context, pointID uuid.UUID, day string) (*ZReportResponse, error) {
+	report := new(ZReport)
+	if err := p.reader().WithContext(ctx).
+		Where("point_id = ? AND day = ?", pointID, day).
+		Take(report).Error; err != nil {
+
+		return nil, err
+	}
+
+	return newZReportResponse(report), nil
+}
+
+func (p *Postgres) ListZReports(ctx context.Context, pointID uuid.UUID, limit int) ([]*ZReportResponse, error) {
+	reports := make([]*ZReport, 0)
+	if err := p.reader().WithContext(ctx).
+		Where("point_id = ?", pointID).
+		Order("day DESC").
+		Limit(limit).
+		Find(&reports).Error; err != nil {
+
+		return nil, err
+	}
+
+	res := make([]*ZReportResponse, 0)
+	for _, report := range reports {
+		res = append(res, newZReportResponse(report))
+	}
+
+	return res, nil
+}
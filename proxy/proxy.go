@@ -5,15 +5,19 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+
+	"github.com/krocos/coffee-shop/config"
 )
 
-func CreateServer(port string) *http.Server {
-	apiServerURL, err := url.Parse("http://localhost:8888")
+// CreateServer сервер клиента на addr, который отдаёт само приложение через
+// ui, а запросы к апи и уведомлениям проксирует на серверы из cfg.
+func CreateServer(addr string, cfg *config.Config, ui http.Handler) *http.Server {
+	apiServerURL, err := url.Parse(cfg.API.URL)
 	if err != nil {
 		panic(err)
 	}
 
-	sseServerURL, err := url.Parse("http://localhost:7995")
+	sseServerURL, err := url.Parse(cfg.SSE.URL)
 	if err != nil {
 		panic(err)
 	}
@@ -22,14 +26,17 @@ func CreateServer(port string) *http.Server {
 	sseServer := httputil.NewSingleHostReverseProxy(sseServerURL)
 
 	return &http.Server{
-		Addr: port,
+		Addr: addr,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 			switch {
 			case strings.HasPrefix(r.URL.Path, "/user-api") ||
 				strings.HasPrefix(r.URL.Path, "/payment-gateway-api") ||
 				strings.HasPrefix(r.URL.Path, "/kitchen-api") ||
-				strings.HasPrefix(r.URL.Path, "/cache-api"):
+				strings.HasPrefix(r.URL.Path, "/cache-api") ||
+				strings.HasPrefix(r.URL.Path, "/support-api") ||
+				strings.HasPrefix(r.URL.Path, "/manager-api") ||
+				strings.HasPrefix(r.URL.Path, "/admin-api"):
 
 				apiServer.ServeHTTP(w, r)
 			case strings.HasPrefix(r.URL.Path, "/user") ||
@@ -38,7 +45,7 @@ func CreateServer(port string) *http.Server {
 
 				sseServer.ServeHTTP(w, r)
 			default:
-				http.DefaultServeMux.ServeHTTP(w, r)
+				ui.ServeHTTP(w, r)
 			}
 		}),
 	}
@@ -0,0 +1,416 @@
+// Package reconcile сверяет состояние заказов, которое размазано по воркфлоу,
+// таблицам заказов, кухни и кассы и поиску, и чинит расхождения.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+
+	"github.com/krocos/coffee-shop/backend"
+	"github.com/krocos/coffee-shop/elasticsearch"
+	"github.com/krocos/coffee-shop/postgres"
+)
+
+const (
+	KindMissingInSearch  = "missing_in_search"
+	KindStatus           = "status"
+	KindTotalPrice       = "total_price"
+	KindGiftCardAmount   = "gift_card_amount"
+	KindLogItems         = "log_items"
+	KindWorkflowStatus   = "workflow_status"
+	KindOrphanCookItems  = "orphan_cook_items"
+	KindOrphanCacheOrder = "orphan_cache_order"
+)
+
+const batchSize = 200
+
+// finalStatuses статусы, после которых воркфлоу заказа завершается. Готовый,
+// но не забранный заказ тоже остаётся в статусе ready.
+var finalStatuses = map[string]bool{
+	postgres.OrderStatusPaymentTimeout:  true,
+	postgres.OrderStatusPaymentCanceled: true,
+	postgres.OrderStatusReady:           true,
+	postgres.OrderStatusReceived:        true,
+}
+
+type Mismatch struct {
+	OrderID     uuid.UUID
+	Kind        string
+	Details     string
+	Repaired    bool
+	RepairError string
+}
+
+type Report struct {
+	CheckedOrders int
+	Mismatches    []*Mismatch
+}
+
+// Unrepaired сколько расхождений осталось.
+func (r *Report) Unrepaired() int {
+	n := 0
+	for _, m := range r.Mismatches {
+		if !m.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+type Params struct {
+	// Since сверяются заказы, созданные после этого времени.
+	Since time.Time
+	// Repair чинить найденные расхождения, иначе только отчёт.
+	Repair bool
+	// Settle через сколько перепроверить расхождения. Воркфлоу пишет в базу и
+	// в поиск по очереди, и в моменте они могут расходиться без всякой ошибки.
+	Settle time.Duration
+}
+
+// Reconciler активити сверки, его же использует cmd/reconcile.
+type Reconciler struct {
+	storage *postgres.Postgres
+	search  *elasticsearch.Search
+	client  client.Client
+}
+
+func NewReconciler(storage *postgres.Postgres, search *elasticsearch.Search, client client.Client) *Reconciler {
+	return &Reconciler{
+		storage: storage,
+		search:  search,
+		client:  client,
+	}
+}
+
+// workflowInfo что известно о воркфлоу заказа. state есть только если
+// воркфлоу ответил на запрос.
+type workflowInfo struct {
+	found    bool
+	running  bool
+	closedAt time.Time
+	state    *backend.OrderState
+}
+
+func (r *Reconciler) describeWorkflow(ctx context.Context, orderID uuid.UUID) (*workflowInfo, error) {
+	res, err := r.client.DescribeWorkflowExecution(ctx, backend.OrderWorkflowID(orderID), "")
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			return &workflowInfo{}, nil
+		}
+		return nil, fmt.Errorf("describe workflow of order %s: %v", orderID, err)
+	}
+
+	info := &workflowInfo{
+		found:   true,
+		running: res.WorkflowExecutionInfo.Status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING,
+	}
+	if res.WorkflowExecutionInfo.CloseTime != nil {
+		info.closedAt = *res.WorkflowExecutionInfo.CloseTime
+	}
+
+	return info, nil
+}
+
+// queryState спрашивает у воркфлоу состояние заказа. Завершённый воркфлоу тоже
+// отвечает, если его история ещё хранится.
+func (r *Reconciler) queryState(ctx context.Context, orderID uuid.UUID) (*backend.OrderState, error) {
+	value, err := r.client.QueryWorkflow(ctx, backend.OrderWorkflowID(orderID), "", backend.OrderStateQuery)
+	if err != nil {
+		return nil, fmt.Errorf("query state of order %s: %v", orderID, err)
+	}
+
+	state := new(backend.OrderState)
+	if err = value.Get(state); err != nil {
+		return nil, fmt.Errorf("decode state of order %s: %v", orderID, err)
+	}
+
+	return state, nil
+}
+
+// Reconcile сверяет заказы, созданные после params.Since, и списки кухни и
+// кассы. С params.Repair расхождения чинятся: для живого воркфлоу правдой
+// считается он, иначе база, а поиск перестраивается из базы.
+func (r *Reconciler) Reconcile(ctx context.Context, params Params) (*Report, error) {
+	report := &Report{Mismatches: make([]*Mismatch, 0)}
+
+	suspicious := make([]uuid.UUID, 0)
+
+	cursor := postgres.OrderCursor{CreatedAt: params.Since}
+	for {
+		orders, err := r.storage.ListOrdersBatch(ctx, cursor, batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("list orders: %v", err)
+		}
+		if len(orders) == 0 {
+			break
+		}
+
+		mismatches, err := r.checkOrders(ctx, orders)
+		if err != nil {
+			return nil, err
+		}
+		for orderID := range mismatches {
+			suspicious = append(suspicious, orderID)
+		}
+
+		report.CheckedOrders += len(orders)
+
+		last := orders[len(orders)-1]
+		cursor = postgres.OrderCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	if err := r.sleep(ctx, params.Settle); err != nil {
+		return nil, err
+	}
+
+	// Перепроверяем только то, что разошлось на первом проходе.
+	for start := 0; start < len(suspicious); start += batchSize {
+		end := start + batchSize
+		if end > len(suspicious) {
+			end = len(suspicious)
+		}
+
+		orders, err := r.storage.GetOrders(ctx, suspicious[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("get orders: %v", err)
+		}
+
+		mismatches, err := r.checkOrders(ctx, orders)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, order := range orders {
+			found, ok := mismatches[order.ID]
+			if !ok {
+				continue
+			}
+			if params.Repair {
+				r.repairOrder(ctx, order, found)
+			}
+			report.Mismatches = append(report.Mismatches, found.list...)
+		}
+	}
+
+	leftovers, err := r.checkLeftovers(ctx, params.Settle)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range leftovers {
+		if params.Repair {
+			if err = r.storage.RemoveOrderLeftovers(ctx, m.OrderID); err != nil {
+				m.RepairError = err.Error()
+			} else {
+				m.Repaired = true
+			}
+		}
+		report.Mismatches = append(report.Mismatches, m)
+	}
+
+	return report, nil
+}
+
+func (r *Reconciler) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// orderMismatches расхождения по одному заказу и то, что нужно для починки.
+type orderMismatches struct {
+	list     []*Mismatch
+	doc      *elasticsearch.StoredOrder
+	workflow *workflowInfo
+}
+
+func (m *orderMismatches) add(orderID uuid.UUID, kind, format string, args ...interface{}) {
+	m.list = append(m.list, &Mismatch{
+		OrderID: orderID,
+		Kind:    kind,
+		Details: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *Reconciler) checkOrders(ctx context.Context, orders []*postgres.Order) (map[uuid.UUID]*orderMismatches, error) {
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID.String())
+	}
+
+	docs, err := r.search.GetOrders(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[uuid.UUID]*orderMismatches)
+	for _, order := range orders {
+		found, err := r.checkOrder(ctx, order, docs[order.ID.String()])
+		if err != nil {
+			return nil, err
+		}
+		if len(found.list) > 0 {
+			res[order.ID] = found
+		}
+	}
+
+	return res, nil
+}
+
+func (r *Reconciler) checkOrder(ctx context.Context, order *postgres.Order, doc *elasticsearch.StoredOrder) (*orderMismatches, error) {
+	found := &orderMismatches{doc: doc}
+
+	if doc == nil {
+		found.add(order.ID, KindMissingInSearch, "order is in postgres but not in search")
+	} else {
+		if doc.Order.Status != order.Status {
+			found.add(order.ID, KindStatus, "postgres %s, search %s", order.Status, doc.Order.Status)
+		}
+		if doc.Order.TotalPrice != order.TotalPrice {
+			found.add(order.ID, KindTotalPrice, "postgres %d, search %d", order.TotalPrice, doc.Order.TotalPrice)
+		}
+		if doc.Order.GiftCardAmount != order.GiftCardAmount {
+			found.add(order.ID, KindGiftCardAmount, "postgres %d, search %d", order.GiftCardAmount, doc.Order.GiftCardAmount)
+		}
+		if len(doc.Order.LogItems) != len(order.LogItems) {
+			found.add(order.ID, KindLogItems, "postgres %d, search %d", len(order.LogItems), len(doc.Order.LogItems))
+		}
+	}
+
+	// Воркфлоу спрашиваем, только если он ещё идёт или если по базе заказ не
+	// закончен, а значит воркфлоу мог упасть, не дописав статус.
+	info, err := r.describeWorkflow(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	found.workflow = info
+
+	if !info.running && finalStatuses[order.Status] {
+		return found, nil
+	}
+	if !info.found {
+		found.add(order.ID, KindWorkflowStatus, "workflow not found, postgres %s", order.Status)
+		return found, nil
+	}
+
+	state, err := r.queryState(ctx, order.ID)
+	if err != nil {
+		found.add(order.ID, KindWorkflowStatus, "%v", err)
+		return found, nil
+	}
+	info.state = state
+
+	if state.Status != order.Status {
+		found.add(order.ID, KindWorkflowStatus, "workflow %s, postgres %s", state.Status, order.Status)
+	}
+	if doc != nil && state.Status != doc.Order.Status {
+		found.add(order.ID, KindWorkflowStatus, "workflow %s, search %s", state.Status, doc.Order.Status)
+	}
+
+	return found, nil
+}
+
+func (r *Reconciler) repairOrder(ctx context.Context, order *postgres.Order, found *orderMismatches) {
+	err := r.doRepairOrder(ctx, order, found)
+
+	for _, m := range found.list {
+		if err != nil {
+			m.RepairError = err.Error()
+		} else {
+			m.Repaired = true
+		}
+	}
+}
+
+func (r *Reconciler) doRepairOrder(ctx context.Context, order *postgres.Order, found *orderMismatches) error {
+	var version int64
+	if found.doc != nil {
+		version = found.doc.Order.Version
+	}
+
+	if state := found.workflow.state; state != nil {
+		if state.Status != order.Status {
+			if err := r.storage.UpdateOrderStatus(ctx, order.ID, state.Status); err != nil {
+				return fmt.Errorf("update status in postgres: %v", err)
+			}
+			order.Status = state.Status
+		}
+		if state.SearchVersion > version {
+			version = state.SearchVersion
+		}
+	} else {
+		// Воркфлоу не ответил, а без него не понять, какой статус верный.
+		for _, m := range found.list {
+			if m.Kind == KindWorkflowStatus {
+				return fmt.Errorf("workflow state is unknown, repair by hand")
+			}
+		}
+	}
+
+	// Версию сохраняем, что бы запоздавшие записи воркфлоу по-прежнему
+	// отбрасывались, а новые проходили. Поиск или воркфлоу могут знать версию
+	// новее записанной в outbox.
+	doc := order.SearchDoc()
+	if version > doc.Version {
+		doc.Version = version
+	}
+
+	if err := r.search.ReplaceOrder(ctx, doc); err != nil {
+		return fmt.Errorf("replace search document: %v", err)
+	}
+
+	return nil
+}
+
+// checkLeftovers находит строки кухни и кассы заказов, воркфлоу которых уже
+// завершился. Завершившиеся недавно (меньше settle назад) пропускаем.
+func (r *Reconciler) checkLeftovers(ctx context.Context, settle time.Duration) ([]*Mismatch, error) {
+	leftovers, err := r.storage.ListLeftoverOrderIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list kitchen and cache leftovers: %v", err)
+	}
+
+	res := make([]*Mismatch, 0)
+
+	check := func(orderIDs []uuid.UUID, kind string) error {
+		for _, orderID := range orderIDs {
+			info, err := r.describeWorkflow(ctx, orderID)
+			if err != nil {
+				return err
+			}
+			if info.running || (info.found && time.Since(info.closedAt) < settle) {
+				continue
+			}
+
+			details := "workflow is closed"
+			if !info.found {
+				details = "workflow not found"
+			}
+			res = append(res, &Mismatch{OrderID: orderID, Kind: kind, Details: details})
+		}
+		return nil
+	}
+
+	if err = check(leftovers.CookItemOrders, KindOrphanCookItems); err != nil {
+		return nil, err
+	}
+	if err = check(leftovers.CacheOrders, KindOrphanCacheOrder); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
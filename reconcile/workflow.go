@@ -0,0 +1,89 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const scheduleID = "reconcile"
+
+type WorkflowParams struct {
+	// Lookback за какой срок от запуска сверять заказы.
+	Lookback time.Duration
+	Repair   bool
+}
+
+// ReconcileWorkflow сверка по расписанию, то же самое, что делает cmd/reconcile.
+func ReconcileWorkflow(ctx workflow.Context, params WorkflowParams) (*Report, error) {
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 3},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	var reconciler *Reconciler
+
+	report := new(Report)
+	if err := workflow.ExecuteActivity(ctx, reconciler.Reconcile, Params{
+		Since:  workflow.Now(ctx).Add(-params.Lookback),
+		Repair: params.Repair,
+		Settle: 10 * time.Second,
+	}).Get(ctx, report); err != nil {
+		return nil, err
+	}
+
+	workflow.GetLogger(ctx).Info("reconcile finished",
+		"checked", report.CheckedOrders,
+		"mismatches", len(report.Mismatches),
+		"unrepaired", report.Unrepaired())
+
+	return report, nil
+}
+
+// EnsureSchedule заводит расписание сверки или обновляет его интервал.
+func EnsureSchedule(ctx context.Context, c client.Client, taskQueue string, every time.Duration, params WorkflowParams) error {
+	spec := client.ScheduleSpec{
+		Intervals: []client.ScheduleIntervalSpec{{Every: every}},
+	}
+
+	_, err := c.ScheduleClient().Create(ctx, client.ScheduleOptions{
+		ID:   scheduleID,
+		Spec: spec,
+		Action: &client.ScheduleWorkflowAction{
+			ID:        scheduleID,
+			Workflow:  ReconcileWorkflow,
+			Args:      []interface{}{params},
+			TaskQueue: taskQueue,
+		},
+	})
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return fmt.Errorf("create reconcile schedule: %v", err)
+	}
+
+	handle := c.ScheduleClient().GetHandle(ctx, scheduleID)
+	if err = handle.Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			input.Description.Schedule.Spec = &spec
+			input.Description.Schedule.Action = &client.ScheduleWorkflowAction{
+				ID:        scheduleID,
+				Workflow:  ReconcileWorkflow,
+				Args:      []interface{}{params},
+				TaskQueue: taskQueue,
+			}
+			return &client.ScheduleUpdate{Schedule: &input.Description.Schedule}, nil
+		},
+	}); err != nil {
+		return fmt.Errorf("update reconcile schedule: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	ssse "github.com/r3labs/sse/v2"
+	"github.com/rs/cors"
+)
+
+// Server раздаёт уведомления клиентам: пользователь, кухня и касса
+// подписываются каждый на свой поток, а события приходят в /send-event.
+type Server struct {
+	server *ssse.Server
+}
+
+func NewServer() *Server {
+	server := ssse.New()
+	server.AutoStream = true
+	server.AutoReplay = false
+	server.OnSubscribe = func(streamID string, sub *ssse.Subscriber) {
+		log.Println(fmt.Sprintf("connected to '%s' via '%s'", streamID, sub.URL.String()))
+	}
+	server.OnUnsubscribe = func(streamID string, sub *ssse.Subscriber) {
+		log.Println(fmt.Sprintf("disconnected to '%s' via '%s'", streamID, sub.URL.String()))
+	}
+
+	return &Server{server: server}
+}
+
+// Handler подписки клиентов и приём событий по http.
+func (s *Server) Handler() http.Handler {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/user/{user_id}", s.subscribe(clientTypeUser, "user_id"))
+	router.HandleFunc("/kitchen/{kitchen_id}", s.subscribe(clientTypeKitchen, "kitchen_id"))
+	router.HandleFunc("/cache/{cache_id}", s.subscribe(clientTypeCache, "cache_id"))
+
+	router.HandleFunc("/send-event", func(w http.ResponseWriter, r *http.Request) {
+		event := new(Event)
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		go s.publish(*event)
+	})
+
+	return cors.AllowAll().Handler(router)
+}
+
+func (s *Server) subscribe(clientType ClientType, idVar string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID, err := uuid.Parse(mux.Vars(r)[idVar])
+		if err != nil {
+			http.Error(w, fmt.Errorf("parse %s id: %v", clientType, err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		vv := r.URL.Query()
+		vv.Add("stream", fmt.Sprintf("%s:%s", clientType, clientID.String()))
+		r.URL.RawQuery = vv.Encode()
+
+		s.server.ServeHTTP(w, r)
+	}
+}
+
+// SendNotification публикует событие сразу, без http. Так сервер сам служит
+// уведомителем, когда всё запущено в одном процессе.
+func (s *Server) SendNotification(_ context.Context, event Event) error {
+	s.publish(event)
+	return nil
+}
+
+func (s *Server) publish(event Event) {
+	switch event.ClientType {
+	case clientTypeUser, clientTypeCache, clientTypeKitchen:
+		streamID := fmt.Sprintf("%s:%s", event.ClientType, event.ClientID.String())
+		s.server.Publish(streamID, &ssse.Event{Data: []byte(event.EventType)})
+	}
+}